@@ -15,6 +15,7 @@ import (
 	"github.com/AdityaSripal/token_curated_registry/types"
 	"github.com/tendermint/go-crypto"
 	"encoding/json"
+	"fmt"
 	"github.com/cosmos/cosmos-sdk/wire"
 )
 
@@ -28,17 +29,8 @@ type RegistryApp struct {
 
 	cdc *amino.Codec
 
-	minDeposit int64
-
-	applyStage int64
-
-	commitStage int64
-
-	revealStage int64
-
-	dispensationPct float64
-
-	quorum float64
+	// Params of the default registry created when genesis defines none
+	defaultParams types.RegistryParams
 
 	// keys to access the substores
 	capKeyMain *sdk.KVStoreKey
@@ -46,57 +38,108 @@ type RegistryApp struct {
 	capKeyListings *sdk.KVStoreKey
 	capKeyCommits *sdk.KVStoreKey
 	capKeyReveals *sdk.KVStoreKey
+	capKeyArchive *sdk.KVStoreKey
+	capKeyHistory *sdk.KVStoreKey
 	capKeyBallots *sdk.KVStoreKey
 	capKeyFees *sdk.KVStoreKey
+	capKeyRegistries *sdk.KVStoreKey
 
 	ballotMapper dbl.BallotMapper
+	registryMapper dbl.RegistryMapper
+	schemaMapper dbl.SchemaMapper
 
 	// Manage addition and subtraction of account balances
 	accountMapper auth.AccountMapper
 	accountKeeper bank.Keeper
 }
 
-func NewRegistryApp(logger log.Logger, db dbm.DB, mindeposit int64, applystage int64, commitstage int64, revealstage int64, dispensationpct float64, _quorum float64) *RegistryApp {
+func NewRegistryApp(logger log.Logger, db dbm.DB, defaultParams types.RegistryParams) *RegistryApp {
 	cdc := MakeCodec()
 	var app = &RegistryApp{
 		BaseApp: bam.NewBaseApp(appName, cdc, logger, db),
 		cdc: cdc,
-		minDeposit: mindeposit,
-		applyStage: applystage,
-		commitStage: commitstage,
-		revealStage: revealstage,
-		dispensationPct: dispensationpct,
-		quorum: _quorum,
+		defaultParams: defaultParams,
 		capKeyMain: sdk.NewKVStoreKey("main"),
 		capKeyAccount: sdk.NewKVStoreKey("acc"),
 		capKeyFees: sdk.NewKVStoreKey("fee"),
 		capKeyListings: sdk.NewKVStoreKey("listings"),
 		capKeyCommits: sdk.NewKVStoreKey("commits"),
 		capKeyReveals: sdk.NewKVStoreKey("reveals"),
+		capKeyArchive: sdk.NewKVStoreKey("archive"),
+		capKeyHistory: sdk.NewKVStoreKey("history"),
 		capKeyBallots: sdk.NewKVStoreKey("ballots"),
+		capKeyRegistries: sdk.NewKVStoreKey("registries"),
 	}
 
-	app.ballotMapper = dbl.NewBallotMapper(app.capKeyListings, app.capKeyBallots, app.capKeyCommits, app.capKeyReveals, app.cdc)
 	app.accountMapper = auth.NewAccountMapper(app.cdc, app.capKeyAccount, &auth.BaseAccount{})
 	app.accountKeeper =  bank.NewKeeper(app.accountMapper)
+	app.ballotMapper = dbl.NewBallotMapper(dbl.BallotKeys{
+		Listings: app.capKeyListings,
+		Ballots: app.capKeyBallots,
+		Commits: app.capKeyCommits,
+		Reveals: app.capKeyReveals,
+		Archive: app.capKeyArchive,
+		History: app.capKeyHistory,
+	}, app.cdc).WithBank(app.accountKeeper)
+	app.registryMapper = dbl.NewRegistryMapper(app.capKeyRegistries, app.cdc)
+	app.schemaMapper = dbl.NewSchemaMapper(app.capKeyMain, app.cdc)
+
+	// Registry msgs are handled with the params and namespace of the registry they name
+	forRegistry := func(build handle.RegistryHandlerBuilder) sdk.Handler {
+		return handle.NewRegistryHandler(app.registryMapper, app.ballotMapper, build)
+	}
 
 	app.Router().
-		AddRoute("DeclareCandidacy", handle.NewCandidacyHandler(app.accountKeeper, app.ballotMapper, app.minDeposit, app.applyStage)).
-		AddRoute("Challenge", handle.NewChallengeHandler(app.accountKeeper, app.ballotMapper, app.commitStage, app.revealStage, app.minDeposit)).
-		AddRoute("Commit", handle.NewCommitHandler(app.cdc, app.capKeyBallots, app.capKeyCommits)).
-		AddRoute("Reveal", handle.NewRevealHandler(app.accountKeeper, app.ballotMapper)).
-		AddRoute("Apply", handle.NewApplyHandler(app.accountKeeper, app.ballotMapper, app.capKeyListings, app.quorum, app.dispensationPct)).
-		AddRoute("ClaimReward", handle.NewClaimRewardHandler(app.cdc, app.accountKeeper, app.capKeyBallots, app.capKeyReveals, app.capKeyListings, app.dispensationPct))
+		AddRoute("CreateRegistry", handle.NewCreateRegistryHandler(app.registryMapper)).
+		AddRoute("DeclareCandidacy", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewCandidacyHandler(keeper, params.MinDeposit, params.ApplyLen, params.Fees)
+		})).
+		AddRoute("Challenge", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewChallengeHandler(keeper, params.CommitLen, params.RevealLen, params.MinDeposit)
+		})).
+		AddRoute("Commit", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewCommitHandler(keeper)
+		})).
+		AddRoute("Reveal", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewRevealHandler(keeper)
+		})).
+		AddRoute("Apply", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewApplyHandler(keeper, params.Quorum, params.DispensationPct, params.Appeal)
+		})).
+		AddRoute("Appeal", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewAppealHandler(keeper, params.Appeal)
+		})).
+		AddRoute("AppealDecision", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewAppealDecisionHandler(keeper, params.DispensationPct, params.Appeal)
+		})).
+		AddRoute("ClaimReward", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewClaimRewardHandler(keeper)
+		})).
+		AddRoute("ClaimAllRewards", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewClaimAllRewardsHandler(keeper)
+		})).
+		AddRoute("DelegateVoting", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewDelegateVotingHandler(keeper)
+		})).
+		AddRoute("RenewListing", forRegistry(func(keeper dbl.Keeper, params types.RegistryParams) sdk.Handler {
+			return handle.NewRenewListingHandler(keeper, params.Fees)
+		}))
 
 	app.SetTxDecoder(app.txDecoder)
 	app.SetInitChainer(app.initChainer)
-	app.MountStoresIAVL(app.capKeyMain, app.capKeyAccount, app.capKeyFees, app.capKeyListings, app.capKeyCommits, app.capKeyReveals, app.capKeyBallots)
+	app.SetBeginBlocker(handle.NewBeginBlocker(app.schemaMapper, app.schemaStores(), haltNode))
+	app.SetEndBlocker(handle.NewEndBlocker(app.registryMapper, app.ballotMapper))
+	app.MountStoresIAVL(app.capKeyMain, app.capKeyAccount, app.capKeyFees, app.capKeyListings, app.capKeyCommits, app.capKeyReveals, app.capKeyArchive, app.capKeyHistory, app.capKeyBallots, app.capKeyRegistries)
 	app.SetAnteHandler(handle.NewAnteHandler(app.accountMapper))
 
 	err := app.LoadLatestVersion(app.capKeyMain)
 	if err != nil {
 		cmn.Exit(err.Error())
 	}
+	err = app.checkSchema()
+	if err != nil {
+		cmn.Exit(err.Error())
+	}
 
 
 	return app
@@ -105,6 +148,9 @@ func NewRegistryApp(logger log.Logger, db dbm.DB, mindeposit int64, applystage i
 func (app *RegistryApp) initChainer(ctx sdk.Context, req abci.RequestInitChain) abci.ResponseInitChain {
 	stateJSON := req.AppStateBytes
 
+	// New chains start out in the current layout
+	app.schemaMapper.SetVersion(ctx, dbl.SchemaVersion)
+
 	genesisState := new(types.GenesisState)
 	err := app.cdc.UnmarshalJSON(stateJSON, genesisState)
 	if err != nil {
@@ -120,9 +166,67 @@ func (app *RegistryApp) initChainer(ctx sdk.Context, req abci.RequestInitChain)
 		}
 		app.accountMapper.SetAccount(ctx, acc)
 	}
+
+	// Genesis files without registries get the default one
+	registries := genesisState.Registries
+	if len(registries) == 0 {
+		registries = []types.Registry{{
+			ID: types.DefaultRegistryID,
+			Params: app.defaultParams,
+		}}
+	}
+	denoms := genesisState.StakingDenoms
+	if len(denoms) == 0 {
+		denoms = []string{app.defaultParams.Denom}
+	}
+	app.registryMapper.SetStakingDenoms(ctx, denoms)
+
+	for _, registry := range registries {
+		if !types.ValidRegistryID(registry.ID) {
+			panic(types.ErrInvalidRegistry(registry.ID))
+		}
+		if err := registry.Params.Validate(); err != nil {
+			panic(err)
+		}
+		if !app.registryMapper.IsStakingDenom(ctx, registry.Params.Denom) {
+			panic(types.ErrInvalidParams(fmt.Sprintf("%s is not a staking denom", registry.Params.Denom)))
+		}
+		app.registryMapper.SetRegistry(ctx, registry)
+	}
 	return abci.ResponseInitChain{}
 }
 
+// Answer top listings queries from the rank index, reading no more than the
+// requested entries. Every other path goes to the base app.
+func (app *RegistryApp) Query(req abci.RequestQuery) abci.ResponseQuery {
+	if req.Path != types.TopListingsPath {
+		return app.BaseApp.Query(req)
+	}
+
+	query := types.TopListingsQuery{}
+	err := app.cdc.UnmarshalBinary(req.Data, &query)
+	if err != nil {
+		return sdk.ErrUnknownRequest(err.Error()).QueryResult()
+	}
+	if !types.ValidRegistryID(query.Registry) {
+		return types.ErrInvalidRegistry(query.Registry).QueryResult()
+	}
+	if query.Limit <= 0 || query.Limit > types.MaxTopListings {
+		return types.ErrInvalidParams(fmt.Sprintf("Limit must be between 1 and %d", types.MaxTopListings)).QueryResult()
+	}
+
+	ctx := app.NewContext(true, abci.Header{})
+	listings := app.ballotMapper.ForRegistry(query.Registry).TopListings(ctx, int(query.Limit))
+	bz, err := app.cdc.MarshalBinary(listings)
+	if err != nil {
+		return sdk.ErrInternal(err.Error()).QueryResult()
+	}
+	return abci.ResponseQuery{
+		Value: bz,
+		Height: app.LastBlockHeight(),
+	}
+}
+
 func (app *RegistryApp) txDecoder(txBytes []byte) (sdk.Tx, sdk.Error) {
 	var tx = auth.StdTx{}
 	err := app.cdc.UnmarshalBinary(txBytes, &tx)
@@ -158,8 +262,59 @@ func (app *RegistryApp) ExportAppStateJSON() (appState json.RawMessage, err erro
 	}
 	app.accountMapper.IterateAccounts(ctx, appendAccount)
 
+	registries := []types.Registry{}
+	app.registryMapper.IterateRegistries(ctx, func(registry types.Registry) bool {
+		registries = append(registries, registry)
+		return false
+	})
+
 	genState := types.GenesisState{
 		Accounts: accounts,
+		Registries: registries,
+		StakingDenoms: app.registryMapper.GetStakingDenoms(ctx),
 	}
 	return wire.MarshalJSONIndent(app.cdc, genState)
-}
\ No newline at end of file
+}
+
+// Hold migrations of a store in an older schema back until the block at
+// height, so every node migrates in the same block. Fails unless the store is
+// current or height is its next block: the blocks before it would run on the
+// old layout.
+func (app *RegistryApp) SetUpgradeHeight(height int64) error {
+	app.schemaMapper = app.schemaMapper.WithUpgradeHeight(height)
+	app.SetBeginBlocker(handle.NewBeginBlocker(app.schemaMapper, app.schemaStores(), haltNode))
+	return app.checkSchema()
+}
+
+// Whether the next block can run on the committed store
+func (app *RegistryApp) checkSchema() error {
+	ctx := app.NewContext(true, abci.Header{})
+	return app.schemaMapper.CheckStart(ctx, app.LastBlockHeight())
+}
+
+func (app *RegistryApp) schemaStores() dbl.Stores {
+	return dbl.Stores{
+		Ballots: app.ballotMapper,
+		Registries: app.registryMapper,
+		DefaultParams: app.defaultParams,
+	}
+}
+
+// Stop the node before the block is committed, so it restarts from the last
+// committed block once the cause is fixed
+func haltNode(err error) {
+	cmn.Exit(fmt.Sprintf("Halting, the store cannot be migrated: %v", err))
+}
+
+// Mappers of the app, for tooling and test harnesses that inspect its state
+func (app *RegistryApp) AccountMapper() auth.AccountMapper {
+	return app.accountMapper
+}
+
+func (app *RegistryApp) BallotMapper() dbl.BallotMapper {
+	return app.ballotMapper
+}
+
+func (app *RegistryApp) RegistryMapper() dbl.RegistryMapper {
+	return app.registryMapper
+}
@@ -0,0 +1,109 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/AdityaSripal/token_curated_registry/app"
+	"github.com/AdityaSripal/token_curated_registry/testutil"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/abci/types"
+)
+
+func TestChallengeLifecycle(t *testing.T) {
+	h := testutil.NewHarness(t, testutil.DefaultParams())
+	owner := h.NewAccount(1000)
+	challenger := h.NewAccount(1000)
+	voter := h.NewAccount(1000)
+	registry := types.DefaultRegistryID
+
+	h.RequireOK(h.Deliver(types.NewDeclareCandidacyMsg(owner.Address, registry, "Listing", h.Coin(100)), owner))
+	h.RequireOK(h.Deliver(types.NewChallengeMsg(challenger.Address, registry, "Listing", h.Coin(100), "Spam", ""), challenger))
+
+	nonce := []byte("nonce")
+	h.RequireOK(h.Deliver(types.NewCommitMsg(voter.Address, registry, "Listing", types.VoteCommitment(true, nonce)), voter))
+
+	// Reveals wait for the commit phase to end
+	reveal := types.NewRevealMsg(voter.Address, registry, "Listing", true, nonce, h.Coin(50))
+	h.RequireCode(h.Deliver(reveal, voter), types.CodeNotRevealPhase)
+	h.AdvanceTo(11)
+	// The commitment binds the vote, so the other vote cannot be revealed with the nonce
+	flipped := types.NewRevealMsg(voter.Address, registry, "Listing", false, nonce, h.Coin(50))
+	h.RequireCode(h.Deliver(flipped, voter), types.CodeVoteMismatch)
+	h.RequireOK(h.Deliver(reveal, voter))
+
+	h.AdvanceTo(21)
+	res := h.Deliver(types.NewApplyMsg(challenger.Address, registry, "Listing"), challenger)
+	h.RequireOK(res)
+	h.RequireTag(res.Tags, types.TagOutcome, types.OutcomeAccepted)
+
+	assert.Equal(t, int64(50), h.Listing("Listing").Votes, "Listing not added with its votes")
+	assert.True(t, h.Ballot("Listing").Settled, "Ballot not settled")
+
+	h.NextBlock()
+	h.RequireOK(h.Deliver(types.NewClaimRewardMsg(voter.Address, registry, "Listing"), voter))
+
+	// Owner keeps the bond listed and half the challenger's, the voter
+	// gets the other half for the winning side
+	h.RequireBalance(owner, 950)
+	h.RequireBalance(challenger, 900)
+	h.RequireBalance(voter, 1050)
+}
+
+func TestListingExpiryLifecycle(t *testing.T) {
+	params := testutil.DefaultParams()
+	params.ApplyLen = 1
+	params.ListingLen = 5
+	h := testutil.NewHarness(t, params)
+	owner := h.NewAccount(1000)
+
+	h.RequireOK(h.Deliver(types.NewDeclareCandidacyMsg(owner.Address, types.DefaultRegistryID, "Listing", h.Coin(100)), owner))
+	h.NextBlock()
+	h.RequireOK(h.Deliver(types.NewApplyMsg(owner.Address, types.DefaultRegistryID, "Listing"), owner))
+	assert.Equal(t, int64(7), h.Listing("Listing").Expiry)
+
+	// Skipped blocks never run their EndBlocker
+	h.BlockAt(8, h.Header.Time+100)
+	assert.Equal(t, "Listing", h.Listing("Listing").Identifier, "Listing expired without an EndBlocker")
+
+	h.NextBlock()
+	h.RequireTag(h.EndBlockTags, types.TagOutcome, types.OutcomeExpired)
+	assert.Equal(t, types.Listing{}, h.Listing("Listing"), "Expired listing not removed")
+	h.RequireBalance(owner, 1000)
+}
+
+func TestTopListingsQuery(t *testing.T) {
+	params := testutil.DefaultParams()
+	params.ApplyLen = 1
+	h := testutil.NewHarness(t, params)
+	owner := h.NewAccount(1000)
+
+	for identifier, bond := range map[string]int64{"low": 100, "high": 300, "mid": 200} {
+		h.RequireOK(h.Deliver(types.NewDeclareCandidacyMsg(owner.Address, types.DefaultRegistryID, identifier, h.Coin(bond)), owner))
+	}
+	h.NextBlock()
+	for _, identifier := range []string{"low", "high", "mid"} {
+		h.RequireOK(h.Deliver(types.NewApplyMsg(owner.Address, types.DefaultRegistryID, identifier), owner))
+	}
+	// Queries read committed state
+	h.NextBlock()
+
+	cdc := app.MakeCodec()
+	query := func(limit int64) abci.ResponseQuery {
+		bz, err := cdc.MarshalBinary(types.TopListingsQuery{Registry: types.DefaultRegistryID, Limit: limit})
+		require.Nil(t, err)
+		return h.App.Query(abci.RequestQuery{Path: types.TopListingsPath, Data: bz})
+	}
+
+	res := query(2)
+	require.Equal(t, uint32(0), res.Code, res.Log)
+	listings := []types.Listing{}
+	require.Nil(t, cdc.UnmarshalBinary(res.Value, &listings))
+	require.Equal(t, 2, len(listings), "Limit not applied")
+	assert.Equal(t, "high", listings[0].Identifier)
+	assert.Equal(t, "mid", listings[1].Identifier)
+
+	assert.NotEqual(t, uint32(0), query(0).Code, "Empty limit accepted")
+	assert.NotEqual(t, uint32(0), query(types.MaxTopListings + 1).Code, "Limit above maximum accepted")
+}
@@ -0,0 +1,542 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/tmlibs/log"
+	dbm "github.com/tendermint/tmlibs/db"
+	abci "github.com/tendermint/abci/types"
+	"github.com/cosmos/cosmos-sdk/wire"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	dbl "github.com/AdityaSripal/token_curated_registry/db"
+)
+
+var (
+	simSeed = flag.Int64("SimSeed", 0, "Seed of the registry simulation, random if 0")
+	simBlocks = flag.Int("SimBlocks", 1000, "Number of blocks the registry simulation runs")
+	simActors = flag.Int("SimActors", 20, "Number of accounts sending msgs in the registry simulation")
+	simTxs = flag.Int("SimTxs", 8, "Maximum number of txs per simulated block")
+)
+
+// Coins every simulated actor starts with
+const simBalance = 100000
+
+// Registry params of the simulation. Short phases so ballots go through
+// every stage many times in a run.
+func simParams(treasury sdk.Address) types.RegistryParams {
+	return types.RegistryParams{
+		Denom: types.DefaultDenom,
+		MinDeposit: 100,
+		ApplyLen: 5,
+		CommitLen: 5,
+		RevealLen: 5,
+		DispensationPct: types.NewRatio(1, 2),
+		Quorum: types.NewRatio(1, 2),
+		Deadlines: types.BlockDeadlines,
+		Appeal: types.AppealParams{
+			AppealLen: 5,
+			BondFactor: 2,
+			CommitLen: 5,
+			RevealLen: 5,
+			Quorum: types.NewRatio(2, 3),
+		},
+		Fees: types.FeeParams{
+			ApplicationFee: 3,
+			RenewalFee: 2,
+			Treasury: treasury,
+		},
+		ListingLen: 40,
+	}
+}
+
+// Account whose msgs the simulation signs
+type simActor struct {
+	key crypto.PrivKey
+	addr sdk.Address
+}
+
+// Vote committed by an actor and not revealed yet
+type simVote struct {
+	actor *simActor
+	registry types.Registry
+	identifier string
+	pollID string
+	choice bool
+	nonce []byte
+}
+
+// Random msg for the simulation to send, signed by its signers. A nil msg
+// skips the operation.
+type simOperation struct {
+	name string
+	weight int
+	msg func(sim *simulation, ctx sdk.Context) sdk.Msg
+}
+
+// Property of the app state that holds after every block
+type simInvariant struct {
+	name string
+	check func(sim *simulation, ctx sdk.Context) error
+}
+
+type simulation struct {
+	r *rand.Rand
+	app *RegistryApp
+	actors []*simActor
+	treasury *simActor
+	identifiers []string
+	votes []simVote
+	header abci.Header
+
+	// Delivered txs by operation and result code
+	results map[string]map[sdk.ABCICodeType]int
+}
+
+// Run InitChain with accs funded and commit it
+func setGenesis(rapp *RegistryApp, accs ...auth.BaseAccount) error {
+	genaccs := make([]*types.GenesisAccount, len(accs))
+	for i, acc := range accs {
+		genaccs[i] = types.NewGenesisAccount(&acc)
+	}
+
+	genesisState := types.GenesisState{
+		Accounts: genaccs,
+	}
+
+	stateBytes, err := wire.MarshalJSONIndent(rapp.cdc, genesisState)
+	if err != nil {
+		return err
+	}
+
+	rapp.InitChain(abci.RequestInitChain{Validators: []abci.Validator{}, AppStateBytes: stateBytes})
+	rapp.Commit()
+	return nil
+}
+
+func newSimulation(seed int64, actors int) *simulation {
+	r := rand.New(rand.NewSource(seed))
+	sim := &simulation{
+		r: r,
+		results: map[string]map[sdk.ABCICodeType]int{},
+	}
+
+	accs := []auth.BaseAccount{}
+	for i := 0; i <= actors; i++ {
+		key := crypto.GenPrivKeyEd25519FromSecret([]byte(fmt.Sprintf("simulation %d actor %d", seed, i)))
+		actor := &simActor{
+			key: key,
+			addr: key.PubKey().Address(),
+		}
+		acc := auth.NewBaseAccountWithAddress(actor.addr)
+		acc.SetCoins(sdk.Coins{{
+			Denom: types.DefaultDenom,
+			Amount: simBalance,
+		}})
+		accs = append(accs, acc)
+		// The last account only receives fees
+		if i == actors {
+			sim.treasury = actor
+		} else {
+			sim.actors = append(sim.actors, actor)
+		}
+	}
+
+	// Few identifiers so actors compete for them
+	for i := 0; i < 2 * actors; i++ {
+		sim.identifiers = append(sim.identifiers, fmt.Sprintf("listing-%d", i))
+	}
+
+	sim.app = NewRegistryApp(log.NewNopLogger(), dbm.NewMemDB(), simParams(sim.treasury.addr))
+	err := setGenesis(sim.app, accs...)
+	if err != nil {
+		panic(err)
+	}
+	return sim
+}
+
+func (sim *simulation) actor() *simActor {
+	return sim.actors[sim.r.Intn(len(sim.actors))]
+}
+
+// Actor with the given address, nil if there is none
+func (sim *simulation) actorOf(addr sdk.Address) *simActor {
+	for _, actor := range append(sim.actors, sim.treasury) {
+		if string(actor.addr) == string(addr) {
+			return actor
+		}
+	}
+	return nil
+}
+
+func (sim *simulation) identifier() string {
+	return sim.identifiers[sim.r.Intn(len(sim.identifiers))]
+}
+
+func (sim *simulation) coin(amount int64) sdk.Coin {
+	return sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: amount,
+	}
+}
+
+// Every registry on chain, the default one and those created since
+func (sim *simulation) registries(ctx sdk.Context) []types.Registry {
+	registries := []types.Registry{}
+	sim.app.registryMapper.IterateRegistries(ctx, func(registry types.Registry) bool {
+		registries = append(registries, registry)
+		return false
+	})
+	return registries
+}
+
+func (sim *simulation) registry(ctx sdk.Context) types.Registry {
+	registries := sim.registries(ctx)
+	return registries[sim.r.Intn(len(registries))]
+}
+
+// Mapper scoped to a registry and its params
+func (sim *simulation) mapper(registry types.Registry) dbl.BallotMapper {
+	return sim.app.ballotMapper.ForParams(registry)
+}
+
+// Random ballot of any registry for which keep returns true, false if there is none
+func (sim *simulation) ballot(ctx sdk.Context, keep func(types.Ballot) bool) (types.Registry, types.Ballot, bool) {
+	registries := []types.Registry{}
+	ballots := []types.Ballot{}
+	for _, registry := range sim.registries(ctx) {
+		sim.mapper(registry).IterateBallots(ctx, func(ballot types.Ballot) bool {
+			if keep(ballot) {
+				registries = append(registries, registry)
+				ballots = append(ballots, ballot)
+			}
+			return false
+		})
+	}
+	if len(ballots) == 0 {
+		return types.Registry{}, types.Ballot{}, false
+	}
+	i := sim.r.Intn(len(ballots))
+	return registries[i], ballots[i], true
+}
+
+// Sign the msg by every signer with their current sequences and deliver it
+func (sim *simulation) deliver(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+	signers := msg.GetSigners()
+	seqs := make([]int64, len(signers))
+	for i, signer := range signers {
+		seqs[i] = sim.app.accountMapper.GetAccount(ctx, signer).GetSequence()
+	}
+	signBytes := types.StdSignBytes(sim.header.ChainID, seqs, auth.StdFee{}, msg)
+	sigs := make([]auth.StdSignature, len(signers))
+	for i, signer := range signers {
+		key := sim.actorOf(signer).key
+		sigs[i] = auth.StdSignature{
+			key.PubKey(),
+			key.Sign(signBytes),
+			seqs[i],
+		}
+	}
+	return sim.app.Deliver(auth.NewStdTx(msg, auth.StdFee{}, sigs))
+}
+
+// Operations are weighted so most ballots get challenged and voted on
+var simOperations = []simOperation{
+	{"create_registry", 1, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		// A few registries whose appeals go to a council of three
+		params := simParams(sim.treasury.addr)
+		for _, i := range sim.r.Perm(len(sim.actors))[:3] {
+			params.Appeal.Council = append(params.Appeal.Council, sim.actors[i].addr)
+		}
+		return types.NewCreateRegistryMsg(sim.actor().addr, fmt.Sprintf("council-%d", sim.r.Intn(3)), params)
+	}},
+	{"declare_candidacy", 3, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		bond := 100 + sim.r.Int63n(100)
+		return types.NewDeclareCandidacyMsg(sim.actor().addr, sim.registry(ctx).ID, sim.identifier(), sim.coin(bond))
+	}},
+	{"challenge", 3, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		registry, ballot, ok := sim.ballot(ctx, func(ballot types.Ballot) bool {
+			return !ballot.Challenged() && (ballot.Status == types.StatusApplying || ballot.Accepted())
+		})
+		if !ok {
+			return nil
+		}
+		return types.NewChallengeMsg(sim.actor().addr, registry.ID, ballot.Identifier, sim.coin(ballot.Bond), "", "")
+	}},
+	{"commit", 10, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		now := ctx.BlockHeight()
+		registry, ballot, ok := sim.ballot(ctx, func(ballot types.Ballot) bool {
+			return ballot.CommitOpen(now)
+		})
+		if !ok {
+			return nil
+		}
+		vote := simVote{
+			actor: sim.actor(),
+			registry: registry,
+			identifier: ballot.Identifier,
+			pollID: ballot.PollID,
+			choice: sim.r.Intn(2) == 0,
+			nonce: []byte(fmt.Sprintf("nonce %d", sim.r.Int63())),
+		}
+		sim.votes = append(sim.votes, vote)
+		return types.NewCommitMsg(vote.actor.addr, registry.ID, vote.identifier, types.VoteCommitment(vote.choice, vote.nonce))
+	}},
+	{"reveal", 10, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		if len(sim.votes) == 0 {
+			return nil
+		}
+		i := sim.r.Intn(len(sim.votes))
+		vote := sim.votes[i]
+		ballot := sim.mapper(vote.registry).GetBallot(ctx, vote.identifier)
+		if ballot.PollID != vote.pollID || !ballot.RevealOpen(ctx.BlockHeight()) {
+			// Drop votes on polls that are over, keep the others for later
+			if ballot.PollID != vote.pollID || ctx.BlockHeight() >= ballot.EndRevealBlockStamp {
+				sim.votes = append(sim.votes[:i], sim.votes[i+1:]...)
+			}
+			return nil
+		}
+		sim.votes = append(sim.votes[:i], sim.votes[i+1:]...)
+		stake := 1 + sim.r.Int63n(200)
+		return types.NewRevealMsg(vote.actor.addr, vote.registry.ID, vote.identifier, vote.choice, vote.nonce, sim.coin(stake))
+	}},
+	{"delegate_voting", 2, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		actor := sim.actor()
+		registry := sim.registry(ctx)
+		// Some delegations are revoked again
+		if sim.r.Intn(4) == 0 {
+			return types.NewDelegateVotingMsg(actor.addr, registry.ID, nil, sdk.Coin{})
+		}
+		delegate := sim.actor()
+		if delegate == actor {
+			return nil
+		}
+		power := 1 + sim.r.Int63n(100)
+		return types.NewDelegateVotingMsg(actor.addr, registry.ID, delegate.addr, sim.coin(power))
+	}},
+	{"apply", 6, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		return types.NewApplyMsg(sim.actor().addr, sim.registry(ctx).ID, sim.identifier())
+	}},
+	{"appeal", 1, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		now := ctx.BlockHeight()
+		registry, ballot, ok := sim.ballot(ctx, func(ballot types.Ballot) bool {
+			return ballot.Appealable(now)
+		})
+		if !ok {
+			return nil
+		}
+		party := ballot.Owner
+		if sim.r.Intn(2) == 0 {
+			party = ballot.Challenger
+		}
+		bond := registry.Params.Appeal.BondFactor * ballot.Bond
+		return types.NewAppealMsg(party, registry.ID, ballot.Identifier, sim.coin(bond))
+	}},
+	{"appeal_decision", 2, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		registry, ballot, ok := sim.ballot(ctx, func(ballot types.Ballot) bool {
+			return ballot.Status == types.StatusCouncil
+		})
+		if !ok {
+			return nil
+		}
+		// Signed by a bare majority of the council
+		council := registry.Params.Appeal.Council
+		return types.NewAppealDecisionMsg(council[:len(council)/2+1], registry.ID, ballot.Identifier, sim.r.Intn(2) == 0)
+	}},
+	{"claim_reward", 3, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		actor := sim.actor()
+		registry := sim.registry(ctx)
+		pending := sim.mapper(registry).PendingClaims(ctx, actor.addr)
+		if len(pending) == 0 {
+			return nil
+		}
+		return types.NewClaimRewardMsg(actor.addr, registry.ID, pending[sim.r.Intn(len(pending))])
+	}},
+	{"claim_all_rewards", 1, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		return types.NewClaimAllRewardsMsg(sim.actor().addr, sim.registry(ctx).ID)
+	}},
+	{"renew_listing", 1, func(sim *simulation, ctx sdk.Context) sdk.Msg {
+		registry, ballot, ok := sim.ballot(ctx, func(ballot types.Ballot) bool {
+			return ballot.Accepted() && ballot.Bond > 0
+		})
+		if !ok {
+			return nil
+		}
+		return types.NewRenewListingMsg(ballot.Owner, registry.ID, ballot.Identifier)
+	}},
+}
+
+// Coins a ballot holds for its owner, challenger, appellant and voter pool.
+// Stakes of unclaimed reveals are counted separately.
+func ballotEscrow(ballot types.Ballot) int64 {
+	switch {
+	case ballot.PollID == "":
+		// Candidate, or unchallenged listing. Expired listings have their bond refunded
+		return ballot.Bond
+	case !ballot.Settled:
+		// Both bonds, and the appeal bond once appealed
+		return 2 * ballot.Bond + ballot.AppealBond
+	case ballot.Accepted():
+		// Owner's bond stays with the listing
+		return ballot.Bond + ballot.Pool - ballot.PoolPaid
+	default:
+		return ballot.Pool - ballot.PoolPaid
+	}
+}
+
+var simInvariants = []simInvariant{
+	{"conservation", func(sim *simulation, ctx sdk.Context) error {
+		var total int64
+		for _, actor := range append(sim.actors, sim.treasury) {
+			total += sim.app.accountMapper.GetAccount(ctx, actor.addr).GetCoins().AmountOf(types.DefaultDenom)
+		}
+		for _, registry := range sim.registries(ctx) {
+			mapper := sim.mapper(registry)
+			mapper.IterateBallots(ctx, func(ballot types.Ballot) bool {
+				total += ballotEscrow(ballot)
+				// Pools of the polls the listing had before, not yet claimed
+				for _, poll := range mapper.ArchivedPolls(ctx, ballot.Identifier) {
+					total += poll.Pool - poll.PoolPaid
+				}
+				return false
+			})
+			mapper.IterateReveals(ctx, func(_ types.Voter, vote types.Vote) bool {
+				total += vote.Power
+				return false
+			})
+		}
+		supply := int64(len(sim.actors) + 1) * simBalance
+		if total != supply {
+			return fmt.Errorf("accounts and escrow hold %d, supply is %d", total, supply)
+		}
+		return nil
+	}},
+	{"ballots", func(sim *simulation, ctx sdk.Context) (err error) {
+		for _, registry := range sim.registries(ctx) {
+			mapper := sim.mapper(registry)
+			mapper.IterateBallots(ctx, func(ballot types.Ballot) bool {
+				switch {
+				case ballot.Approve < 0 || ballot.Deny < 0:
+					err = fmt.Errorf("%s has negative tally %d/%d", ballot.Identifier, ballot.Approve, ballot.Deny)
+				case ballot.PoolPaid > ballot.Pool:
+					err = fmt.Errorf("%s paid %d of a pool of %d", ballot.Identifier, ballot.PoolPaid, ballot.Pool)
+				case ballot.Settled && !ballot.Decided() && ballot.Status != types.StatusRemoved:
+					err = fmt.Errorf("%s settled in status %s", ballot.Identifier, ballot.Status)
+				case ballot.PollID != "" && ballot.EndCommitBlockStamp > ballot.EndRevealBlockStamp:
+					err = fmt.Errorf("%s commit phase ends after its reveal phase", ballot.Identifier)
+				}
+				listed := mapper.GetListing(ctx, ballot.Identifier).Identifier != ""
+				if listed && (ballot.Status == types.StatusApplying || ballot.Status == types.StatusRejected) {
+					err = fmt.Errorf("%s listed in status %s", ballot.Identifier, ballot.Status)
+				}
+				if !listed && ballot.Accepted() && ballot.Bond > 0 {
+					err = fmt.Errorf("%s accepted with a bond but not listed", ballot.Identifier)
+				}
+				return err != nil
+			})
+			if err != nil {
+				return fmt.Errorf("registry %s: %v", registry.ID, err)
+			}
+		}
+		return nil
+	}},
+	{"listings", func(sim *simulation, ctx sdk.Context) error {
+		for _, registry := range sim.registries(ctx) {
+			mapper := sim.mapper(registry)
+			var last int64 = math.MaxInt64
+			for _, listing := range mapper.TopListings(ctx, math.MaxInt32) {
+				if listing != mapper.GetListing(ctx, listing.Identifier) {
+					return fmt.Errorf("rank index of %s in %s is stale", listing.Identifier, registry.ID)
+				}
+				if listing.Score() > last {
+					return fmt.Errorf("%s ranked below a lower score in %s", listing.Identifier, registry.ID)
+				}
+				last = listing.Score()
+				if mapper.GetBallot(ctx, listing.Identifier).Identifier == "" {
+					return fmt.Errorf("%s listed in %s without a ballot", listing.Identifier, registry.ID)
+				}
+			}
+		}
+		return nil
+	}},
+}
+
+// Drives the app through random blocks of random msgs from many actors and
+// checks every invariant after each block. Failures print the seed to rerun
+// them with -SimSeed.
+func TestSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Simulation skipped in short mode")
+	}
+	seed := *simSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	sim := newSimulation(seed, *simActors)
+
+	weights := 0
+	for _, op := range simOperations {
+		weights += op.weight
+	}
+
+	for height := int64(1); height <= int64(*simBlocks); height++ {
+		sim.header = abci.Header{
+			Height: height,
+			Time: height * 10,
+		}
+		sim.app.BeginBlock(abci.RequestBeginBlock{Header: sim.header})
+		ctx := sim.app.NewContext(false, sim.header)
+
+		txs := sim.r.Intn(*simTxs + 1)
+		for i := 0; i < txs; i++ {
+			pick := sim.r.Intn(weights)
+			op := simOperations[0]
+			for _, candidate := range simOperations {
+				if pick < candidate.weight {
+					op = candidate
+					break
+				}
+				pick -= candidate.weight
+			}
+
+			msg := op.msg(sim, ctx)
+			if msg == nil {
+				continue
+			}
+			res := sim.deliver(ctx, msg)
+			if res.Code == sdk.ToABCICode(sdk.CodespaceRoot, sdk.CodeInternal) {
+				t.Fatalf("%s failed internally at height %d: %s. Rerun with -SimSeed=%d", op.name, height, res.Log, seed)
+			}
+			if sim.results[op.name] == nil {
+				sim.results[op.name] = map[sdk.ABCICodeType]int{}
+			}
+			sim.results[op.name][res.Code]++
+		}
+
+		sim.app.EndBlock(abci.RequestEndBlock{})
+		for _, invariant := range simInvariants {
+			if err := invariant.check(sim, ctx); err != nil {
+				t.Fatalf("Invariant %s broken at height %d: %v. Rerun with -SimSeed=%d", invariant.name, height, err, seed)
+			}
+		}
+		sim.app.Commit()
+	}
+
+	t.Logf("Simulated %d blocks with seed %d", *simBlocks, seed)
+	for _, op := range simOperations {
+		t.Logf("%s: %v", op.name, sim.results[op.name])
+	}
+	// A run where nothing succeeds checks nothing
+	for _, name := range []string{"create_registry", "declare_candidacy", "challenge", "commit", "reveal", "delegate_voting", "apply"} {
+		if sim.results[name][0] == 0 && *simBlocks >= 1000 {
+			t.Errorf("No %s delivered successfully. Rerun with -SimSeed=%d", name, seed)
+		}
+	}
+}
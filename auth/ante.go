@@ -5,7 +5,7 @@ import (
 	"fmt"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/cosmos/cosmos-sdk/x/auth"
-	"reflect"
+	"github.com/AdityaSripal/token_curated_registry/types"
 )
 
 func NewAnteHandler(accountMapper auth.AccountMapper) sdk.AnteHandler {
@@ -16,61 +16,93 @@ func NewAnteHandler(accountMapper auth.AccountMapper) sdk.AnteHandler {
 		}
 
 		sigs := stdTx.GetSignatures()
-		if len(sigs) != 1 {
+		if len(sigs) == 0 {
 			return ctx,
 				sdk.ErrUnauthorized("no signers").Result(),
 				true
 		}
 
-		sig := sigs[0]
 		msg := tx.GetMsg()
+		signerAddrs := msg.GetSigners()
 
-		signerAddr := msg.GetSigners()[0]
-
-		acc := accountMapper.GetAccount(ctx, signerAddr)
+		// Every signer declared by the msg must provide exactly one signature, in order
+		if len(sigs) != len(signerAddrs) {
+			return ctx,
+				sdk.ErrUnauthorized(fmt.Sprintf("Wrong number of signatures. Got %d, expected %d", len(sigs), len(signerAddrs))).Result(),
+				true
+		}
 
-		if acc == nil {
-			return ctx, sdk.ErrUnknownAddress(signerAddr.String()).Result(), true
+		for i, signerAddr := range signerAddrs {
+			for _, prev := range signerAddrs[:i] {
+				if bytes.Equal(prev, signerAddr) {
+					return ctx, sdk.ErrUnauthorized(fmt.Sprintf("Duplicate signer %v", signerAddr)).Result(), true
+				}
+			}
 		}
-	
-		// Check and increment sequence number.
-		seq := acc.GetSequence()
-		if seq != sig.Sequence {
-			return ctx, sdk.ErrInvalidSequence(
-				fmt.Sprintf("Invalid sequence. Got %d, expected %d", sig.Sequence, seq)).Result(), true
+
+		// All signers sign the same document, which commits to every signer's sequence
+		sequences := make([]int64, len(sigs))
+		for i, sig := range sigs {
+			sequences[i] = sig.Sequence
 		}
-		acc.SetSequence(seq + 1)
-	
-		// If pubkey is not known for account,
-		// set it from the StdSignature.
-		pubKey := acc.GetPubKey()
-		if pubKey == nil {
-			pubKey = sig.PubKey
-			if pubKey == nil {
-				return ctx, sdk.ErrInvalidPubKey("PubKey not found").Result(), true
-			}
-			if !bytes.Equal(pubKey.Address(), signerAddr) {
-				return ctx, sdk.ErrInvalidPubKey(
-					fmt.Sprintf("PubKey does not match Signer address %v", signerAddr)).Result(), true
-			}
-			err := acc.SetPubKey(pubKey)
+		signBytes := types.StdSignBytes(ctx.ChainID(), sequences, stdTx.Fee, msg)
+
+		// Verify all signatures before touching any account so a bad signature
+		// further down the list cannot leave earlier sequences incremented
+		accs := make([]auth.Account, len(signerAddrs))
+		for i, signerAddr := range signerAddrs {
+			acc, err := processSig(ctx, accountMapper, signerAddr, sigs[i], signBytes)
 			if err != nil {
-				return ctx, sdk.ErrInternal("setting PubKey on signer's account").Result(), true
+				return ctx, err.Result(), true
 			}
+			accs[i] = acc
 		}
 
-		if !reflect.DeepEqual(sigs[0].PubKey.Address().Bytes(), signerAddr.Bytes()) {
-			return ctx, sdk.ErrInternal("Wrong signer address").Result(), true
+		for _, acc := range accs {
+			accountMapper.SetAccount(ctx, acc)
 		}
 
-		if !sigs[0].PubKey.VerifyBytes(msg.GetSignBytes(), sigs[0].Signature) {
-			return ctx, sdk.ErrInternal("Invalid Signature").Result(), true
-		}
+		return ctx, sdk.Result{}, false
+	}
+}
+
+// Verify a single signer's signature and sequence, returning the account with
+// its sequence incremented and pubkey set. The account is not saved.
+func processSig(ctx sdk.Context, accountMapper auth.AccountMapper, signerAddr sdk.Address, sig auth.StdSignature, signBytes []byte) (auth.Account, sdk.Error) {
+	acc := accountMapper.GetAccount(ctx, signerAddr)
+	if acc == nil {
+		return nil, sdk.ErrUnknownAddress(signerAddr.String())
+	}
 
-		if !pubKey.VerifyBytes(msg.GetSignBytes(), sig.Signature) {
-			return ctx, sdk.ErrUnauthorized("signature verification failed").Result(), true
+	// Check and increment sequence number.
+	seq := acc.GetSequence()
+	if seq != sig.Sequence {
+		return nil, sdk.ErrInvalidSequence(
+			fmt.Sprintf("Invalid sequence for signer %v. Got %d, expected %d", signerAddr, sig.Sequence, seq))
+	}
+	acc.SetSequence(seq + 1)
+
+	// If pubkey is not known for account,
+	// set it from the StdSignature.
+	pubKey := acc.GetPubKey()
+	if pubKey == nil {
+		pubKey = sig.PubKey
+		if pubKey == nil {
+			return nil, sdk.ErrInvalidPubKey("PubKey not found")
 		}
+		if !bytes.Equal(pubKey.Address(), signerAddr) {
+			return nil, sdk.ErrInvalidPubKey(
+				fmt.Sprintf("PubKey does not match Signer address %v", signerAddr))
+		}
+		err := acc.SetPubKey(pubKey)
+		if err != nil {
+			return nil, sdk.ErrInternal("setting PubKey on signer's account")
+		}
+	}
 
-		return ctx, sdk.Result{}, false
+	if !pubKey.VerifyBytes(signBytes, sig.Signature) {
+		return nil, sdk.ErrUnauthorized(fmt.Sprintf("signature verification failed for signer %v", signerAddr))
 	}
-}
\ No newline at end of file
+
+	return acc, nil
+}
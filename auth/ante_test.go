@@ -13,7 +13,7 @@ import (
 )
 
 func setup() (sdk.Context, auth.AccountMapper) {
-	ms, _, _, _, _, accountKey := db.SetupMultiStore()
+	ms, _, _, _, _, _, _, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
@@ -34,7 +34,7 @@ func TestBadTx(t *testing.T) {
 	privKey := utils.GeneratePrivKey()
 	mapper.NewAccountWithAddress(ctx, privKey.PubKey().Address())
 
-	sig := privKey.Sign(msg.GetSignBytes())
+	sig := privKey.Sign(types.StdSignBytes("", []int64{0}, auth.StdFee{}, msg))
 
 	tx := auth.StdTx{
 		Msg: msg,
@@ -64,7 +64,7 @@ func TestGoodTx(t *testing.T) {
 
 	msg.Owner = privKey.PubKey().Address()
 
-	sig := privKey.Sign(msg.GetSignBytes())
+	sig := privKey.Sign(types.StdSignBytes("", []int64{0}, auth.StdFee{}, msg))
 
 	tx := auth.StdTx{
 		Msg: msg,
@@ -82,3 +82,161 @@ func TestGoodTx(t *testing.T) {
 	assert.Equal(t, false, abort, "Good tx failed")
 }
 
+// Msg declaring several signers, standing in for a jointly owned listing
+type multiSignerMsg struct {
+	types.DeclareCandidacyMsg
+	Signers []sdk.Address
+}
+
+func (msg multiSignerMsg) GetSigners() []sdk.Address {
+	return msg.Signers
+}
+
+func TestMultiSignerTx(t *testing.T) {
+	ctx, mapper := setup()
+
+	ante := NewAnteHandler(mapper)
+
+	privKey1 := utils.GeneratePrivKey()
+	privKey2 := utils.GeneratePrivKey()
+
+	acc1 := mapper.NewAccountWithAddress(ctx, privKey1.PubKey().Address())
+	mapper.SetAccount(ctx, acc1)
+	acc2 := mapper.NewAccountWithAddress(ctx, privKey2.PubKey().Address())
+	mapper.SetAccount(ctx, acc2)
+
+	msg := multiSignerMsg{
+		DeclareCandidacyMsg: types.GenerateCandidacyMsg(),
+		Signers: []sdk.Address{privKey1.PubKey().Address(), privKey2.PubKey().Address()},
+	}
+
+	signBytes := types.StdSignBytes("", []int64{0, 0}, auth.StdFee{}, msg)
+	sig1 := privKey1.Sign(signBytes)
+	sig2 := privKey2.Sign(signBytes)
+
+	// Only one of two signers signs
+	tx := auth.StdTx{
+		Msg: msg,
+		Signatures: []auth.StdSignature{auth.StdSignature{
+			privKey1.PubKey(),
+			sig1,
+			0,
+		}},
+	}
+
+	_, res, abort := ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Tx missing a signature allowed to pass")
+	assert.Equal(t, sdk.ToABCICode(sdk.CodespaceRoot, sdk.CodeUnauthorized), res.Code, res.Log)
+
+	// Signatures in wrong order fail verification
+	tx.Signatures = []auth.StdSignature{
+		auth.StdSignature{privKey2.PubKey(), sig2, 0},
+		auth.StdSignature{privKey1.PubKey(), sig1, 0},
+	}
+
+	_, _, abort = ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Tx with mismatched signers allowed to pass")
+
+	// Bad second signature does not increment first signer's sequence
+	tx.Signatures = []auth.StdSignature{
+		auth.StdSignature{privKey1.PubKey(), sig1, 0},
+		auth.StdSignature{privKey2.PubKey(), sig1, 0},
+	}
+
+	_, _, abort = ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Tx with invalid second signature allowed to pass")
+	assert.Equal(t, int64(0), mapper.GetAccount(ctx, privKey1.PubKey().Address()).GetSequence(), "Sequence incremented on failed tx")
+
+	// All signers sign in order
+	tx.Signatures = []auth.StdSignature{
+		auth.StdSignature{privKey1.PubKey(), sig1, 0},
+		auth.StdSignature{privKey2.PubKey(), sig2, 0},
+	}
+
+	_, res, abort = ante(ctx, tx)
+
+	assert.Equal(t, sdk.Result{}, res, "Multi-signer tx failed")
+	assert.Equal(t, false, abort, "Multi-signer tx failed")
+
+	assert.Equal(t, int64(1), mapper.GetAccount(ctx, privKey1.PubKey().Address()).GetSequence(), "Sequence of first signer not incremented")
+	assert.Equal(t, int64(1), mapper.GetAccount(ctx, privKey2.PubKey().Address()).GetSequence(), "Sequence of second signer not incremented")
+
+	// Replaying the same tx fails on sequence
+	_, res, abort = ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Replayed tx allowed to pass")
+	assert.Equal(t, sdk.ToABCICode(sdk.CodespaceRoot, sdk.CodeInvalidSequence), res.Code, res.Log)
+}
+
+func TestDuplicateSigner(t *testing.T) {
+	ctx, mapper := setup()
+
+	ante := NewAnteHandler(mapper)
+
+	privKey := utils.GeneratePrivKey()
+
+	acc := mapper.NewAccountWithAddress(ctx, privKey.PubKey().Address())
+	mapper.SetAccount(ctx, acc)
+
+	msg := multiSignerMsg{
+		DeclareCandidacyMsg: types.GenerateCandidacyMsg(),
+		Signers: []sdk.Address{privKey.PubKey().Address(), privKey.PubKey().Address()},
+	}
+
+	sig := privKey.Sign(types.StdSignBytes("", []int64{0, 0}, auth.StdFee{}, msg))
+
+	tx := auth.StdTx{
+		Msg: msg,
+		Signatures: []auth.StdSignature{
+			auth.StdSignature{privKey.PubKey(), sig, 0},
+			auth.StdSignature{privKey.PubKey(), sig, 0},
+		},
+	}
+
+	_, res, abort := ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Tx with duplicate signers allowed to pass")
+	assert.Equal(t, sdk.ToABCICode(sdk.CodespaceRoot, sdk.CodeUnauthorized), res.Code, res.Log)
+}
+
+func TestReplayOtherChain(t *testing.T) {
+	ctx, mapper := setup()
+
+	ante := NewAnteHandler(mapper)
+
+	privKey := utils.GeneratePrivKey()
+
+	acc := mapper.NewAccountWithAddress(ctx, privKey.PubKey().Address())
+	mapper.SetAccount(ctx, acc)
+
+	msg := types.GenerateCandidacyMsg()
+	msg.Owner = privKey.PubKey().Address()
+
+	// Signed for a different chain
+	sig := privKey.Sign(types.StdSignBytes("other-chain", []int64{0}, auth.StdFee{}, msg))
+
+	tx := auth.StdTx{
+		Msg: msg,
+		Signatures: []auth.StdSignature{auth.StdSignature{
+			privKey.PubKey(),
+			sig,
+			0,
+		}},
+	}
+
+	_, res, abort := ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Tx signed for another chain allowed to pass")
+	assert.Equal(t, sdk.ToABCICode(sdk.CodespaceRoot, sdk.CodeUnauthorized), res.Code, res.Log)
+
+	// Signed over a different sequence than the one claimed
+	sig = privKey.Sign(types.StdSignBytes("", []int64{1}, auth.StdFee{}, msg))
+	tx.Signatures[0].Signature = sig
+
+	_, _, abort = ante(ctx, tx)
+
+	assert.Equal(t, true, abort, "Tx signed over wrong sequence allowed to pass")
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+	types "github.com/AdityaSripal/token_curated_registry/types"
+	db "github.com/AdityaSripal/token_curated_registry/db"
+)
+
+// Remove the expired listings of every registry at the end of each block
+func NewEndBlocker(registryMapper db.RegistryMapper, ballotMapper db.BallotMapper) sdk.EndBlocker {
+	return func(ctx sdk.Context, req abci.RequestEndBlock) abci.ResponseEndBlock {
+		tags := sdk.EmptyTags()
+		registryMapper.IterateRegistries(ctx, func(registry types.Registry) bool {
+			if registry.Params.ListingLen > 0 {
+				tags = expireListings(ctx, ballotMapper.ForParams(registry), tags)
+			}
+			return false
+		})
+		return abci.ResponseEndBlock{
+			Tags: tags,
+		}
+	}
+}
+
+// Remove the registry's listings past their expiry and refund their owners'
+// bonds. Listings under challenge are left to the challenge and expire later
+// if they survive it.
+func expireListings(ctx sdk.Context, keeper db.Keeper, tags sdk.Tags) sdk.Tags {
+	now := keeper.Now(ctx)
+	for _, listing := range keeper.ExpiredListings(ctx, now) {
+		ballot := keeper.GetBallot(ctx, listing.Identifier)
+		if ballot.Challenged() {
+			continue
+		}
+		if ballot.Transition(types.StatusRemoved, now) != nil {
+			continue
+		}
+
+		err := keeper.Release(ctx, ballot.Owner, ballot.Bond)
+		if err != nil {
+			panic(err)
+		}
+		keeper.ArchiveListing(ctx, ballot, types.RemovedExpired)
+		keeper.AppendHistory(ctx, listing.Identifier, types.HistoryEvent{
+			Action: "expire_listing",
+			Outcome: types.OutcomeExpired,
+		})
+		// Bond is returned, ballot stays for unclaimed votes on its polls until
+		// the identifier is declared again
+		ballot.Bond = 0
+		keeper.SetBallot(ctx, ballot)
+
+		tags = tags.AppendTag(types.TagAction, []byte("expire_listing"))
+		tags = tags.AppendTag(types.TagListing, []byte(listing.Identifier))
+		tags = tags.AppendTag(types.TagRegistry, []byte(keeper.RegistryID()))
+		tags = tags.AppendTag(types.TagOutcome, []byte(types.OutcomeExpired))
+	}
+	return tags
+}
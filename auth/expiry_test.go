@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/AdityaSripal/token_curated_registry/db"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/tmlibs/log"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/bank"
+	"github.com/AdityaSripal/token_curated_registry/utils"
+)
+
+func TestExpireListings(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, registryKey := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	registryMapper := db.NewRegistryMapper(registryKey, cdc)
+	registry := types.Registry{
+		ID: types.DefaultRegistryID,
+		Params: types.RegistryParams{
+			Denom: "RegistryCoin",
+			Deadlines: types.BlockDeadlines,
+			ListingLen: 10,
+		},
+	}
+	registryMapper.SetRegistry(ctx, registry)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+	scoped := mapper.ForParams(registry)
+
+	owner := utils.GenerateAddress()
+	scoped.AddBallot(ctx, "Expiring listing", owner, 0, 100)
+	scoped.AddListing(ctx, "Expiring listing", 0, 100)
+	ballot := scoped.GetBallot(ctx, "Expiring listing")
+	ballot.Status = types.StatusAccepted
+	ballot.Settled = true
+	ballot.PollID = types.NewPollID("Expiring listing", 3)
+	scoped.SetBallot(ctx, ballot)
+	assert.Equal(t, int64(10), scoped.GetListing(ctx, "Expiring listing").Expiry, "Expiry not set")
+
+	endBlocker := NewEndBlocker(registryMapper, mapper)
+
+	// Listing is still valid on its expiry
+	ctx = ctx.WithBlockHeight(10)
+	res := endBlocker(ctx, abci.RequestEndBlock{})
+	assert.Equal(t, 0, len(res.Tags), "Expired listing early")
+	assert.Equal(t, "Expiring listing", scoped.GetListing(ctx, "Expiring listing").Identifier, "Listing removed early")
+
+	// Listings under challenge wait for the challenge
+	ballot.Status = types.StatusCommit
+	scoped.SetBallot(ctx, ballot)
+	ctx = ctx.WithBlockHeight(11)
+	res = endBlocker(ctx, abci.RequestEndBlock{})
+	assert.Equal(t, 0, len(res.Tags), "Expired challenged listing")
+
+	ballot.Status = types.StatusAccepted
+	scoped.SetBallot(ctx, ballot)
+	res = endBlocker(ctx, abci.RequestEndBlock{})
+	assert.Equal(t, []byte(types.OutcomeExpired), getTag(res.Tags, types.TagOutcome), "Expiry not tagged")
+	assert.Equal(t, []byte("Expiring listing"), getTag(res.Tags, types.TagListing), "Listing not tagged")
+	assert.Equal(t, "", scoped.GetListing(ctx, "Expiring listing").Identifier, "Listing not removed")
+	assert.Equal(t, int64(100), accountKeeper.GetCoins(ctx, owner).AmountOf("RegistryCoin"), "Bond not refunded")
+	assert.Equal(t, int64(0), scoped.GetBallot(ctx, "Expiring listing").Bond, "Bond refunded twice")
+	assert.Equal(t, 0, len(scoped.ExpiredListings(ctx, 100)), "Expiry index not cleared")
+
+	archived, ok := scoped.GetArchivedListing(ctx, "Expiring listing")
+	assert.True(t, ok, "Expired listing not archived")
+	assert.Equal(t, types.RemovedExpired, archived.Reason, "Removal reason wrong")
+	assert.Equal(t, int64(11), archived.Height, "Removal height wrong")
+	assert.Equal(t, types.StatusRemoved, scoped.GetBallot(ctx, "Expiring listing").Status, "Expired ballot not removed")
+
+	// Expired listings cannot be challenged
+	challenger := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, challenger, scoped.Coins(100))
+	res = NewChallengeHandler(scoped, 10, 10, 100)(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Expiring listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}, "", ""))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotListed), res.Code, "Expired listing challenged")
+
+	// But the identifier can be declared again
+	res = NewCandidacyHandler(scoped, 100, 10, types.FeeParams{})(ctx, types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Expiring listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	redeclared := scoped.GetBallot(ctx, "Expiring listing")
+	assert.Equal(t, types.StatusApplying, redeclared.Status, "Expired identifier not declared again")
+	assert.Equal(t, int64(100), redeclared.Bond)
+	polls := scoped.ArchivedPolls(ctx, "Expiring listing")
+	assert.Equal(t, 1, len(polls), "Poll of expired listing not archived")
+	assert.Equal(t, ballot.PollID, polls[0].PollID)
+}
+
+func TestRenewListing(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc).WithListingLen(10)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	owner := utils.GenerateAddress()
+	stranger := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, owner, mapper.Coins(100))
+	mapper.AddBallot(ctx, "Renewed listing", owner, 0, 100)
+	mapper.AddListing(ctx, "Renewed listing", 0, 100)
+
+	handler := NewRenewListingHandler(mapper, types.FeeParams{
+		RenewalFee: 30,
+	})
+
+	res := handler(ctx, types.NewRenewListingMsg(stranger, types.DefaultRegistryID, "Renewed listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotListingOwner), res.Code, "Stranger renewed listing")
+
+	res = handler(ctx, types.NewRenewListingMsg(owner, types.DefaultRegistryID, "Missing listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotListed), res.Code, "Renewed missing listing")
+
+	res = handler(ctx, types.NewRenewListingMsg(owner, types.DefaultRegistryID, "Renewed listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte("20"), getTag(res.Tags, types.TagExpiry), "Expiry not tagged")
+	assert.Equal(t, int64(20), mapper.GetListing(ctx, "Renewed listing").Expiry, "Expiry not extended")
+	assert.Equal(t, int64(70), accountKeeper.GetCoins(ctx, owner).AmountOf("RegistryCoin"), "Renewal fee not charged")
+
+	// Only the extended expiry is indexed
+	assert.Equal(t, 0, len(mapper.ExpiredListings(ctx, 20)), "Old expiry still indexed")
+	assert.Equal(t, 1, len(mapper.ExpiredListings(ctx, 21)), "New expiry not indexed")
+
+	// More than a lifetime is left now
+	res = handler(ctx, types.NewRenewListingMsg(owner, types.DefaultRegistryID, "Renewed listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeRenewalTooEarly), res.Code, "Renewed two lifetimes ahead")
+	assert.Equal(t, int64(20), mapper.GetListing(ctx, "Renewed listing").Expiry, "Expiry extended by rejected renewal")
+	assert.Equal(t, int64(70), accountKeeper.GetCoins(ctx, owner).AmountOf("RegistryCoin"), "Fee charged for rejected renewal")
+
+	// A lapsed listing that was not removed yet runs a lifetime from now
+	ctx = ctx.WithBlockHeight(30)
+	res = handler(ctx, types.NewRenewListingMsg(owner, types.DefaultRegistryID, "Renewed listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(40), mapper.GetListing(ctx, "Renewed listing").Expiry, "Lapsed listing renewed from its old expiry")
+	assert.Equal(t, 0, len(mapper.ExpiredListings(ctx, 40)), "Lapsed listing still indexed as expired")
+
+	// So does a listing added before the registry had a listing length
+	mapper.AddBallot(ctx, "Unexpiring listing", owner, 0, 100)
+	mapper.WithListingLen(0).AddListing(ctx, "Unexpiring listing", 0, 100)
+	res = handler(ctx, types.NewRenewListingMsg(owner, types.DefaultRegistryID, "Unexpiring listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(40), mapper.GetListing(ctx, "Unexpiring listing").Expiry, "Zero expiry renewed from height 0")
+	assert.Equal(t, 2, len(mapper.ExpiredListings(ctx, 41)), "Renewed zero expiry not indexed")
+
+	// Registries without a listing length have nothing to renew
+	handler = NewRenewListingHandler(mapper.WithListingLen(0), types.FeeParams{})
+	res = handler(ctx, types.NewRenewListingMsg(owner, types.DefaultRegistryID, "Renewed listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNoExpiry), res.Code, "Renewed listing that never expires")
+}
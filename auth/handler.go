@@ -1,321 +1,890 @@
 package auth
 
 import (
-	"github.com/tendermint/go-amino"
+	"bytes"
+	"fmt"
 	sdk "github.com/cosmos/cosmos-sdk/types"
-	bank "github.com/cosmos/cosmos-sdk/x/bank"
 	types "github.com/AdityaSripal/token_curated_registry/types"
 	db "github.com/AdityaSripal/token_curated_registry/db"
 	"reflect"
-	"crypto/sha256"
 )
 
-func NewCandidacyHandler(accountKeeper bank.Keeper, ballotMapper db.BallotMapper, minBond int64, applyLen int64) sdk.Handler {
+func NewCandidacyHandler(keeper db.Keeper, minBond int64, applyLen int64, fees types.FeeParams) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		declareMsg := msg.(types.DeclareCandidacyMsg)
+		if err := checkDenom(keeper, declareMsg.Bond); err != nil {
+			return err.Result()
+		}
 		if declareMsg.Bond.Amount < minBond {
 			return sdk.ErrInsufficientFunds("Must send at least the minimum bond").Result()
 		}
-		_, _, err := accountKeeper.SubtractCoins(ctx, declareMsg.Owner, []sdk.Coin{declareMsg.Bond})
+		err := keeper.Lock(ctx, declareMsg.Owner, declareMsg.Bond.Amount)
 
 		if err != nil {
 			return err.Result()
 		}
 
-		ballot := ballotMapper.GetBallot(ctx, declareMsg.Identifier)
-		if !reflect.DeepEqual(ballot, types.Ballot{}) {
-			return sdk.NewError(2, 110, "Candidate already exists").Result()
+		// Identifiers of removed listings are free again
+		ballot := keeper.GetBallot(ctx, declareMsg.Identifier)
+		if !reflect.DeepEqual(ballot, types.Ballot{}) && ballot.Status != types.StatusRemoved {
+			return types.ErrCandidateExists("").Result()
+		}
+
+		if ballot.PollID != "" {
+			// Voters on the removed listing's last poll claim from its archived copy
+			keeper.ArchivePoll(ctx, ballot)
 		}
 
-		err2 := ballotMapper.AddBallot(ctx, declareMsg.Identifier, declareMsg.Owner, applyLen, declareMsg.Bond.Amount)
+		err2 := keeper.AddBallot(ctx, declareMsg.Identifier, declareMsg.Owner, applyLen, declareMsg.Bond.Amount)
 		if err2 != nil {
 			return err2.Result()
 		}
-		return sdk.Result{}
+		tags := sdk.NewTags(
+			types.TagAction, []byte("declare_candidacy"),
+			types.TagListing, []byte(declareMsg.Identifier),
+			types.TagOwner, []byte(declareMsg.Owner.String()),
+			types.TagAmount, types.AmountTag(declareMsg.Bond.Amount),
+		)
+		tags, err = chargeFee(ctx, keeper, declareMsg.Owner, fees.ApplicationFee, fees.Treasury, tags)
+		if err != nil {
+			return err.Result()
+		}
+		keeper.AppendHistory(ctx, declareMsg.Identifier, types.HistoryEvent{
+			Action: "declare_candidacy",
+			Actor: declareMsg.Owner,
+			Amount: declareMsg.Bond.Amount,
+		})
+		return sdk.Result{
+			Tags: tags,
+		}
 	}
 }
 
-func NewChallengeHandler(accountKeeper bank.Keeper, ballotMapper db.BallotMapper, commitLen int64, revealLen int64, minBond int64) sdk.Handler {
+func NewChallengeHandler(keeper db.Keeper, commitLen int64, revealLen int64, minBond int64) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		challengeMsg := msg.(types.ChallengeMsg)
-		_, _, err := accountKeeper.SubtractCoins(ctx, challengeMsg.Owner, []sdk.Coin{challengeMsg.Bond})
+		if err := checkDenom(keeper, challengeMsg.Bond); err != nil {
+			return err.Result()
+		}
+		err := keeper.Lock(ctx, challengeMsg.Owner, challengeMsg.Bond.Amount)
 		if err != nil {
 			return err.Result()
 		}
 
-		store := ctx.KVStore(ballotMapper.BallotKey)
-		key := []byte(challengeMsg.Identifier)
-		bz := store.Get(key)
-
-		if bz == nil {
-			return sdk.NewError(2, 108, "Candidate with given identifier does not exist").Result()
-		}
-		ballot := &types.Ballot{}
-		err2 := ballotMapper.Cdc.UnmarshalBinary(bz, ballot)
-		if err2 != nil {
-			panic(err2)
+		ballot := keeper.GetBallot(ctx, challengeMsg.Identifier)
+		if reflect.DeepEqual(ballot, types.Ballot{}) {
+			return types.ErrUnknownCandidate("").Result()
 		}
 
-		if ballot.EndCommitBlockStamp != 0  {
-			return sdk.NewError(2, 111, "Candidate has already been challenged").Result()
+		if ballot.Challenged() {
+			return types.ErrAlreadyChallenged("").Result()
 		}
 
 		if challengeMsg.Bond.Amount < ballot.Bond {
-			return sdk.NewError(2, 115, "Must match candidate bond to challenge").Result()
+			return types.ErrChallengeBondTooLow("").Result()
 		}
 
-		err3 := ballotMapper.ActivateBallot(ctx, accountKeeper, ballot.Owner, challengeMsg.Owner, challengeMsg.Identifier, commitLen, revealLen, minBond, challengeMsg.Bond.Amount)
+		now := ballot.Now(ctx)
+		underBonded := ballot.Bond < minBond
+		next := types.StatusCommit
+		if underBonded {
+			next = types.StatusRemoved
+		}
+		previous := ballot
+		err3 := ballot.Transition(next, now)
 		if err3 != nil {
 			return err3.Result()
 		}
-		return sdk.Result{}
+		if !underBonded && ballot.Bond != challengeMsg.Bond.Amount {
+			return types.ErrChallengeBondMismatch("").Result()
+		}
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("challenge"),
+			types.TagListing, []byte(challengeMsg.Identifier),
+			types.TagOwner, []byte(ballot.Owner.String()),
+			types.TagChallenger, []byte(challengeMsg.Owner.String()),
+			types.TagAmount, types.AmountTag(challengeMsg.Bond.Amount),
+		)
+		event := types.HistoryEvent{
+			Action: "challenge",
+			Actor: challengeMsg.Owner,
+			Amount: challengeMsg.Bond.Amount,
+		}
+		if underBonded {
+			// Candidate bond is below the current minimum, so it is removed
+			// instead. Both bonds go back to whoever posted them.
+			keeper.ArchiveListing(ctx, ballot, types.RemovedUnderBonded)
+			err = keeper.Release(ctx, challengeMsg.Owner, challengeMsg.Bond.Amount)
+			if err != nil {
+				return err.Result()
+			}
+			err = keeper.Release(ctx, ballot.Owner, ballot.Bond)
+			if err != nil {
+				return err.Result()
+			}
+			tags = tags.AppendTag(types.TagOutcome, []byte(types.OutcomeUnderBonded))
+			tags = tags.AppendTag(types.TagRefund, types.AmountTag(ballot.Bond))
+			event.Outcome = types.OutcomeUnderBonded
+			ballot.Bond = 0
+			keeper.SetBallot(ctx, ballot)
+		} else {
+			if previous.PollID != "" {
+				// Listing survived an earlier challenge. Its poll is archived for
+				// the claims still open on it and the new poll starts afresh.
+				keeper.ArchivePoll(ctx, previous)
+				event.Action = "rechallenge"
+				ballot.Approve = 0
+				ballot.Deny = 0
+				ballot.EndAppealBlockStamp = 0
+				ballot.Appealed = false
+				ballot.Appellant = nil
+				ballot.AppealBond = 0
+				ballot.PrevPollID = ""
+				ballot.Pool = 0
+				ballot.PoolPaid = 0
+				ballot.PowerClaimed = 0
+			}
+			ballot.Settled = false
+			ballot.Challenger = challengeMsg.Owner
+			ballot.PollID = types.NewPollID(challengeMsg.Identifier, ctx.BlockHeight())
+			ballot.Reason = challengeMsg.Reason
+			ballot.Evidence = challengeMsg.Evidence
+			ballot.EndCommitBlockStamp = now + commitLen
+			ballot.EndRevealBlockStamp = ballot.EndCommitBlockStamp + revealLen
+			keeper.SetBallot(ctx, ballot)
+
+			tags = tags.AppendTag(types.TagPoll, []byte(ballot.PollID))
+			event.PollID = ballot.PollID
+		}
+		keeper.AppendHistory(ctx, challengeMsg.Identifier, event)
+		return sdk.Result{
+			Tags: tags,
+		}
 	}
 }
 
-func NewCommitHandler(cdc *amino.Codec, ballotKey, commitKey sdk.StoreKey) sdk.Handler {
+func NewCommitHandler(keeper db.Keeper) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		commitMsg := msg.(types.CommitMsg)
 
-		store := ctx.KVStore(ballotKey)
-		key := []byte(commitMsg.Identifier)
-		bz := store.Get(key)
-		
-		if bz == nil {
-			return sdk.NewError(2, 108, "Candidate with given identifier does not exist").Result()
-		}
-		candidate := &types.Ballot{}
-		err2 := cdc.UnmarshalBinary(bz, candidate)
-		if err2 != nil {
-			panic(err2)
+		candidate := keeper.GetBallot(ctx, commitMsg.Identifier)
+		if reflect.DeepEqual(candidate, types.Ballot{}) {
+			return types.ErrUnknownCandidate("").Result()
 		}
 
-		if candidate.EndCommitBlockStamp == 0 || candidate.EndCommitBlockStamp < ctx.BlockHeight() {
-			return sdk.NewError(2, 112, "Candidate not in commit phase").Result()
+		if !candidate.CommitOpen(candidate.Now(ctx)) {
+			return types.ErrNotCommitPhase("").Result()
 		}
 
-		commitStore := ctx.KVStore(commitKey)
-
 		voter := types.Voter{
 			Owner: commitMsg.Owner,
 			Identifier: commitMsg.Identifier,
+			PollID: candidate.PollID,
+		}
+		keeper.SetCommitment(ctx, voter, commitMsg.Commitment)
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("commit"),
+			types.TagListing, []byte(commitMsg.Identifier),
+			types.TagVoter, []byte(commitMsg.Owner.String()),
+			types.TagPoll, []byte(candidate.PollID),
+		)
+		keeper.AppendHistory(ctx, commitMsg.Identifier, types.HistoryEvent{
+			Action: "commit",
+			Actor: commitMsg.Owner,
+			PollID: candidate.PollID,
+		})
+		return sdk.Result{
+			Tags: tags,
 		}
-		voterKey, _ := cdc.MarshalBinary(voter)
-		commitStore.Set(voterKey, commitMsg.Commitment)
-		return sdk.Result{}
 	}
 }
 
-func NewRevealHandler(accountKeeper bank.Keeper, ballotMapper db.BallotMapper) sdk.Handler {
+func NewRevealHandler(keeper db.Keeper) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		revealMsg := msg.(types.RevealMsg)
-		_, _, err := accountKeeper.SubtractCoins(ctx, revealMsg.Owner, []sdk.Coin{revealMsg.Bond})
+		if err := checkDenom(keeper, revealMsg.Bond); err != nil {
+			return err.Result()
+		}
+		err := keeper.Lock(ctx, revealMsg.Owner, revealMsg.Bond.Amount)
 		if err != nil {
 			return err.Result()
 		}
 
-		store := ctx.KVStore(ballotMapper.BallotKey)
-		key := []byte(revealMsg.Identifier)
-		bz := store.Get(key)
-		
-		if bz == nil {
-			return sdk.NewError(2, 108, "Candidate with given identifier does not exist").Result()
-		}
-		candidate := &types.Ballot{}
-		err2 := ballotMapper.Cdc.UnmarshalBinary(bz, candidate)
-		if err2 != nil {
-			panic(err2)
+		candidate := keeper.GetBallot(ctx, revealMsg.Identifier)
+		if reflect.DeepEqual(candidate, types.Ballot{}) {
+			return types.ErrUnknownCandidate("").Result()
 		}
 
-		if candidate.EndCommitBlockStamp > ctx.BlockHeight() || candidate.EndRevealBlockStamp < ctx.BlockHeight() {
-			return sdk.NewError(2, 112, "Candidate not in reveal phase").Result()
+		if !candidate.RevealOpen(candidate.Now(ctx)) {
+			return types.ErrNotRevealPhase("").Result()
 		}
 
-		commitStore := ctx.KVStore(ballotMapper.CommitKey)
-		revealStore := ctx.KVStore(ballotMapper.RevealKey)
-		
 		voter := types.Voter{
 			Owner: revealMsg.Owner,
 			Identifier: revealMsg.Identifier,
+			PollID: candidate.PollID,
 		}
-		voterKey, _ := ballotMapper.Cdc.MarshalBinary(voter)
-		if revealStore.Get(voterKey) != nil {
-			return sdk.NewError(2, 128, "Cannot vote more than once").Result()
+		// A direct vote replaces one cast with the owner's delegated power
+		if previous, ok := keeper.GetReveal(ctx, voter); ok {
+			if previous.Delegate == nil {
+				return types.ErrDuplicateVote("").Result()
+			}
+			err = keeper.Release(ctx, revealMsg.Owner, previous.Power)
+			if err != nil {
+				return err.Result()
+			}
+			err = keeper.VoteBallot(ctx, revealMsg.Owner, revealMsg.Identifier, previous.Choice, -previous.Power)
+			if err != nil {
+				return err.Result()
+			}
 		}
 
-		commitment := commitStore.Get(voterKey)
-		
-		hasher := sha256.New()
-		vz, _ := ballotMapper.Cdc.MarshalBinary(revealMsg.Vote)
-		hasher.Sum(vz)
-		val := hasher.Sum(revealMsg.Nonce)
-
-		if (!reflect.DeepEqual(val, commitment)) {
-			return sdk.NewError(2, 106, "Vote does not match commitment").Result()
+		commitment := keeper.GetCommitment(ctx, voter)
+		if !types.CommitmentMatches(commitment, voter.Identifier, voter.PollID, revealMsg.Vote, revealMsg.Nonce) {
+			return types.ErrVoteMismatch("").Result()
 		}
 
-		reveal := types.Vote{
+		keeper.DeleteCommitment(ctx, voter)
+		keeper.SetReveal(ctx, voter, types.Vote{
 			Choice: revealMsg.Vote,
 			Power: revealMsg.Bond.Amount,
+		})
+		keeper.AddPendingClaim(ctx, revealMsg.Owner, revealMsg.Identifier)
+
+		err3 := keeper.VoteBallot(ctx, revealMsg.Owner, revealMsg.Identifier, revealMsg.Vote, revealMsg.Bond.Amount)
+		if err3 != nil {
+			return err3.Result()
 		}
-		revealVal, _ := ballotMapper.Cdc.MarshalBinary(reveal)
-	
-		commitStore.Delete(voterKey)
-		revealStore.Set(voterKey, revealVal)
+		keeper.AppendHistory(ctx, revealMsg.Identifier, types.HistoryEvent{
+			Action: "reveal",
+			Actor: revealMsg.Owner,
+			PollID: candidate.PollID,
+			Amount: revealMsg.Bond.Amount,
+		})
 
-		err3 := ballotMapper.VoteBallot(ctx, revealMsg.Owner, revealMsg.Identifier, revealMsg.Vote, revealMsg.Bond.Amount)
-		if err2 != nil {
+		delegated, err3 := castDelegatedVotes(ctx, keeper, revealMsg.Owner, voter, revealMsg.Vote)
+		if err3 != nil {
 			return err3.Result()
 		}
-		return sdk.Result{}
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("reveal"),
+			types.TagListing, []byte(revealMsg.Identifier),
+			types.TagVoter, []byte(revealMsg.Owner.String()),
+			types.TagPoll, []byte(candidate.PollID),
+			types.TagAmount, types.AmountTag(revealMsg.Bond.Amount),
+		)
+		if delegated > 0 {
+			tags = tags.AppendTag(types.TagDelegated, types.AmountTag(delegated))
+		}
+		return sdk.Result{
+			Tags: tags,
+		}
 	}
 }
 
-func NewApplyHandler(accountKeeper bank.Keeper, ballotMapper db.BallotMapper, listingKey sdk.StoreKey, quorum float64, dispPct float64) sdk.Handler {
+func NewApplyHandler(keeper db.Keeper, quorum types.Ratio, dispPct types.Ratio, appeal types.AppealParams) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		applyMsg := msg.(types.ApplyMsg)
 
-		store := ctx.KVStore(ballotMapper.BallotKey)
-		key := []byte(applyMsg.Identifier)
-		val := store.Get(key)
-		ballot := &types.Ballot{}
-		err := ballotMapper.Cdc.UnmarshalBinary(val, ballot)
-		if err != nil {
-			panic(err)
+		ballot := keeper.GetBallot(ctx, applyMsg.Identifier)
+		if reflect.DeepEqual(ballot, types.Ballot{}) {
+			return types.ErrUnknownCandidate("").Result()
 		}
 
-		registry := ctx.KVStore(listingKey)
+		tags := sdk.NewTags(
+			types.TagAction, []byte("apply"),
+			types.TagListing, []byte(applyMsg.Identifier),
+			types.TagOwner, []byte(ballot.Owner.String()),
+		)
 
-		if ballot.Active {
-			if ctx.BlockHeight() < ballot.EndRevealBlockStamp {
-				return sdk.NewError(2, 120, "Cannot apply until reveal phase ends").Result()
+		now := ballot.Now(ctx)
+		switch ballot.StatusAt(now) {
+		case types.StatusApplying:
+			err := ballot.Transition(types.StatusAccepted, now)
+			if err != nil {
+				return err.Result()
 			}
-		} else {
-			if ctx.BlockHeight() < ballot.EndApplyBlockStamp {
-				return sdk.NewError(2, 120, "Cannot apply until application phase ends").Result()
-			} else {
-				listing := types.Listing{
-					Identifier: ballot.Identifier,
-					Votes: 0,
-				}
-				val, _ := ballotMapper.Cdc.MarshalBinary(listing)
-				registry.Set(key, val)
-				return sdk.Result{}
+			// Nothing to pay out without a challenge
+			ballot.Settled = true
+			keeper.SetBallot(ctx, ballot)
+			keeper.AddListing(ctx, ballot.Identifier, 0, ballot.Bond)
+			keeper.AppendHistory(ctx, ballot.Identifier, types.HistoryEvent{
+				Action: "apply",
+				Actor: applyMsg.Owner,
+				Outcome: types.OutcomeListed,
+			})
+			return sdk.Result{
+				Tags: tags.AppendTag(types.TagOutcome, []byte(types.OutcomeListed)),
 			}
+		case types.StatusCouncil:
+			return types.ErrAwaitingCouncil("").Result()
 		}
 
-		total := ballot.Approve + ballot.Deny
+		tags = tags.AppendTag(types.TagChallenger, []byte(ballot.Challenger.String()))
+		tags = tags.AppendTag(types.TagPoll, []byte(ballot.PollID))
 
-		if float64(ballot.Approve) / float64(total) > quorum {
-			listing := types.Listing{
-				Identifier: ballot.Identifier,
-				Votes: ballot.Approve,
+		// Decision already made, pay out once nobody appealed it in time
+		if ballot.Decided() && !ballot.Settled {
+			if now < ballot.EndAppealBlockStamp {
+				return types.ErrAppealPhaseNotEnded("").Result()
 			}
-			entry, _ := ballotMapper.Cdc.MarshalBinary(listing)
-			registry.Set(key, entry)
+			return finalizeBallot(ctx, keeper, &ballot, dispPct, tags, applyMsg.Owner)
+		}
 
-			reward := sdk.Coin{
-				Denom: "RegistryCoin",
-				Amount: int64(float64(ballot.Bond) * dispPct),
-			}
-			_, _, err := accountKeeper.AddCoins(ctx, ballot.Owner, []sdk.Coin{reward})
+		// Appeal votes need a supermajority to accept the listing
+		threshold := quorum
+		if ballot.Appealed {
+			threshold = appeal.Quorum
+		}
+		// A poll without votes rejects the listing. Tallying a poll that is
+		// still open, or a settled ballot, is not a legal move.
+		accepted := threshold.ExceededBy(ballot.Approve, ballot.Approve + ballot.Deny)
+		err := ballot.Transition(types.DecisionStatus(accepted), now)
+		if err != nil {
+			return err.Result()
+		}
 
-			if err != nil {
-				return err.Result()
+		if appeal.AppealLen == 0 || ballot.Appealed {
+			return finalizeBallot(ctx, keeper, &ballot, dispPct, tags, applyMsg.Owner)
+		}
+
+		// Registry reflects the decision right away but bonds stay locked until the appeal window closes
+		setListing(ctx, keeper, &ballot)
+		ballot.EndAppealBlockStamp = now + appeal.AppealLen
+		keeper.SetBallot(ctx, ballot)
+		keeper.AppendHistory(ctx, ballot.Identifier, types.HistoryEvent{
+			Action: "resolve",
+			Actor: applyMsg.Owner,
+			PollID: ballot.PollID,
+			Outcome: outcome(ballot.Accepted()),
+		})
+
+		return sdk.Result{
+			Tags: tags.AppendTag(types.TagOutcome, []byte(outcome(ballot.Accepted()))),
+		}
+	}
+}
+
+func NewAppealHandler(keeper db.Keeper, appeal types.AppealParams) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		appealMsg := msg.(types.AppealMsg)
+		if err := checkDenom(keeper, appealMsg.Bond); err != nil {
+			return err.Result()
+		}
+
+		ballot := keeper.GetBallot(ctx, appealMsg.Identifier)
+		if reflect.DeepEqual(ballot, types.Ballot{}) {
+			return types.ErrUnknownCandidate("").Result()
+		}
+
+		now := ballot.Now(ctx)
+		if !ballot.Appealable(now) {
+			return types.ErrNotAppealable("").Result()
+		}
+
+		if !bytes.Equal(appealMsg.Owner, ballot.Owner) && !bytes.Equal(appealMsg.Owner, ballot.Challenger) {
+			return types.ErrNotParty("").Result()
+		}
+
+		if appealMsg.Bond.Amount < appeal.BondFactor * ballot.Bond {
+			return types.ErrAppealBondTooLow(fmt.Sprintf("Appeal bond must be at least %d", appeal.BondFactor * ballot.Bond)).Result()
+		}
+
+		err := keeper.Lock(ctx, appealMsg.Owner, appealMsg.Bond.Amount)
+		if err != nil {
+			return err.Result()
+		}
+
+		next := types.StatusCommit
+		if len(appeal.Council) > 0 {
+			next = types.StatusCouncil
+		}
+		err2 := ballot.Transition(next, now)
+		if err2 != nil {
+			return err2.Result()
+		}
+		ballot.Appealed = true
+		ballot.Appellant = appealMsg.Owner
+		ballot.AppealBond = appealMsg.Bond.Amount
+
+		if next == types.StatusCommit {
+			// Open a second, longer poll. Votes on the first poll are only refunded
+			ballot.PrevPollID = ballot.PollID
+			ballot.PollID = types.NewPollID(ballot.Identifier, ctx.BlockHeight())
+			ballot.Approve = 0
+			ballot.Deny = 0
+			ballot.EndCommitBlockStamp = now + appeal.CommitLen
+			ballot.EndRevealBlockStamp = ballot.EndCommitBlockStamp + appeal.RevealLen
+		}
+		keeper.SetBallot(ctx, ballot)
+		keeper.AppendHistory(ctx, appealMsg.Identifier, types.HistoryEvent{
+			Action: "appeal",
+			Actor: appealMsg.Owner,
+			PollID: ballot.PollID,
+			Amount: appealMsg.Bond.Amount,
+		})
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("appeal"),
+			types.TagListing, []byte(appealMsg.Identifier),
+			types.TagOwner, []byte(ballot.Owner.String()),
+			types.TagChallenger, []byte(ballot.Challenger.String()),
+			types.TagPoll, []byte(ballot.PollID),
+			types.TagAmount, types.AmountTag(appealMsg.Bond.Amount),
+		)
+		return sdk.Result{
+			Tags: tags,
+		}
+	}
+}
+
+func NewAppealDecisionHandler(keeper db.Keeper, dispPct types.Ratio, appeal types.AppealParams) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		decisionMsg := msg.(types.AppealDecisionMsg)
+
+		ballot := keeper.GetBallot(ctx, decisionMsg.Identifier)
+		if ballot.Status != types.StatusCouncil {
+			return types.ErrNoCouncilReview("").Result()
+		}
+
+		// Ante handler has verified every signer, so only membership and majority are left
+		for _, signer := range decisionMsg.Signers {
+			member := false
+			for _, councillor := range appeal.Council {
+				if bytes.Equal(signer, councillor) {
+					member = true
+				}
+			}
+			if !member {
+				return types.ErrNotCouncil(fmt.Sprintf("%v is not on the council", signer)).Result()
 			}
+		}
+		if len(decisionMsg.Signers) * 2 <= len(appeal.Council) {
+			return types.ErrNotCouncil("").Result()
+		}
 
-		} else {
-			registry.Delete(key)
+		err := ballot.Transition(types.DecisionStatus(decisionMsg.Approve), ballot.Now(ctx))
+		if err != nil {
+			return err.Result()
+		}
 
-			// Challenger receives his original bond as well as dispPct of applier bond
-			reward := sdk.Coin{
-				Denom: "RegistryCoin",
-				Amount: int64(float64(ballot.Bond) * dispPct) + ballot.Bond,
+		tags := sdk.NewTags(
+			types.TagAction, []byte("appeal_decision"),
+			types.TagListing, []byte(decisionMsg.Identifier),
+			types.TagOwner, []byte(ballot.Owner.String()),
+			types.TagChallenger, []byte(ballot.Challenger.String()),
+			types.TagPoll, []byte(ballot.PollID),
+		)
+		return finalizeBallot(ctx, keeper, &ballot, dispPct, tags, nil)
+	}
+}
+
+// Write the final decision on a challenged ballot to the registry and pay out
+// the owner, challenger and appellant. The winning party gets dispPct of the
+// losing bond rounded down, the rest is the pool for voters on the winning side.
+// With no votes on the winning side the pool goes to the winning party too.
+// The decision is logged in the listing's history as made by actor, nil for
+// the appeal council.
+func finalizeBallot(ctx sdk.Context, keeper db.Keeper, ballot *types.Ballot, dispPct types.Ratio, tags sdk.Tags, actor sdk.Address) sdk.Result {
+	setListing(ctx, keeper, ballot)
+
+	dispensation := dispPct.MulFloor(ballot.Bond)
+	ballot.Pool = ballot.Bond - dispensation
+
+	var recipient sdk.Address
+	var amount, winningVotes int64
+	if ballot.Accepted() {
+		recipient = ballot.Owner
+		amount = dispensation
+		winningVotes = ballot.Approve
+	} else {
+		// Challenger receives his original bond as well as dispPct of applier bond
+		recipient = ballot.Challenger
+		amount = dispensation + ballot.Bond
+		winningVotes = ballot.Deny
+	}
+	if winningVotes == 0 {
+		amount += ballot.Pool
+		ballot.Pool = 0
+	}
+	err := keeper.Release(ctx, recipient, amount)
+	if err != nil {
+		return err.Result()
+	}
+	tags = tags.AppendTag(types.TagOutcome, []byte(outcome(ballot.Accepted())))
+	tags = tags.AppendTag(types.TagAmount, types.AmountTag(amount))
+
+	// Appellant gets the appeal bond back if the final decision went their way,
+	// otherwise it goes to the other party
+	if ballot.Appellant != nil {
+		appellantIsOwner := bytes.Equal(ballot.Appellant, ballot.Owner)
+		recipient = ballot.Appellant
+		if appellantIsOwner != ballot.Accepted() {
+			if appellantIsOwner {
+				recipient = ballot.Challenger
+			} else {
+				recipient = ballot.Owner
 			}
-			_, _, err := accountKeeper.AddCoins(ctx, ballot.Challenger, []sdk.Coin{reward})
+		}
+		err := keeper.Release(ctx, recipient, ballot.AppealBond)
+		if err != nil {
+			return err.Result()
+		}
+	}
 
-			if err != nil {
-				return err.Result()
+	ballot.Settled = true
+	keeper.SetBallot(ctx, *ballot)
+	keeper.AppendHistory(ctx, ballot.Identifier, types.HistoryEvent{
+		Action: "finalize",
+		Actor: actor,
+		PollID: ballot.PollID,
+		Outcome: outcome(ballot.Accepted()),
+		Amount: amount,
+	})
+
+	return sdk.Result{
+		Tags: tags,
+	}
+}
+
+// Add or remove the ballot's listing according to its decision
+func setListing(ctx sdk.Context, keeper db.Keeper, ballot *types.Ballot) {
+	if !ballot.Accepted() {
+		keeper.ArchiveListing(ctx, *ballot, types.RemovedLostChallenge)
+		return
+	}
+	keeper.AddListing(ctx, ballot.Identifier, ballot.Approve, ballot.Bond)
+}
+
+// Vote the power delegated to the revealing delegate with the delegate's choice
+// on the same poll. Each delegator's power is staked from their own balance
+// and recorded as their reveal, so they claim the refund and reward pro rata.
+// Delegators who revealed already, or can no longer cover the delegated
+// power, are skipped.
+func castDelegatedVotes(ctx sdk.Context, keeper db.Keeper, delegate sdk.Address, delegateVoter types.Voter, choice bool) (int64, sdk.Error) {
+	var total int64
+	for _, delegation := range keeper.Delegations(ctx, delegate) {
+		voter := types.Voter{
+			Owner: delegation.Delegator,
+			Identifier: delegateVoter.Identifier,
+			PollID: delegateVoter.PollID,
+		}
+		if _, ok := keeper.GetReveal(ctx, voter); ok {
+			continue
+		}
+		if keeper.Lock(ctx, delegation.Delegator, delegation.Power) != nil {
+			continue
+		}
+		keeper.SetReveal(ctx, voter, types.Vote{
+			Choice: choice,
+			Power: delegation.Power,
+			Delegate: delegate,
+		})
+		keeper.AddPendingClaim(ctx, delegation.Delegator, voter.Identifier)
+		keeper.AppendHistory(ctx, voter.Identifier, types.HistoryEvent{
+			Action: "delegated_reveal",
+			Actor: delegation.Delegator,
+			PollID: voter.PollID,
+			Amount: delegation.Power,
+		})
+		total += delegation.Power
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return total, keeper.VoteBallot(ctx, delegate, delegateVoter.Identifier, choice, total)
+}
+
+// Delegate voting power on the registry to a curator, or revoke the delegation
+func NewDelegateVotingHandler(keeper db.Keeper) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		delegateMsg := msg.(types.DelegateVotingMsg)
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("delegate_voting"),
+			types.TagOwner, []byte(delegateMsg.Owner.String()),
+		)
+
+		if len(delegateMsg.Delegate) == 0 {
+			if _, ok := keeper.GetDelegation(ctx, delegateMsg.Owner); !ok {
+				return types.ErrNoDelegation("").Result()
+			}
+			keeper.DeleteDelegation(ctx, delegateMsg.Owner)
+			return sdk.Result{
+				Tags: tags,
 			}
 		}
 
-		ballot.Active = false
-		val, _ = ballotMapper.Cdc.MarshalBinary(ballot)
-		store.Set(key, val)
+		if err := checkDenom(keeper, delegateMsg.Power); err != nil {
+			return err.Result()
+		}
+		keeper.SetDelegation(ctx, types.Delegation{
+			Delegator: delegateMsg.Owner,
+			Delegate: delegateMsg.Delegate,
+			Power: delegateMsg.Power.Amount,
+		})
 
-		return sdk.Result{}
+		tags = tags.AppendTag(types.TagDelegate, []byte(delegateMsg.Delegate.String()))
+		tags = tags.AppendTag(types.TagAmount, types.AmountTag(delegateMsg.Power.Amount))
+		return sdk.Result{
+			Tags: tags,
+		}
 	}
 }
 
-func NewClaimRewardHandler(cdc *amino.Codec, accountKeeper bank.Keeper, ballotKey sdk.StoreKey, revealKey sdk.StoreKey, listingKey sdk.StoreKey, dispPct float64) sdk.Handler {
+// Extend a listing by the registry's listing lifetime, charging the renewal
+// fee. A lapsed listing runs for one lifetime from now, and a listing cannot
+// be renewed while more than a lifetime is left on it.
+func NewRenewListingHandler(keeper db.Keeper, fees types.FeeParams) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
-		claimMsg := msg.(types.ClaimRewardMsg)
-		revealStore := ctx.KVStore(revealKey)
+		renewMsg := msg.(types.RenewListingMsg)
 
-		voter := types.Voter{
-			Owner: claimMsg.Owner,
-			Identifier: claimMsg.Identifier,
+		if keeper.ListingLifetime() == 0 {
+			return types.ErrNoExpiry("").Result()
+		}
+		listing := keeper.GetListing(ctx, renewMsg.Identifier)
+		if listing.Identifier == "" {
+			return types.ErrNotListed("").Result()
+		}
+		ballot := keeper.GetBallot(ctx, renewMsg.Identifier)
+		if !bytes.Equal(ballot.Owner, renewMsg.Owner) {
+			return types.ErrNotListingOwner("").Result()
+		}
+		if listing.Expiry - keeper.Now(ctx) > keeper.ListingLifetime() {
+			return types.ErrRenewalTooEarly("").Result()
 		}
-		key, _ := cdc.MarshalBinary(voter)
-		bz := revealStore.Get(key)
 
-		vote := &types.Vote{}
-		err := cdc.UnmarshalBinary(bz, vote)
+		tags := sdk.NewTags(
+			types.TagAction, []byte("renew_listing"),
+			types.TagListing, []byte(renewMsg.Identifier),
+			types.TagOwner, []byte(renewMsg.Owner.String()),
+		)
+		tags, err := chargeFee(ctx, keeper, renewMsg.Owner, fees.RenewalFee, fees.Treasury, tags)
 		if err != nil {
-			panic(err)
+			return err.Result()
 		}
-		
-		registry := ctx.KVStore(listingKey)
-		val := registry.Get([]byte(claimMsg.Identifier))
 
-		ballotStore := ctx.KVStore(ballotKey)
-		listKey := []byte(claimMsg.Identifier)
-		ballot := &types.Ballot{}
-		lz := ballotStore.Get(listKey)
+		listing = keeper.RenewListing(ctx, renewMsg.Identifier)
+		keeper.AppendHistory(ctx, renewMsg.Identifier, types.HistoryEvent{
+			Action: "renew_listing",
+			Actor: renewMsg.Owner,
+			Amount: fees.RenewalFee,
+		})
+		return sdk.Result{
+			Tags: tags.AppendTag(types.TagExpiry, types.AmountTag(listing.Expiry)),
+		}
+	}
+}
 
-		err = cdc.UnmarshalBinary(lz, ballot)
-		if err != nil {
-			panic(err)
-		} 
+// Charge a non-refundable fee, sent to the treasury or burned if there is none
+func chargeFee(ctx sdk.Context, keeper db.Keeper, payer sdk.Address, fee int64, treasury sdk.Address, tags sdk.Tags) (sdk.Tags, sdk.Error) {
+	if fee == 0 {
+		return tags, nil
+	}
+	err := keeper.Lock(ctx, payer, fee)
+	if err != nil {
+		return tags, err
+	}
+	tags = tags.AppendTag(types.TagFee, types.AmountTag(fee))
+	if len(treasury) == 0 {
+		return tags.AppendTag(types.TagTreasury, []byte(types.TreasuryBurned)), nil
+	}
+	err = keeper.Release(ctx, treasury, fee)
+	if err != nil {
+		return tags, err
+	}
+	return tags.AppendTag(types.TagTreasury, []byte(treasury.String())), nil
+}
+
+// Bonds must be in the staking denom of the mapper's registry
+func checkDenom(keeper db.Keeper, bond sdk.Coin) sdk.Error {
+	if bond.Denom != keeper.StakingDenom() {
+		return types.ErrInvalidBond(fmt.Sprintf("Bond must be in %s", keeper.StakingDenom()))
+	}
+	return nil
+}
+
+func outcome(decision bool) string {
+	if decision {
+		return types.OutcomeAccepted
+	}
+	return types.OutcomeRejected
+}
+
+func NewClaimRewardHandler(keeper db.Keeper) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		claimMsg := msg.(types.ClaimRewardMsg)
 
-		if ballot.Active {
-			return sdk.NewError(2, 130, "Cannot claim reward until after ballot vote is applied").Result()
+		ballot := keeper.GetBallot(ctx, claimMsg.Identifier)
+		if reflect.DeepEqual(ballot, types.Ballot{}) {
+			return types.ErrUnknownCandidate("").Result()
 		}
 
-		var decision bool
-		if val == nil {
-			decision = false 
-		} else {
-			decision = true
+		if ballot.Challenged() {
+			return types.ErrBallotNotApplied("").Result()
+		}
+
+		paid, result, err := claimReward(ctx, keeper, claimMsg.Owner, ballot)
+		if err != nil {
+			return err.Result()
+		}
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("claim_reward"),
+			types.TagListing, []byte(claimMsg.Identifier),
+			types.TagVoter, []byte(claimMsg.Owner.String()),
+			types.TagPoll, []byte(ballot.PollID),
+			types.TagOutcome, []byte(result),
+			types.TagAmount, types.AmountTag(paid),
+		)
+		return sdk.Result{
+			Tags: tags,
 		}
+	}
+}
 
-		if vote.Choice != decision {
-			refund := sdk.Coin{
-				Denom: "RegistryCoin",
-				Amount: vote.Power,
+// Claim on every applied ballot the sender revealed on, up to types.MaxClaimsPerTx.
+// Ballots still being voted on or appealed are left for a later claim.
+func NewClaimAllRewardsHandler(keeper db.Keeper) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		claimMsg := msg.(types.ClaimAllRewardsMsg)
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("claim_all_rewards"),
+			types.TagVoter, []byte(claimMsg.Owner.String()),
+		)
+
+		var total int64
+		claims := 0
+		for _, identifier := range keeper.PendingClaims(ctx, claimMsg.Owner) {
+			if claims == types.MaxClaimsPerTx {
+				break
+			}
+			ballot := keeper.GetBallot(ctx, identifier)
+			if ballot.Challenged() {
+				continue
+			}
+			paid, _, err := claimReward(ctx, keeper, claimMsg.Owner, ballot)
+			if err != nil {
+				return err.Result()
 			}
-			accountKeeper.AddCoins(ctx, claimMsg.Owner, []sdk.Coin{refund})
-			return sdk.Result{}
+			total += paid
+			claims++
+			tags = tags.AppendTag(types.TagListing, []byte(identifier))
+			tags = tags.AppendTag(types.TagPoll, []byte(ballot.PollID))
 		}
 
-		var pool, total int64
-		pool = int64(float64(ballot.Bond) * (float64(1.0) - dispPct))
-		if decision {
-			total = ballot.Approve
-		} else {
-			total = ballot.Deny
+		if claims == 0 {
+			return types.ErrNoVote("No applied ballots with unclaimed votes").Result()
 		}
 
-		reward := sdk.Coin{
-			Denom: "RegistryCoin",
-			Amount: vote.Power + int64(float64(pool) * float64(vote.Power) / float64(total)),
+		tags = tags.AppendTag(types.TagAmount, types.AmountTag(total))
+		return sdk.Result{
+			Tags: tags,
+		}
+	}
+}
+
+// Pay the voter for their unclaimed reveals on the polls of an applied ballot
+// and on the archived polls of its listing, record each poll in the claim
+// ledger and prune the reveals. Votes on a poll superseded by an appeal, and
+// votes against the decision, are only refunded.
+func claimReward(ctx sdk.Context, keeper db.Keeper, owner sdk.Address, ballot types.Ballot) (int64, string, sdk.Error) {
+	tally := claimTally{result: types.OutcomeRefunded}
+	for _, poll := range keeper.ArchivedPolls(ctx, ballot.Identifier) {
+		if poll, changed := claimPolls(ctx, keeper, owner, poll, &tally); changed {
+			keeper.ArchivePoll(ctx, poll)
 		}
-		_, _, accErr := accountKeeper.AddCoins(ctx, claimMsg.Owner, []sdk.Coin{reward})
+	}
+	if current, changed := claimPolls(ctx, keeper, owner, ballot, &tally); changed {
+		keeper.SetBallot(ctx, current)
+	}
 
-		if accErr != nil {
-			return accErr.Result()
+	if !tally.revealed {
+		if tally.claimed {
+			return 0, "", types.ErrAlreadyClaimed("")
 		}
+		return 0, "", types.ErrNoVote("")
+	}
 
-		return sdk.Result{}
+	err := keeper.Release(ctx, owner, tally.paid)
+	if err != nil {
+		return 0, "", err
 	}
+	keeper.DeletePendingClaim(ctx, owner, ballot.Identifier)
+	keeper.AppendHistory(ctx, ballot.Identifier, types.HistoryEvent{
+		Action: "claim_reward",
+		Actor: owner,
+		PollID: ballot.PollID,
+		Outcome: tally.result,
+		Amount: tally.paid,
+	})
+	return tally.paid, tally.result, nil
+}
+
+// What a claim paid so far, across the polls it went through
+type claimTally struct {
+	paid int64
+	result string
+	revealed bool
+	claimed bool
 }
 
+// Claim the voter's reveals on the polls of one ballot into tally. Winning
+// votes share the pool pro rata, rounded down. The last winning voter to
+// claim gets whatever is left, so the pool is always paid out exactly.
+// Returns the ballot and whether its pool changed.
+func claimPolls(ctx sdk.Context, keeper db.Keeper, owner sdk.Address, ballot types.Ballot, tally *claimTally) (types.Ballot, bool) {
+	polls := []string{ballot.PollID}
+	if ballot.PrevPollID != "" {
+		polls = append(polls, ballot.PrevPollID)
+	}
+
+	rewarded := false
+	for _, pollID := range polls {
+		voter := types.Voter{
+			Owner: owner,
+			Identifier: ballot.Identifier,
+			PollID: pollID,
+		}
+		if _, ok := keeper.GetClaim(ctx, voter); ok {
+			tally.claimed = true
+			continue
+		}
+		vote, ok := keeper.GetReveal(ctx, voter)
+		if !ok {
+			continue
+		}
+		tally.revealed = true
+
+		amount := vote.Power
+		if pollID == ballot.PollID && vote.Choice == ballot.Accepted() {
+			var total int64
+			if ballot.Accepted() {
+				total = ballot.Approve
+			} else {
+				total = ballot.Deny
+			}
+			share := types.MulDivFloor(ballot.Pool, vote.Power, total)
+			ballot.PowerClaimed += vote.Power
+			if ballot.PowerClaimed == total {
+				share = ballot.Pool - ballot.PoolPaid
+			}
+			ballot.PoolPaid += share
+			amount += share
+			rewarded = true
+			tally.result = types.OutcomeRewarded
+		}
+
+		keeper.SetClaim(ctx, voter, types.Claim{
+			Vote: vote,
+			Paid: amount,
+			Height: ctx.BlockHeight(),
+		})
+		tally.paid += amount
+	}
+	return ballot, rewarded
+}
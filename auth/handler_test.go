@@ -11,28 +11,28 @@ import (
 	"github.com/cosmos/cosmos-sdk/x/auth"
 	"github.com/cosmos/cosmos-sdk/x/bank"
 	"github.com/AdityaSripal/token_curated_registry/utils"
-	"crypto/sha256"
 )
 
 func TestCandidacyHandler(t *testing.T) {
 	// setup
 	addr := utils.GenerateAddress()
-	msg := types.NewDeclareCandidacyMsg(addr, "Unique registry listing", sdk.Coin{
+	msg := types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := db.SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
 
-	mapper := db.NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
 	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
 
 	// set handler
-	handler := NewCandidacyHandler(accountKeeper, mapper, 100, 10)
+	handler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
 
 	res := handler(ctx, msg)
 
@@ -57,7 +57,10 @@ func TestCandidacyHandler(t *testing.T) {
 
 	assert.Equal(t, expected, true, "Account balances not deducted correctly")
 	
-	assert.Equal(t, sdk.Result{}, res, "Handler did not pass")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Handler did not pass")
+	assert.Equal(t, []byte("declare_candidacy"), getTag(res.Tags, types.TagAction), "Action not tagged")
+	assert.Equal(t, []byte("Unique registry listing"), getTag(res.Tags, types.TagListing), "Listing not tagged")
+	assert.Equal(t, []byte("100"), getTag(res.Tags, types.TagAmount), "Bond not tagged")
 
 	// Check that adding candidate twice fails
 	res = handler(ctx, msg)
@@ -70,27 +73,28 @@ func TestChallengeHandler(t *testing.T) {
 	addr := utils.GenerateAddress()
 	challenger := utils.GenerateAddress()
 
-	challengeMsg := types.NewChallengeMsg(challenger, "Unique registry listing", sdk.Coin{
+	challengeMsg := types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
-	})
+	}, "", "")
 
-	msg := types.NewDeclareCandidacyMsg(addr, "Unique registry listing", sdk.Coin{
+	msg := types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := db.SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
 
-	mapper := db.NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
 	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
 
 	// set handlers
-	declareHandler := NewCandidacyHandler(accountKeeper, mapper, 100, 10)
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
 
 	// fund account
 	account := auth.NewBaseAccountWithAddress(addr)
@@ -102,7 +106,7 @@ func TestChallengeHandler(t *testing.T) {
 
 	declareHandler(ctx, msg)
 
-	handler := NewChallengeHandler(accountKeeper, mapper, 10, 10, 100)
+	handler := NewChallengeHandler(mapper, 10, 10, 100)
 
 	res := handler(ctx, challengeMsg)
 
@@ -120,46 +124,166 @@ func TestChallengeHandler(t *testing.T) {
 
 	// Valid challengeMsg changes state correcty
 	ballot := mapper.GetBallot(ctx, "Unique registry listing")
-	assert.Equal(t, true, ballot.Active, "Ballot correctly Activated")
+	assert.Equal(t, types.StatusCommit, ballot.Status, "Ballot correctly Activated")
 	assert.Equal(t, int64(10), ballot.EndCommitBlockStamp, "Ballot commitstamp wrong")
 	assert.Equal(t, int64(20), ballot.EndRevealBlockStamp, "Ballot revealstamp wrong")
 
-	assert.Equal(t, sdk.Result{}, res, "Handler did not pass")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Handler did not pass")
+	assert.Equal(t, []byte(challenger.String()), getTag(res.Tags, types.TagChallenger), "Challenger not tagged")
+	assert.Equal(t, []byte(ballot.PollID), getTag(res.Tags, types.TagPoll), "Poll not tagged")
 
 	// Cannot challenge same candidate twice
 	res = handler(ctx, challengeMsg)
 	assert.Equal(t, sdk.ABCICodeType(0x1000a), res.Code, "Allowed ballot to be challenged twice")
 }
 
+func TestChallengeUnderBonded(t *testing.T) {
+	addr := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+	accountKeeper.AddCoins(ctx, challenger, mapper.Coins(300))
+
+	handler := NewChallengeHandler(mapper, 10, 10, 100)
+	challenge := func(amount int64) sdk.Result {
+		return handler(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+			Denom: "RegistryCoin",
+			Amount: amount,
+		}, "Listing is spam", "ipfs://QmEvidence"))
+	}
+
+	// Listing bonded below the current minimum is removed and both bonds refunded
+	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 50)
+	mapper.AddListing(ctx, "Unique registry listing", 0, 50)
+	res := challenge(100)
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte(types.OutcomeUnderBonded), getTag(res.Tags, types.TagOutcome), "Removal not tagged")
+	assert.Equal(t, []byte("50"), getTag(res.Tags, types.TagRefund), "Owner refund not tagged")
+	assert.Equal(t, int64(50), accountKeeper.GetCoins(ctx, addr).AmountOf("RegistryCoin"), "Owner bond not refunded")
+	removed := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, types.StatusRemoved, removed.Status, "Outdated ballot was not removed")
+	assert.Equal(t, int64(0), removed.Bond, "Refunded bond still held")
+	assert.Equal(t, types.Listing{}, mapper.GetListing(ctx, "Unique registry listing"), "Outdated listing was not removed")
+
+	archived, ok := mapper.GetArchivedListing(ctx, "Unique registry listing")
+	assert.True(t, ok, "Outdated listing was not archived")
+	assert.Equal(t, types.RemovedUnderBonded, archived.Reason, "Removal reason wrong")
+	assert.Equal(t, addr, archived.Owner, "Owner not archived")
+	assert.Equal(t, int64(300), accountKeeper.GetCoins(ctx, challenger).AmountOf("RegistryCoin"), "Challenger not refunded")
+
+	// Challenge bond has to match the listing's bond
+	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 150)
+	res = challenge(200)
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeChallengeBondMismatch), res.Code, "Challenged with mismatched bond")
+
+	res = challenge(150)
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, types.StatusCommit, ballot.Status, "Ballot not activated")
+	assert.Equal(t, challenger, ballot.Challenger, "Challenger not stored")
+	assert.Equal(t, "Listing is spam", ballot.Reason, "Challenge reason not stored")
+	assert.Equal(t, "ipfs://QmEvidence", ballot.Evidence, "Challenge evidence not stored")
+}
+
+func TestRechallenge(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{Height: 20}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	// Listing that survived a challenge, with its winning voter yet to claim
+	firstPoll := types.NewPollID("Unique registry listing", 5)
+	mapper.SetBallot(ctx, types.Ballot{
+		Identifier: "Unique registry listing",
+		Owner: utils.GenerateAddress(),
+		Challenger: utils.GenerateAddress(),
+		PollID: firstPoll,
+		Approve: 2,
+		Bond: 100,
+		Status: types.StatusAccepted,
+		Settled: true,
+		Pool: 100,
+	})
+	mapper.AddListing(ctx, "Unique registry listing", 2, 100)
+	voter := utils.GenerateAddress()
+	mapper.SetReveal(ctx, types.Voter{Owner: voter, Identifier: "Unique registry listing", PollID: firstPoll}, types.Vote{Choice: true, Power: 2})
+
+	challenger := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, challenger, mapper.Coins(100))
+	res := NewChallengeHandler(mapper, 10, 10, 100)(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}, "", ""))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	// The new poll starts afresh
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, types.StatusCommit, ballot.Status, "Listing not challenged again")
+	assert.Equal(t, types.NewPollID("Unique registry listing", 20), ballot.PollID, "New poll not opened")
+	assert.Equal(t, challenger, ballot.Challenger)
+	assert.Equal(t, int64(0), ballot.Approve, "Tally of the first poll carried over")
+	assert.Equal(t, int64(0), ballot.Pool, "Pool of the first poll carried over")
+	history := mapper.History(ctx, "Unique registry listing", 0, 10)
+	assert.Equal(t, "rechallenge", history[len(history) - 1].Action, "Re-challenge not recorded")
+
+	// Voters on the first poll claim from its archived copy once the new poll is applied
+	ballot.Status = types.StatusAccepted
+	ballot.Settled = true
+	mapper.SetBallot(ctx, ballot)
+	res = NewClaimRewardHandler(mapper)(ctx, types.NewClaimRewardMsg(voter, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte(types.OutcomeRewarded), getTag(res.Tags, types.TagOutcome), "Archived poll not rewarded")
+	assert.Equal(t, int64(102), accountKeeper.GetCoins(ctx, voter).AmountOf("RegistryCoin"), "Archived poll not paid")
+	polls := mapper.ArchivedPolls(ctx, "Unique registry listing")
+	assert.Equal(t, 1, len(polls), "First poll not archived")
+	assert.Equal(t, int64(100), polls[0].PoolPaid, "Archived pool not paid out")
+}
+
 func TestCommitHandler(t *testing.T) {
 	// setup
 	addr := utils.GenerateAddress()
 	challenger := utils.GenerateAddress()
 	committer := utils.GenerateAddress()
 
-	challengeMsg := types.NewChallengeMsg(challenger, "Unique registry listing", sdk.Coin{
+	challengeMsg := types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
-	})
+	}, "", "")
 
-	msg := types.NewDeclareCandidacyMsg(addr, "Unique registry listing", sdk.Coin{
+	msg := types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := db.SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
 
-	mapper := db.NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
 	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
 
 	// set handlers
-	declareHandler := NewCandidacyHandler(accountKeeper, mapper, 100, 10)
-	challengeHandler := NewChallengeHandler(accountKeeper, mapper, 10, 10, 100)
-	commitHandler := NewCommitHandler(cdc, ballotKey, commitKey)
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 10, 10, 100)
+	commitHandler := NewCommitHandler(mapper)
 
 	// fund account
 	account := auth.NewBaseAccountWithAddress(addr)
@@ -179,11 +303,11 @@ func TestCommitHandler(t *testing.T) {
 	}})
 	accountMapper.SetAccount(ctx, &challengerAcc)
 
-	commitMsg := types.NewCommitMsg(committer, "Unique registry listing", []byte("My commitment"))
+	commitMsg := types.NewCommitMsg(committer, types.DefaultRegistryID, "Unique registry listing", []byte("My commitment"))
 	
 	// Check that you cannot commit before challenge
 	res := commitHandler(ctx, commitMsg)
-	assert.Equal(t, sdk.ABCICodeType(0x20070), res.Code, "Allowed commitment before commit phase")
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotCommitPhase), res.Code, "Allowed commitment before commit phase")
 
 	challengeHandler(ctx, challengeMsg)
 
@@ -194,12 +318,12 @@ func TestCommitHandler(t *testing.T) {
 	voter := types.Voter{
 		Owner: committer,
 		Identifier: "Unique registry listing",
+		PollID: mapper.GetBallot(ctx, "Unique registry listing").PollID,
 	}
-	key, _ := cdc.MarshalBinary(voter)
-	commitment := store.Get(key)
+	commitment := store.Get(mapper.CommitmentKey(voter))
 	assert.Equal(t, commitMsg.Commitment, commitment, "Commitment not set correctly")
 
-	assert.Equal(t, sdk.Result{}, res, "Valid commitment msg did not pass")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Valid commitment msg did not pass")
 
 }
 
@@ -210,30 +334,31 @@ func TestRevealHandler(t *testing.T) {
 	challenger := utils.GenerateAddress()
 	voter := utils.GenerateAddress()
 
-	challengeMsg := types.NewChallengeMsg(challenger, "Unique registry listing", sdk.Coin{
+	challengeMsg := types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
-	})
+	}, "", "")
 
-	msg := types.NewDeclareCandidacyMsg(addr, "Unique registry listing", sdk.Coin{
+	msg := types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := db.SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
 
-	mapper := db.NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
 	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
 
 	// set handlers
-	declareHandler := NewCandidacyHandler(accountKeeper, mapper, 100, 10)
-	challengeHandler := NewChallengeHandler(accountKeeper, mapper, 10, 10, 100)
-	commitHandler := NewCommitHandler(cdc, ballotKey, commitKey)
-	revealHandler := NewRevealHandler(accountKeeper, mapper)
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 10, 10, 100)
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
 
 	// fund account
 	account := auth.NewBaseAccountWithAddress(addr)
@@ -263,35 +388,32 @@ func TestRevealHandler(t *testing.T) {
 	challengeHandler(ctx, challengeMsg)
 
 	// Create commitment
-	hasher := sha256.New()
-	vote, _ := cdc.MarshalBinary(true)
-	hasher.Sum(vote)
-	commitment := hasher.Sum([]byte("My secret nonce"))
+	commitment := types.VoteCommitment(true, []byte("My secret nonce"))
 
 	// Make commitment
-	commitMsg := types.NewCommitMsg(voter, "Unique registry listing", commitment)
+	commitMsg := types.NewCommitMsg(voter, types.DefaultRegistryID, "Unique registry listing", commitment)
 	commitHandler(ctx, commitMsg)
 
 	// Create reveal msg's
-	revealMsg := types.NewRevealMsg(voter, "Unique registry listing", true, []byte("My secret nonce"), sdk.Coin{
+	revealMsg := types.NewRevealMsg(voter, types.DefaultRegistryID, "Unique registry listing", true, []byte("My secret nonce"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	fakeMsg := types.NewRevealMsg(voter, "Unique registry listing", false, []byte("I want to change my vote"), sdk.Coin{
+	fakeMsg := types.NewRevealMsg(voter, types.DefaultRegistryID, "Unique registry listing", false, []byte("I want to change my vote"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
 
 	// Revealing before reveal phase fails
 	res := revealHandler(ctx, revealMsg)
-	assert.Equal(t, sdk.ABCICodeType(0x20070), res.Code, "Allowed reveal msg to pass before reveal phase")
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotRevealPhase), res.Code, "Allowed reveal msg to pass before reveal phase")
 
 	// Fast forward block height
 	ctx = ctx.WithBlockHeight(11)
 
 	// Revealing incorrect commitment fails
 	res = revealHandler(ctx, fakeMsg)
-	assert.Equal(t, sdk.ABCICodeType(0x2006a), res.Code, "Allowed invalid reveal to pass")
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeVoteMismatch), res.Code, "Allowed invalid reveal to pass")
 
 	// Check ballot votes have not changed after invalid reveals
 	ballot := mapper.GetBallot(ctx, "Unique registry listing")
@@ -304,14 +426,14 @@ func TestRevealHandler(t *testing.T) {
 
 	assert.Equal(t, int64(100), ballot.Approve, "Ballot votes did not increment correctly")
 	assert.Equal(t, int64(0), ballot.Deny, "Deny votes is incorrect")
-	assert.Equal(t, sdk.Result{}, res, "Reveal handling did not pass")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Reveal handling did not pass")
 
 	// Check that revealing (voting) twice fails
 	res = revealHandler(ctx, revealMsg)
 	ballot = mapper.GetBallot(ctx, "Unique registry listing")
 
 	assert.Equal(t, int64(100), ballot.Approve, "Allowed user to vote twice")
-	assert.Equal(t, sdk.ABCICodeType(0x20080), res.Code, "Handler did not fail as expected when voting twice")
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeDuplicateVote), res.Code, "Handler did not fail as expected when voting twice")
 }
 
 func TestApplyHandler(t *testing.T) {
@@ -320,31 +442,32 @@ func TestApplyHandler(t *testing.T) {
 	challenger := utils.GenerateAddress()
 	voter := utils.GenerateAddress()
 
-	challengeMsg := types.NewChallengeMsg(challenger, "Unique registry listing", sdk.Coin{
+	challengeMsg := types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
-	})
+	}, "", "")
 
-	msg := types.NewDeclareCandidacyMsg(addr, "Unique registry listing", sdk.Coin{
+	msg := types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := db.SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
 
-	mapper := db.NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
 	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
 
 	// set handlers
-	declareHandler := NewCandidacyHandler(accountKeeper, mapper, 100, 10)
-	challengeHandler := NewChallengeHandler(accountKeeper, mapper, 10, 10, 100)
-	commitHandler := NewCommitHandler(cdc, ballotKey, commitKey)
-	revealHandler := NewRevealHandler(accountKeeper, mapper)
-	applyHandler := NewApplyHandler(accountKeeper, mapper, listKey, 0.5, 0.5)
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 10, 10, 100)
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), types.AppealParams{})
 
 	// fund account
 	account := auth.NewBaseAccountWithAddress(addr)
@@ -374,17 +497,14 @@ func TestApplyHandler(t *testing.T) {
 	challengeHandler(ctx, challengeMsg)
 
 	// Create commitment
-	hasher := sha256.New()
-	vote, _ := cdc.MarshalBinary(true)
-	hasher.Sum(vote)
-	commitment := hasher.Sum([]byte("My secret nonce"))
+	commitment := types.VoteCommitment(true, []byte("My secret nonce"))
 
 	// Make commitment
-	commitMsg := types.NewCommitMsg(voter, "Unique registry listing", commitment)
+	commitMsg := types.NewCommitMsg(voter, types.DefaultRegistryID, "Unique registry listing", commitment)
 	commitHandler(ctx, commitMsg)
 
 	// Create reveal msg's
-	revealMsg := types.NewRevealMsg(voter, "Unique registry listing", true, []byte("My secret nonce"), sdk.Coin{
+	revealMsg := types.NewRevealMsg(voter, types.DefaultRegistryID, "Unique registry listing", true, []byte("My secret nonce"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
@@ -395,11 +515,11 @@ func TestApplyHandler(t *testing.T) {
 	revealHandler(ctx, revealMsg)
 
 	// Create Apply msg
-	applyMsg := types.NewApplyMsg(addr, "Unique registry listing")
+	applyMsg := types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing")
 	
 	// Apply before end of reveal phase fails
 	res := applyHandler(ctx, applyMsg)
-	assert.Equal(t, sdk.ABCICodeType(0x20078), res.Code, "Allowed ballot to be finalized before end of reveal phase")
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeRevealPhaseNotEnded), res.Code, "Allowed ballot to be finalized before end of reveal phase")
 
 	// Fast forward block height past reveal phase
 	ctx = ctx.WithBlockHeight(21)
@@ -420,11 +540,14 @@ func TestApplyHandler(t *testing.T) {
 	expected := types.Listing{
 		Identifier: "Unique registry listing",
 		Votes: ballot.Approve,
+		Bond: 100,
 	}
 
 	assert.Equal(t, expected, listing, "Listing not added to registry correctly")
 
-	assert.Equal(t, sdk.Result{}, res, "Handler did not pass")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Handler did not pass")
+	assert.Equal(t, []byte(types.OutcomeAccepted), getTag(res.Tags, types.TagOutcome), "Outcome not tagged")
+	assert.Equal(t, []byte("50"), getTag(res.Tags, types.TagAmount), "Reward not tagged")
 
 
 	// Test apply works without challenge
@@ -440,7 +563,7 @@ func TestApplyHandler(t *testing.T) {
 	}})
 	accountMapper.SetAccount(ctx, &account)
 
-	msg = types.NewDeclareCandidacyMsg(addr, "Unique registry listing 2", sdk.Coin{
+	msg = types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing 2", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
@@ -450,20 +573,22 @@ func TestApplyHandler(t *testing.T) {
 	// Fast forward past application stage
 	ctx = ctx.WithBlockHeight(11)
 
-	applyMsg = types.NewApplyMsg(addr, "Unique registry listing 2")
+	applyMsg = types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing 2")
 	res = applyHandler(ctx, applyMsg)
 
 	// Check that listing added to registry
 	expected = types.Listing{
 		Identifier: "Unique registry listing 2",
 		Votes: 0,
+		Bond: 100,
 	}
 	actualList := mapper.GetListing(ctx, "Unique registry listing 2")
 
 	assert.Equal(t, expected, actualList, "Listing not added properly when unchallenged")
 
 	// Check handler passes
-	assert.Equal(t, sdk.Result{}, res, "applyHandler does not pass when unchallenged")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "applyHandler does not pass when unchallenged")
+	assert.Equal(t, []byte(types.OutcomeListed), getTag(res.Tags, types.TagOutcome), "Outcome not tagged when unchallenged")
 
 
 	// Check that challenging and removing an already existing listing works
@@ -479,26 +604,23 @@ func TestApplyHandler(t *testing.T) {
 	}})
 	accountMapper.SetAccount(ctx, &challengerAcc)
 
-	challengeMsg = types.NewChallengeMsg(challenger, "Unique registry listing 2", sdk.Coin{
+	challengeMsg = types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing 2", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
-	})
+	}, "", "")
 
 	challengeHandler(ctx, challengeMsg)
 
 	// Create commitment
-	hasher = sha256.New()
-	vote, _ = cdc.MarshalBinary(false)
-	hasher.Sum(vote)
-	commitment = hasher.Sum([]byte("My secret nonce"))
+	commitment = types.VoteCommitment(false, []byte("My secret nonce"))
 
-	commitMsg = types.NewCommitMsg(challenger, "Unique registry listing 2", commitment)
+	commitMsg = types.NewCommitMsg(challenger, types.DefaultRegistryID, "Unique registry listing 2", commitment)
 	commitHandler(ctx, commitMsg)
 
 	// Fast forward to reveal stage
 	ctx = ctx.WithBlockHeight(11)
 
-	revealMsg = types.NewRevealMsg(challenger, "Unique registry listing 2", false, []byte("My secret nonce"), sdk.Coin{
+	revealMsg = types.NewRevealMsg(challenger, types.DefaultRegistryID, "Unique registry listing 2", false, []byte("My secret nonce"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 50,
 	})
@@ -514,6 +636,24 @@ func TestApplyHandler(t *testing.T) {
 	// Check that listing is deleted
 	assert.Equal(t, expected, actualList, "Listing was not deleted from registry after successful challenge")
 
+	// and archived with the poll that removed it
+	archived, ok := mapper.GetArchivedListing(ctx, "Unique registry listing 2")
+	assert.True(t, ok, "Removed listing not archived")
+	assert.Equal(t, types.RemovedLostChallenge, archived.Reason, "Removal reason wrong")
+	assert.Equal(t, int64(21), archived.Height, "Removal height wrong")
+	assert.Equal(t, types.NewPollID("Unique registry listing 2", 0), archived.PollID, "Removing poll not archived")
+	assert.Equal(t, int64(100), archived.Listing.Bond, "Removed listing not archived")
+
+	// Every step is in the listing's history, oldest first
+	actions := []string{}
+	for _, event := range mapper.History(ctx, "Unique registry listing 2", 0, 10) {
+		actions = append(actions, event.Action)
+	}
+	assert.Equal(t, []string{"declare_candidacy", "apply", "challenge", "commit", "finalize"}, actions, "History incomplete")
+	final := mapper.History(ctx, "Unique registry listing 2", 4, 1)[0]
+	assert.Equal(t, types.OutcomeRejected, final.Outcome, "Decision not in history")
+	assert.Equal(t, int64(21), final.Height, "Decision height wrong")
+
 	// challenger should receive his original bond(100) as well as dispPct(0.5) of applier bond(100). Total 150. Note current balance of challenger is 0.
 	actualBalance := accountKeeper.HasCoins(ctx, challenger, []sdk.Coin{sdk.Coin{
 		Denom: "RegistryCoin",
@@ -522,7 +662,7 @@ func TestApplyHandler(t *testing.T) {
 	assert.Equal(t, true, actualBalance, "Challenger balance did not update correctly")
 
 	// Check handler passes
-	assert.Equal(t, sdk.Result{}, res, "Handler did not pass")
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Handler did not pass")
 }
 
 func TestClaimRewardHandler(t *testing.T) {
@@ -533,32 +673,33 @@ func TestClaimRewardHandler(t *testing.T) {
 	victor2 := utils.GenerateAddress()
 	loser := utils.GenerateAddress()
 
-	challengeMsg := types.NewChallengeMsg(challenger, "Unique registry listing", sdk.Coin{
+	challengeMsg := types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 200,
-	})
+	}, "", "")
 
-	msg := types.NewDeclareCandidacyMsg(addr, "Unique registry listing", sdk.Coin{
+	msg := types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 200,
 	})
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := db.SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
 	cdc := db.MakeCodec()
 
-	mapper := db.NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
 	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
 
 	// set handlers
-	declareHandler := NewCandidacyHandler(accountKeeper, mapper, 100, 10)
-	challengeHandler := NewChallengeHandler(accountKeeper, mapper, 10, 10, 100)
-	commitHandler := NewCommitHandler(cdc, ballotKey, commitKey)
-	revealHandler := NewRevealHandler(accountKeeper, mapper)
-	applyHandler := NewApplyHandler(accountKeeper, mapper, listKey, 0.5, 0.5)
-	claimRewardHandler := NewClaimRewardHandler(cdc, accountKeeper, ballotKey, revealKey, listKey, 0.5)
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 10, 10, 100)
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), types.AppealParams{})
+	claimRewardHandler := NewClaimRewardHandler(mapper)
 
 	// fund account
 	account := auth.NewBaseAccountWithAddress(addr)
@@ -605,41 +746,33 @@ func TestClaimRewardHandler(t *testing.T) {
 	challengeHandler(ctx, challengeMsg)
 
 	// Create victor commitment
-	hasher := sha256.New()
-	victorVote, _ := cdc.MarshalBinary(true)
-	hasher.Sum(victorVote)
-	victorCommitment1 := hasher.Sum([]byte("Victor1 secret nonce"))
+	victorCommitment1 := types.VoteCommitment(true, []byte("Victor1 secret nonce"))
 
-	hasher = sha256.New()
-	hasher.Sum(victorVote)
-	victorCommitment2 := hasher.Sum([]byte("Victor2 secret nonce"))
+	victorCommitment2 := types.VoteCommitment(true, []byte("Victor2 secret nonce"))
 
 	// Create loser commitment
-	hasher = sha256.New()
-	loserVote, _ := cdc.MarshalBinary(false)
-	hasher.Sum(loserVote)
-	loserCommitment := hasher.Sum([]byte("Loser secret nonce"))
+	loserCommitment := types.VoteCommitment(false, []byte("Loser secret nonce"))
 
 	// Make commitments
-	victorCommitMsg1 := types.NewCommitMsg(victor1, "Unique registry listing", victorCommitment1)
+	victorCommitMsg1 := types.NewCommitMsg(victor1, types.DefaultRegistryID, "Unique registry listing", victorCommitment1)
 	commitHandler(ctx, victorCommitMsg1)
 
-	victorCommitMsg2 := types.NewCommitMsg(victor2, "Unique registry listing", victorCommitment2)
+	victorCommitMsg2 := types.NewCommitMsg(victor2, types.DefaultRegistryID, "Unique registry listing", victorCommitment2)
 	commitHandler(ctx, victorCommitMsg2)
 
-	loserCommitMsg := types.NewCommitMsg(loser, "Unique registry listing", loserCommitment)
+	loserCommitMsg := types.NewCommitMsg(loser, types.DefaultRegistryID, "Unique registry listing", loserCommitment)
 	commitHandler(ctx, loserCommitMsg)
 
 	// Create reveal msg's
-	victorRevealMsg1 := types.NewRevealMsg(victor1, "Unique registry listing", true, []byte("Victor1 secret nonce"), sdk.Coin{
+	victorRevealMsg1 := types.NewRevealMsg(victor1, types.DefaultRegistryID, "Unique registry listing", true, []byte("Victor1 secret nonce"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
-	victorRevealMsg2 := types.NewRevealMsg(victor2, "Unique registry listing", true, []byte("Victor2 secret nonce"), sdk.Coin{
+	victorRevealMsg2 := types.NewRevealMsg(victor2, types.DefaultRegistryID, "Unique registry listing", true, []byte("Victor2 secret nonce"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 300,
 	})
-	loserRevealMsg := types.NewRevealMsg(loser, "Unique registry listing", false, []byte("Loser secret nonce"), sdk.Coin{
+	loserRevealMsg := types.NewRevealMsg(loser, types.DefaultRegistryID, "Unique registry listing", false, []byte("Loser secret nonce"), sdk.Coin{
 		Denom: "RegistryCoin",
 		Amount: 100,
 	})
@@ -656,16 +789,16 @@ func TestClaimRewardHandler(t *testing.T) {
 	ctx = ctx.WithBlockHeight(21)
 
 	// Create Claim reward Msg
-	claimVictorMsg1 := types.NewClaimRewardMsg(victor1, "Unique registry listing")
-	claimVictorMsg2 := types.NewClaimRewardMsg(victor2, "Unique registry listing")
-	claimLoserMsg := types.NewClaimRewardMsg(loser, "Unique registry listing")
+	claimVictorMsg1 := types.NewClaimRewardMsg(victor1, types.DefaultRegistryID, "Unique registry listing")
+	claimVictorMsg2 := types.NewClaimRewardMsg(victor2, types.DefaultRegistryID, "Unique registry listing")
+	claimLoserMsg := types.NewClaimRewardMsg(loser, types.DefaultRegistryID, "Unique registry listing")
 
 	// Make sure claimReward fails before being applied
 	res := claimRewardHandler(ctx, claimVictorMsg1)
-	assert.Equal(t, sdk.ABCICodeType(0x20082), res.Code, "Allowed claim reward to pass before apply")
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeBallotNotApplied), res.Code, "Allowed claim reward to pass before apply")
 
 	// Create Apply msg and handle
-	applyMsg := types.NewApplyMsg(addr, "Unique registry listing")
+	applyMsg := types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing")
 	applyHandler(ctx, applyMsg)
 
 	res1 := claimRewardHandler(ctx, claimVictorMsg1)
@@ -695,7 +828,600 @@ func TestClaimRewardHandler(t *testing.T) {
 	assert.Equal(t, true, actual, "Loser not refunded properly")
 
 	// Check handler passes
-	assert.Equal(t, sdk.Result{}, res1, "Handler did not pass for victor1")
-	assert.Equal(t, sdk.Result{}, res2, "Handler did not pass for victor2")
-	assert.Equal(t, sdk.Result{}, res3, "Handler did not pass for loser")
-}
\ No newline at end of file
+	assert.Equal(t, sdk.ABCICodeType(0), res1.Code, "Handler did not pass for victor1")
+	assert.Equal(t, sdk.ABCICodeType(0), res2.Code, "Handler did not pass for victor2")
+	assert.Equal(t, sdk.ABCICodeType(0), res3.Code, "Handler did not pass for loser")
+
+	assert.Equal(t, []byte(types.OutcomeRewarded), getTag(res1.Tags, types.TagOutcome), "Reward not tagged")
+	assert.Equal(t, []byte("125"), getTag(res1.Tags, types.TagAmount), "Reward amount not tagged")
+	assert.Equal(t, []byte(types.OutcomeRefunded), getTag(res3.Tags, types.TagOutcome), "Refund not tagged")
+
+	// Claim is recorded in the ledger and the reveal pruned
+	voter := types.Voter{
+		Owner: victor1,
+		Identifier: "Unique registry listing",
+		PollID: mapper.GetBallot(ctx, "Unique registry listing").PollID,
+	}
+	claim, claimed := mapper.GetClaim(ctx, voter)
+	assert.Equal(t, true, claimed, "Claim not recorded")
+	assert.Equal(t, int64(125), claim.Paid, "Claim amount not recorded")
+	assert.Equal(t, types.Vote{Choice: true, Power: 100}, claim.Vote, "Claimed vote not recorded")
+
+	_, revealed := mapper.GetReveal(ctx, voter)
+	assert.Equal(t, false, revealed, "Reveal not pruned after claim")
+
+	// Claims are single use
+	res = claimRewardHandler(ctx, claimVictorMsg1)
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAlreadyClaimed), res.Code, "Allowed claim twice")
+	assert.Equal(t, int64(125), accountKeeper.GetCoins(ctx, victor1).AmountOf("RegistryCoin"), "Paid out second claim")
+
+	res = claimRewardHandler(ctx, types.NewClaimRewardMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNoVote), res.Code, "Paid claim without vote")
+}
+
+// Value of the first tag with the given key, or nil
+func getTag(tags sdk.Tags, key string) []byte {
+	for _, tag := range tags {
+		if string(tag.Key) == key {
+			return tag.Value
+		}
+	}
+	return nil
+}
+func TestTimeDeadlines(t *testing.T) {
+	// setup
+	addr := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{Time: 1000}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc).WithDeadlines(types.TimeDeadlines)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	// Phases last an hour each
+	declareHandler := NewCandidacyHandler(mapper, 100, 3600, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 3600, 3600, 100)
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), types.AppealParams{})
+
+	for _, a := range []sdk.Address{addr, challenger, voter} {
+		acc := auth.NewBaseAccountWithAddress(a)
+		acc.SetCoins([]sdk.Coin{sdk.Coin{
+			Denom: "RegistryCoin",
+			Amount: 150,
+		}})
+		accountMapper.SetAccount(ctx, &acc)
+	}
+
+	declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}))
+
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, types.TimeDeadlines, ballot.Deadlines, "Ballot deadline mode not recorded")
+	assert.Equal(t, int64(4600), ballot.EndApplyBlockStamp, "Apply deadline not measured in time")
+
+	// Many blocks pass but the application phase has not ended
+	ctx = ctx.WithBlockHeight(100).WithBlockHeader(abci.Header{Height: 100, Time: 2000})
+
+	challengeHandler(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}, "", ""))
+
+	ballot = mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, int64(5600), ballot.EndCommitBlockStamp, "Commit deadline not measured in time")
+	assert.Equal(t, int64(9200), ballot.EndRevealBlockStamp, "Reveal deadline not measured in time")
+
+	commitment := types.VoteCommitment(true, []byte("My secret nonce"))
+
+	res := commitHandler(ctx, types.NewCommitMsg(voter, types.DefaultRegistryID, "Unique registry listing", commitment))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Commit within commit time rejected")
+
+	revealMsg := types.NewRevealMsg(voter, types.DefaultRegistryID, "Unique registry listing", true, []byte("My secret nonce"), sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	})
+
+	// Height far past the deadlines in blocks has no effect
+	ctx = ctx.WithBlockHeight(10000).WithBlockHeader(abci.Header{Height: 10000, Time: 5000})
+	res = revealHandler(ctx, revealMsg)
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotRevealPhase), res.Code, "Reveal allowed before commit time ended")
+
+	ctx = ctx.WithBlockHeight(10001).WithBlockHeader(abci.Header{Height: 10001, Time: 6000})
+	res = revealHandler(ctx, revealMsg)
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Reveal within reveal time rejected")
+
+	res = applyHandler(ctx, types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeRevealPhaseNotEnded), res.Code, "Apply allowed before reveal time ended")
+
+	ctx = ctx.WithBlockHeight(10002).WithBlockHeader(abci.Header{Height: 10002, Time: 9201})
+	res = applyHandler(ctx, types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, "Apply after reveal time rejected")
+}
+
+// Declare and challenge a listing with funded owner and challenger
+func setupAppeal(t *testing.T, appeal types.AppealParams) (sdk.Context, db.BallotMapper, bank.Keeper, sdk.Address, sdk.Address) {
+	addr := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	for _, a := range []sdk.Address{addr, challenger} {
+		acc := auth.NewBaseAccountWithAddress(a)
+		acc.SetCoins([]sdk.Coin{sdk.Coin{
+			Denom: "RegistryCoin",
+			Amount: 300,
+		}})
+		accountMapper.SetAccount(ctx, &acc)
+	}
+
+	NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})(ctx, types.NewDeclareCandidacyMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}))
+	res := NewChallengeHandler(mapper, 10, 10, 100)(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}, "", ""))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	return ctx, mapper, accountKeeper, addr, challenger
+}
+
+func TestAppealVote(t *testing.T) {
+	appeal := types.AppealParams{
+		AppealLen: 10,
+		BondFactor: 2,
+		CommitLen: 20,
+		RevealLen: 20,
+		Quorum: types.NewRatio(66, 100),
+	}
+	ctx, mapper, accountKeeper, addr, challenger := setupAppeal(t, appeal)
+
+	voter := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, voter, []sdk.Coin{sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 400,
+	}})
+
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), appeal)
+	appealHandler := NewAppealHandler(mapper, appeal)
+	claimRewardHandler := NewClaimRewardHandler(mapper)
+
+	// First poll denies the listing
+	commitHandler(ctx, types.NewCommitMsg(voter, types.DefaultRegistryID, "Unique registry listing", types.VoteCommitment(false, []byte("First nonce"))))
+
+	ctx = ctx.WithBlockHeight(11)
+	res := revealHandler(ctx, types.NewRevealMsg(voter, types.DefaultRegistryID, "Unique registry listing", false, []byte("First nonce"), sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	ctx = ctx.WithBlockHeight(21)
+	res = applyHandler(ctx, types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	// Decision is visible but bonds stay locked during the appeal window
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, types.StatusRejected, ballot.Status, "Ballot not resolved")
+	assert.Equal(t, false, ballot.Settled, "Ballot settled during appeal window")
+	assert.Equal(t, int64(31), ballot.EndAppealBlockStamp, "Appeal deadline wrong")
+	assert.Equal(t, types.Listing{}, mapper.GetListing(ctx, "Unique registry listing"), "Rejected listing still in registry")
+	assert.Equal(t, int64(200), accountKeeper.GetCoins(ctx, challenger).AmountOf("RegistryCoin"), "Challenger paid before appeal window closed")
+
+	ctx = ctx.WithBlockHeight(25)
+	res = applyHandler(ctx, types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAppealPhaseNotEnded), res.Code, "Finalized during appeal window")
+
+	// Only the parties can appeal, with at least twice the bond
+	res = appealHandler(ctx, types.NewAppealMsg(voter, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 200,
+	}))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotParty), res.Code, "Voter allowed to appeal")
+
+	res = appealHandler(ctx, types.NewAppealMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 150,
+	}))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAppealBondTooLow), res.Code, "Appeal allowed with low bond")
+
+	res = appealHandler(ctx, types.NewAppealMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 200,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	ballot = mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, int64(45), ballot.EndCommitBlockStamp, "Appeal commit deadline wrong")
+	assert.Equal(t, int64(65), ballot.EndRevealBlockStamp, "Appeal reveal deadline wrong")
+	assert.Equal(t, int64(0), ballot.Deny, "Appeal poll did not start from zero")
+	assert.NotEqual(t, ballot.PrevPollID, ballot.PollID, "Appeal did not open a new poll")
+
+	// Cannot appeal twice
+	res = appealHandler(ctx, types.NewAppealMsg(challenger, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 200,
+	}))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotAppealable), res.Code, "Allowed second appeal")
+
+	// Same voter approves in the appeal poll
+	res = commitHandler(ctx, types.NewCommitMsg(voter, types.DefaultRegistryID, "Unique registry listing", types.VoteCommitment(true, []byte("Second nonce"))))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	ctx = ctx.WithBlockHeight(46)
+	res = revealHandler(ctx, types.NewRevealMsg(voter, types.DefaultRegistryID, "Unique registry listing", true, []byte("Second nonce"), sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	// Appeal overturns the first decision and is final
+	ctx = ctx.WithBlockHeight(66)
+	res = applyHandler(ctx, types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte(types.OutcomeAccepted), getTag(res.Tags, types.TagOutcome), "Appeal outcome not tagged")
+
+	assert.Equal(t, "Unique registry listing", mapper.GetListing(ctx, "Unique registry listing").Identifier, "Listing not restored after appeal")
+
+	// Owner gets dispPct of challenger bond and the appeal bond back: 0 + 50 + 200
+	assert.Equal(t, int64(250), accountKeeper.GetCoins(ctx, addr).AmountOf("RegistryCoin"), "Owner not paid after appeal")
+	assert.Equal(t, int64(200), accountKeeper.GetCoins(ctx, challenger).AmountOf("RegistryCoin"), "Challenger paid after losing appeal")
+
+	// Voter gets first poll stake back plus stake and whole reward pool of appeal poll: 200 + 100 + 100 + 50
+	res = claimRewardHandler(ctx, types.NewClaimRewardMsg(voter, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(450), accountKeeper.GetCoins(ctx, voter).AmountOf("RegistryCoin"), "Voter not paid for both polls")
+}
+
+func TestAppealCouncil(t *testing.T) {
+	council := []sdk.Address{utils.GenerateAddress(), utils.GenerateAddress(), utils.GenerateAddress()}
+	appeal := types.AppealParams{
+		AppealLen: 10,
+		BondFactor: 2,
+		Council: council,
+	}
+	ctx, mapper, accountKeeper, addr, challenger := setupAppeal(t, appeal)
+
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), appeal)
+	appealHandler := NewAppealHandler(mapper, appeal)
+	decisionHandler := NewAppealDecisionHandler(mapper, types.NewRatio(1, 2), appeal)
+
+	// Nobody votes so the listing is rejected
+	ctx = ctx.WithBlockHeight(21)
+	applyHandler(ctx, types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+
+	res := decisionHandler(ctx, types.NewAppealDecisionMsg(council[:2], types.DefaultRegistryID, "Unique registry listing", true))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNoCouncilReview), res.Code, "Council decided without appeal")
+
+	res = appealHandler(ctx, types.NewAppealMsg(addr, types.DefaultRegistryID, "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 200,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	res = applyHandler(ctx.WithBlockHeight(40), types.NewApplyMsg(addr, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAwaitingCouncil), res.Code, "Applied before council decided")
+
+	// Minority of council and outsiders cannot decide
+	res = decisionHandler(ctx, types.NewAppealDecisionMsg(council[:1], types.DefaultRegistryID, "Unique registry listing", true))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotCouncil), res.Code, "Council minority decided appeal")
+
+	res = decisionHandler(ctx, types.NewAppealDecisionMsg([]sdk.Address{council[0], challenger}, types.DefaultRegistryID, "Unique registry listing", false))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNotCouncil), res.Code, "Outsider counted toward council majority")
+
+	res = decisionHandler(ctx, types.NewAppealDecisionMsg(council[:2], types.DefaultRegistryID, "Unique registry listing", true))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, true, ballot.Settled, "Ballot not finalized by council")
+	assert.Equal(t, "Unique registry listing", mapper.GetListing(ctx, "Unique registry listing").Identifier, "Council decision not applied to registry")
+
+	// Owner: 300 - 100 - 200 + 50 + 200
+	assert.Equal(t, int64(250), accountKeeper.GetCoins(ctx, addr).AmountOf("RegistryCoin"), "Owner not paid after council decision")
+}
+
+func TestClaimAllRewards(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 10, 10, 100)
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), types.AppealParams{})
+	claimAllHandler := NewClaimAllRewardsHandler(mapper)
+
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+	for _, addr := range []sdk.Address{owner, challenger, voter} {
+		accountKeeper.AddCoins(ctx, addr, []sdk.Coin{sdk.Coin{
+			Denom: "RegistryCoin",
+			Amount: 1000,
+		}})
+	}
+
+	commitment := types.VoteCommitment(true, []byte("nonce"))
+
+	bond := sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}
+
+	// Voter approves on three challenged listings, the last one challenged later
+	listings := []string{"First listing", "Second listing", "Third listing"}
+	for i, identifier := range listings {
+		ctx = ctx.WithBlockHeight(int64(i * 20))
+		declareHandler(ctx, types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, identifier, bond))
+		challengeHandler(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, identifier, bond, "", ""))
+		commitHandler(ctx, types.NewCommitMsg(voter, types.DefaultRegistryID, identifier, commitment))
+
+		ctx = ctx.WithBlockHeight(int64(i * 20 + 11))
+		res := revealHandler(ctx, types.NewRevealMsg(voter, types.DefaultRegistryID, identifier, true, []byte("nonce"), bond))
+		assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	}
+	assert.Equal(t, []string{"First listing", "Second listing", "Third listing"}, mapper.PendingClaims(ctx, voter), "Unclaimed reveals not indexed")
+
+	// Only the first two are applied, the third is still in its reveal phase
+	applyHandler(ctx, types.NewApplyMsg(owner, types.DefaultRegistryID, "First listing"))
+	applyHandler(ctx, types.NewApplyMsg(owner, types.DefaultRegistryID, "Second listing"))
+
+	// Voter has 1000 - 3 * 100 staked. Each claim pays 100 + 0.5 * 100
+	res := claimAllHandler(ctx, types.NewClaimAllRewardsMsg(voter, types.DefaultRegistryID))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(1000), accountKeeper.GetCoins(ctx, voter).AmountOf("RegistryCoin"), "Applied ballots not all claimed")
+	assert.Equal(t, []byte("300"), getTag(res.Tags, types.TagAmount), "Total claim not tagged")
+
+	claimed := [][]byte{}
+	for _, tag := range res.Tags {
+		if string(tag.Key) == types.TagListing {
+			claimed = append(claimed, tag.Value)
+		}
+	}
+	assert.Equal(t, [][]byte{[]byte("First listing"), []byte("Second listing")}, claimed, "Claimed listings not tagged")
+	assert.Equal(t, []string{"Third listing"}, mapper.PendingClaims(ctx, voter), "Claimed reveals still indexed")
+
+	// Nothing left until the third ballot is applied
+	res = claimAllHandler(ctx, types.NewClaimAllRewardsMsg(voter, types.DefaultRegistryID))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNoVote), res.Code, "Claimed with nothing pending")
+
+	res = NewClaimRewardHandler(mapper)(ctx, types.NewClaimRewardMsg(voter, types.DefaultRegistryID, "First listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAlreadyClaimed), res.Code, "Single claim paid after claiming all")
+
+	ctx = ctx.WithBlockHeight(60)
+	applyHandler(ctx, types.NewApplyMsg(owner, types.DefaultRegistryID, "Third listing"))
+	res = claimAllHandler(ctx, types.NewClaimAllRewardsMsg(voter, types.DefaultRegistryID))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(1150), accountKeeper.GetCoins(ctx, voter).AmountOf("RegistryCoin"), "Third ballot not claimed")
+	assert.Equal(t, []string{}, mapper.PendingClaims(ctx, voter), "Claimed reveals still indexed")
+}
+
+func TestClaimRewardRemainder(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	claimRewardHandler := NewClaimRewardHandler(mapper)
+
+	// Applied ballot whose pool of 100 is split between three equal winning votes
+	mapper.SetBallot(ctx, types.Ballot{
+		Identifier: "Unique registry listing",
+		Owner: utils.GenerateAddress(),
+		PollID: "poll",
+		Approve: 3,
+		Status: types.StatusAccepted,
+		Settled: true,
+		Pool: 100,
+	})
+
+	voters := []sdk.Address{utils.GenerateAddress(), utils.GenerateAddress(), utils.GenerateAddress()}
+	store := ctx.KVStore(revealKey)
+	for _, voter := range voters {
+		bz, _ := cdc.MarshalBinary(types.Vote{Choice: true, Power: 1})
+		store.Set(mapper.VoterKey(types.Voter{
+			Owner: voter,
+			Identifier: "Unique registry listing",
+			PollID: "poll",
+		}), bz)
+	}
+
+	// Shares are rounded down, the last claimant gets the remainder: 1 + 33, 1 + 33, 1 + 34
+	expected := []int64{34, 34, 35}
+	for i, voter := range voters {
+		res := claimRewardHandler(ctx, types.NewClaimRewardMsg(voter, types.DefaultRegistryID, "Unique registry listing"))
+		assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+		assert.Equal(t, expected[i], accountKeeper.GetCoins(ctx, voter).AmountOf("RegistryCoin"), "Voter paid wrong share")
+	}
+
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, ballot.Pool, ballot.PoolPaid, "Pool not paid out exactly")
+}
+
+func TestDelegateVoting(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	declareHandler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{})
+	challengeHandler := NewChallengeHandler(mapper, 10, 10, 100)
+	commitHandler := NewCommitHandler(mapper)
+	revealHandler := NewRevealHandler(mapper)
+	applyHandler := NewApplyHandler(mapper, types.NewRatio(1, 2), types.NewRatio(1, 2), types.AppealParams{})
+	claimRewardHandler := NewClaimRewardHandler(mapper)
+	delegateHandler := NewDelegateVotingHandler(mapper)
+
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	curator := utils.GenerateAddress()
+	follower := utils.GenerateAddress()
+	dissenter := utils.GenerateAddress()
+	broke := utils.GenerateAddress()
+	for _, addr := range []sdk.Address{owner, challenger, curator, follower, dissenter} {
+		accountKeeper.AddCoins(ctx, addr, []sdk.Coin{sdk.Coin{
+			Denom: "RegistryCoin",
+			Amount: 1000,
+		}})
+	}
+
+	coin := func(amount int64) sdk.Coin {
+		return sdk.Coin{
+			Denom: "RegistryCoin",
+			Amount: amount,
+		}
+	}
+
+	res := delegateHandler(ctx, types.NewDelegateVotingMsg(follower, types.DefaultRegistryID, curator, sdk.Coin{Denom: "OtherCoin", Amount: 200}))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeInvalidBond), res.Code, "Allowed delegation in wrong denom")
+
+	// Broke delegator cannot cover the power once the curator reveals
+	delegations := []types.Delegation{
+		{Delegator: follower, Delegate: curator, Power: 200},
+		{Delegator: dissenter, Delegate: curator, Power: 100},
+		{Delegator: broke, Delegate: curator, Power: 100},
+	}
+	for _, delegation := range delegations {
+		res = delegateHandler(ctx, types.NewDelegateVotingMsg(delegation.Delegator, types.DefaultRegistryID, delegation.Delegate, coin(delegation.Power)))
+		assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	}
+	assert.Equal(t, 3, len(mapper.Delegations(ctx, curator)), "Delegations not indexed by delegate")
+
+	declareHandler(ctx, types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Unique registry listing", coin(100)))
+	challengeHandler(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Unique registry listing", coin(100), "", ""))
+
+	commitHandler(ctx, types.NewCommitMsg(curator, types.DefaultRegistryID, "Unique registry listing", types.VoteCommitment(true, []byte("Curator nonce"))))
+
+	commitHandler(ctx, types.NewCommitMsg(dissenter, types.DefaultRegistryID, "Unique registry listing", types.VoteCommitment(false, []byte("Dissenter nonce"))))
+
+	// Curator's reveal also votes the power of delegators who can cover it
+	ctx = ctx.WithBlockHeight(11)
+	res = revealHandler(ctx, types.NewRevealMsg(curator, types.DefaultRegistryID, "Unique registry listing", true, []byte("Curator nonce"), coin(100)))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte("300"), getTag(res.Tags, types.TagDelegated), "Delegated power not tagged")
+	assert.Equal(t, int64(400), mapper.GetBallot(ctx, "Unique registry listing").Approve, "Delegated power not counted")
+	assert.Equal(t, int64(800), accountKeeper.GetCoins(ctx, follower).AmountOf("RegistryCoin"), "Delegated power not staked")
+
+	// Direct vote replaces the delegated one and refunds its stake
+	res = revealHandler(ctx, types.NewRevealMsg(dissenter, types.DefaultRegistryID, "Unique registry listing", false, []byte("Dissenter nonce"), coin(50)))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, int64(300), ballot.Approve, "Delegated vote not replaced by direct vote")
+	assert.Equal(t, int64(50), ballot.Deny, "Direct vote not counted")
+	assert.Equal(t, int64(950), accountKeeper.GetCoins(ctx, dissenter).AmountOf("RegistryCoin"), "Delegated stake not refunded")
+
+	ctx = ctx.WithBlockHeight(21)
+	res = applyHandler(ctx, types.NewApplyMsg(owner, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, []byte(types.OutcomeAccepted), getTag(res.Tags, types.TagOutcome), res.Log)
+
+	// Pool of 50 split by weight: follower 200 + 33, curator 100 + the remaining 17
+	res = claimRewardHandler(ctx, types.NewClaimRewardMsg(follower, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(1033), accountKeeper.GetCoins(ctx, follower).AmountOf("RegistryCoin"), "Delegator not rewarded")
+
+	res = claimRewardHandler(ctx, types.NewClaimRewardMsg(curator, types.DefaultRegistryID, "Unique registry listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(1017), accountKeeper.GetCoins(ctx, curator).AmountOf("RegistryCoin"), "Delegate not rewarded")
+
+	// Revoke
+	res = delegateHandler(ctx, types.NewDelegateVotingMsg(follower, types.DefaultRegistryID, nil, sdk.Coin{}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	_, found := mapper.GetDelegation(ctx, follower)
+	assert.False(t, found, "Delegation not revoked")
+	assert.Equal(t, 2, len(mapper.Delegations(ctx, curator)), "Revoked delegation still indexed")
+
+	res = delegateHandler(ctx, types.NewDelegateVotingMsg(follower, types.DefaultRegistryID, nil, sdk.Coin{}))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeNoDelegation), res.Code, "Revoked a missing delegation")
+}
+
+func TestApplicationFee(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	owner := utils.GenerateAddress()
+	treasury := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, owner, mapper.Coins(1000))
+	bond := sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 100,
+	}
+
+	// Fee goes to the treasury and is not part of the bond
+	handler := NewCandidacyHandler(mapper, 100, 10, types.FeeParams{
+		ApplicationFee: 25,
+		Treasury: treasury,
+	})
+	res := handler(ctx, types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Paid listing", bond))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte("25"), getTag(res.Tags, types.TagFee), "Fee not tagged")
+	assert.Equal(t, []byte(treasury.String()), getTag(res.Tags, types.TagTreasury), "Treasury not tagged")
+	assert.Equal(t, int64(875), accountKeeper.GetCoins(ctx, owner).AmountOf("RegistryCoin"), "Fee not charged")
+	assert.Equal(t, int64(25), accountKeeper.GetCoins(ctx, treasury).AmountOf("RegistryCoin"), "Fee not sent to treasury")
+	assert.Equal(t, int64(100), mapper.GetBallot(ctx, "Paid listing").Bond, "Fee added to bond")
+
+	// Without a treasury the fee is burned
+	handler = NewCandidacyHandler(mapper, 100, 10, types.FeeParams{
+		ApplicationFee: 25,
+	})
+	res = handler(ctx, types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Burned listing", bond))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte(types.TreasuryBurned), getTag(res.Tags, types.TagTreasury), "Burn not tagged")
+	assert.Equal(t, int64(750), accountKeeper.GetCoins(ctx, owner).AmountOf("RegistryCoin"), "Fee not charged")
+
+	// Bond and fee must both be covered
+	poor := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, poor, mapper.Coins(110))
+	res = handler(ctx, types.NewDeclareCandidacyMsg(poor, types.DefaultRegistryID, "Cheap listing", bond))
+	assert.Equal(t, sdk.ToABCICode(sdk.CodespaceRoot, sdk.CodeInsufficientFunds), res.Code, "Allowed candidacy without fee")
+}
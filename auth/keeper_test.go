@@ -0,0 +1,474 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/AdityaSripal/token_curated_registry/db"
+	"github.com/AdityaSripal/token_curated_registry/utils"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/tmlibs/log"
+)
+
+// In-memory db.Keeper for unit testing handlers without stores. Balances
+// stand in for accounts and Escrowed counts what the handlers hold.
+type memKeeper struct {
+	deadlines types.DeadlineMode
+	listingLen int64
+	ballots map[string]types.Ballot
+	polls map[string]types.Ballot
+	commits map[string][]byte
+	reveals map[string]types.Vote
+	claims map[string]types.Claim
+	pending map[string]bool
+	delegations map[string]types.Delegation
+	listings map[string]types.Listing
+	archive map[string]types.ArchivedListing
+	history map[string][]types.HistoryEvent
+	Balances map[string]int64
+	Escrowed int64
+}
+
+var _ db.Keeper = &memKeeper{}
+
+func newMemKeeper() *memKeeper {
+	return &memKeeper{
+		ballots: map[string]types.Ballot{},
+		polls: map[string]types.Ballot{},
+		commits: map[string][]byte{},
+		reveals: map[string]types.Vote{},
+		claims: map[string]types.Claim{},
+		pending: map[string]bool{},
+		delegations: map[string]types.Delegation{},
+		listings: map[string]types.Listing{},
+		archive: map[string]types.ArchivedListing{},
+		history: map[string][]types.HistoryEvent{},
+		Balances: map[string]int64{},
+	}
+}
+
+func memVoterKey(voter types.Voter) string {
+	return fmt.Sprintf("%X/%s/%s", voter.Owner, voter.Identifier, voter.PollID)
+}
+
+func memPendingKey(owner sdk.Address, identifier string) string {
+	return fmt.Sprintf("%X/%s", owner, identifier)
+}
+
+func (k *memKeeper) Now(ctx sdk.Context) int64 {
+	if k.deadlines == types.TimeDeadlines {
+		return ctx.BlockHeader().Time
+	}
+	return ctx.BlockHeight()
+}
+
+func (k *memKeeper) RegistryID() string {
+	return types.DefaultRegistryID
+}
+
+func (k *memKeeper) StakingDenom() string {
+	return types.DefaultDenom
+}
+
+func (k *memKeeper) ListingLifetime() int64 {
+	return k.listingLen
+}
+
+func (k *memKeeper) GetBallot(ctx sdk.Context, identifier string) types.Ballot {
+	return k.ballots[identifier]
+}
+
+func (k *memKeeper) SetBallot(ctx sdk.Context, ballot types.Ballot) {
+	k.ballots[ballot.Identifier] = ballot
+}
+
+func (k *memKeeper) AddBallot(ctx sdk.Context, identifier string, owner sdk.Address, applyLen int64, bond int64) sdk.Error {
+	ballot := types.Ballot{
+		Identifier: identifier,
+		Owner: owner,
+		Status: types.StatusApplying,
+		Bond: bond,
+		Deadlines: k.deadlines,
+	}
+	ballot.EndApplyBlockStamp = ballot.Now(ctx) + applyLen
+	k.ballots[identifier] = ballot
+	return nil
+}
+
+func (k *memKeeper) VoteBallot(ctx sdk.Context, owner sdk.Address, identifier string, vote bool, power int64) sdk.Error {
+	ballot, ok := k.ballots[identifier]
+	if !ok {
+		return types.ErrUnknownCandidate("")
+	}
+	if vote {
+		ballot.Approve += power
+	} else {
+		ballot.Deny += power
+	}
+	k.ballots[identifier] = ballot
+	return nil
+}
+
+func (k *memKeeper) DeleteBallot(ctx sdk.Context, identifier string) {
+	delete(k.ballots, identifier)
+}
+
+func (k *memKeeper) ArchivePoll(ctx sdk.Context, ballot types.Ballot) {
+	k.polls[ballot.PollID] = ballot
+}
+
+func (k *memKeeper) ArchivedPolls(ctx sdk.Context, identifier string) []types.Ballot {
+	polls := []types.Ballot{}
+	for _, ballot := range k.polls {
+		if ballot.Identifier == identifier {
+			polls = append(polls, ballot)
+		}
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].PollID < polls[j].PollID
+	})
+	return polls
+}
+
+func (k *memKeeper) GetCommitment(ctx sdk.Context, voter types.Voter) []byte {
+	return k.commits[memVoterKey(voter)]
+}
+
+func (k *memKeeper) SetCommitment(ctx sdk.Context, voter types.Voter, commitment []byte) {
+	k.commits[memVoterKey(voter)] = commitment
+}
+
+func (k *memKeeper) DeleteCommitment(ctx sdk.Context, voter types.Voter) {
+	delete(k.commits, memVoterKey(voter))
+}
+
+func (k *memKeeper) GetReveal(ctx sdk.Context, voter types.Voter) (types.Vote, bool) {
+	vote, ok := k.reveals[memVoterKey(voter)]
+	return vote, ok
+}
+
+func (k *memKeeper) SetReveal(ctx sdk.Context, voter types.Voter, vote types.Vote) {
+	k.reveals[memVoterKey(voter)] = vote
+}
+
+func (k *memKeeper) GetClaim(ctx sdk.Context, voter types.Voter) (types.Claim, bool) {
+	claim, ok := k.claims[memVoterKey(voter)]
+	return claim, ok
+}
+
+func (k *memKeeper) SetClaim(ctx sdk.Context, voter types.Voter, claim types.Claim) {
+	k.claims[memVoterKey(voter)] = claim
+	delete(k.reveals, memVoterKey(voter))
+}
+
+func (k *memKeeper) AddPendingClaim(ctx sdk.Context, owner sdk.Address, identifier string) {
+	k.pending[memPendingKey(owner, identifier)] = true
+}
+
+func (k *memKeeper) DeletePendingClaim(ctx sdk.Context, owner sdk.Address, identifier string) {
+	delete(k.pending, memPendingKey(owner, identifier))
+}
+
+func (k *memKeeper) PendingClaims(ctx sdk.Context, owner sdk.Address) []string {
+	prefix := memPendingKey(owner, "")
+	identifiers := []string{}
+	for key := range k.pending {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			identifiers = append(identifiers, key[len(prefix):])
+		}
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+func (k *memKeeper) GetDelegation(ctx sdk.Context, delegator sdk.Address) (types.Delegation, bool) {
+	delegation, ok := k.delegations[string(delegator)]
+	return delegation, ok
+}
+
+func (k *memKeeper) SetDelegation(ctx sdk.Context, delegation types.Delegation) {
+	k.delegations[string(delegation.Delegator)] = delegation
+}
+
+func (k *memKeeper) DeleteDelegation(ctx sdk.Context, delegator sdk.Address) {
+	delete(k.delegations, string(delegator))
+}
+
+func (k *memKeeper) Delegations(ctx sdk.Context, delegate sdk.Address) []types.Delegation {
+	delegators := []string{}
+	for delegator, delegation := range k.delegations {
+		if string(delegation.Delegate) == string(delegate) {
+			delegators = append(delegators, delegator)
+		}
+	}
+	sort.Strings(delegators)
+
+	delegations := []types.Delegation{}
+	for _, delegator := range delegators {
+		delegations = append(delegations, k.delegations[delegator])
+	}
+	return delegations
+}
+
+func (k *memKeeper) GetListing(ctx sdk.Context, identifier string) types.Listing {
+	return k.listings[identifier]
+}
+
+func (k *memKeeper) AddListing(ctx sdk.Context, identifier string, votes int64, bond int64) {
+	listing := types.Listing{
+		Identifier: identifier,
+		Votes: votes,
+		Bond: bond,
+	}
+	if k.listingLen > 0 {
+		listing.Expiry = k.Now(ctx) + k.listingLen
+	}
+	k.listings[identifier] = listing
+}
+
+func (k *memKeeper) RenewListing(ctx sdk.Context, identifier string) types.Listing {
+	listing := k.listings[identifier]
+	if now := k.Now(ctx); listing.Expiry < now {
+		listing.Expiry = now
+	}
+	listing.Expiry += k.listingLen
+	k.listings[identifier] = listing
+	return listing
+}
+
+func (k *memKeeper) DeleteListing(ctx sdk.Context, identifier string) {
+	delete(k.listings, identifier)
+}
+
+func (k *memKeeper) ArchiveListing(ctx sdk.Context, ballot types.Ballot, reason types.RemovalReason) {
+	listing, ok := k.listings[ballot.Identifier]
+	if !ok {
+		return
+	}
+	delete(k.listings, ballot.Identifier)
+	k.archive[ballot.Identifier] = types.ArchivedListing{
+		Listing: listing,
+		Owner: ballot.Owner,
+		Height: ctx.BlockHeight(),
+		Reason: reason,
+		PollID: ballot.PollID,
+		Approve: ballot.Approve,
+		Deny: ballot.Deny,
+	}
+}
+
+func (k *memKeeper) GetArchivedListing(ctx sdk.Context, identifier string) (types.ArchivedListing, bool) {
+	archived, ok := k.archive[identifier]
+	return archived, ok
+}
+
+func (k *memKeeper) TopListings(ctx sdk.Context, limit int) []types.Listing {
+	listings := []types.Listing{}
+	for _, listing := range k.listings {
+		listings = append(listings, listing)
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Score() != listings[j].Score() {
+			return listings[i].Score() > listings[j].Score()
+		}
+		return listings[i].Identifier < listings[j].Identifier
+	})
+	if len(listings) > limit {
+		listings = listings[:limit]
+	}
+	return listings
+}
+
+func (k *memKeeper) ExpiredListings(ctx sdk.Context, now int64) []types.Listing {
+	listings := []types.Listing{}
+	for _, listing := range k.listings {
+		if listing.Expiry > 0 && listing.Expiry < now {
+			listings = append(listings, listing)
+		}
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Expiry != listings[j].Expiry {
+			return listings[i].Expiry < listings[j].Expiry
+		}
+		return listings[i].Identifier < listings[j].Identifier
+	})
+	return listings
+}
+
+func (k *memKeeper) AppendHistory(ctx sdk.Context, identifier string, event types.HistoryEvent) {
+	event.Height = ctx.BlockHeight()
+	k.history[identifier] = append(k.history[identifier], event)
+}
+
+func (k *memKeeper) HistoryLen(ctx sdk.Context, identifier string) int64 {
+	return int64(len(k.history[identifier]))
+}
+
+func (k *memKeeper) History(ctx sdk.Context, identifier string, offset int64, limit int64) []types.HistoryEvent {
+	events := []types.HistoryEvent{}
+	for i := offset; i < offset + limit && i < int64(len(k.history[identifier])); i++ {
+		events = append(events, k.history[identifier][i])
+	}
+	return events
+}
+
+func (k *memKeeper) Lock(ctx sdk.Context, from sdk.Address, amount int64) sdk.Error {
+	if k.Balances[string(from)] < amount {
+		return sdk.ErrInsufficientCoins(fmt.Sprintf("%X has less than %d", from, amount))
+	}
+	k.Balances[string(from)] -= amount
+	k.Escrowed += amount
+	return nil
+}
+
+func (k *memKeeper) Release(ctx sdk.Context, to sdk.Address, amount int64) sdk.Error {
+	k.Balances[string(to)] += amount
+	k.Escrowed -= amount
+	return nil
+}
+
+func memContext(height int64) sdk.Context {
+	return sdk.NewContext(nil, abci.Header{}, false, nil, log.NewNopLogger()).WithBlockHeight(height)
+}
+
+// Context of a block at height with the given block time
+func memContextAt(height int64, blockTime int64) sdk.Context {
+	return sdk.NewContext(nil, abci.Header{Height: height, Time: blockTime}, false, nil, log.NewNopLogger())
+}
+
+func TestCommitRevealInMemory(t *testing.T) {
+	keeper := newMemKeeper()
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+	keeper.Balances[string(challenger)] = 100
+	keeper.Balances[string(voter)] = 100
+
+	ctx := memContext(0)
+	keeper.AddBallot(ctx, "Listing", owner, 0, 100)
+	res := NewChallengeHandler(keeper, 10, 10, 100)(ctx, types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Listing", sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 100,
+	}, "", ""))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	pollID := keeper.GetBallot(ctx, "Listing").PollID
+
+	commitment := types.VoteCommitment(true, []byte("nonce"))
+
+	res = NewCommitHandler(keeper)(ctx, types.NewCommitMsg(voter, types.DefaultRegistryID, "Listing", commitment))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	votes := types.Voter{
+		Owner: voter,
+		Identifier: "Listing",
+		PollID: pollID,
+	}
+	assert.Equal(t, commitment, keeper.GetCommitment(ctx, votes), "Commitment not stored")
+
+	res = NewCommitHandler(keeper)(ctx, types.NewCommitMsg(voter, types.DefaultRegistryID, "Missing", commitment))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeUnknownCandidate), res.Code, "Committed on missing ballot")
+
+	ctx = memContext(11)
+	res = NewRevealHandler(keeper)(ctx, types.NewRevealMsg(voter, types.DefaultRegistryID, "Listing", true, []byte("nonce"), sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 60,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Nil(t, keeper.GetCommitment(ctx, votes), "Commitment not deleted")
+
+	vote, ok := keeper.GetReveal(ctx, votes)
+	assert.True(t, ok, "Reveal not stored")
+	assert.Equal(t, int64(60), vote.Power, "Reveal power wrong")
+	assert.Equal(t, int64(60), keeper.GetBallot(ctx, "Listing").Approve, "Vote not tallied")
+	assert.Equal(t, []string{"Listing"}, keeper.PendingClaims(ctx, voter), "Reveal not pending a claim")
+	assert.Equal(t, int64(40), keeper.Balances[string(voter)], "Stake not taken from voter")
+	assert.Equal(t, int64(100 + 60), keeper.Escrowed, "Stake not escrowed with the challenge bond")
+}
+
+func TestTimeDeadlinesInMemory(t *testing.T) {
+	keeper := newMemKeeper()
+	keeper.deadlines = types.TimeDeadlines
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+	keeper.Balances[string(challenger)] = 100
+	keeper.Balances[string(voter)] = 100
+
+	// Phases run on block time, a few blocks cover all of them
+	ctx := memContextAt(1, 1000)
+	keeper.AddBallot(ctx, "Listing", owner, 10, 100)
+	assert.Equal(t, int64(1010), keeper.GetBallot(ctx, "Listing").EndApplyBlockStamp, "Application phase not timed")
+
+	res := NewChallengeHandler(keeper, 60, 60, 100)(memContextAt(2, 1005), types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Listing", sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 100,
+	}, "", ""))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	ballot := keeper.GetBallot(ctx, "Listing")
+	assert.Equal(t, int64(1065), ballot.EndCommitBlockStamp, "Commit phase not timed")
+	assert.Equal(t, int64(1125), ballot.EndRevealBlockStamp, "Reveal phase not timed")
+
+	commitment := types.VoteCommitment(false, []byte("nonce"))
+	res = NewCommitHandler(keeper)(memContextAt(3, 1064), types.NewCommitMsg(voter, types.DefaultRegistryID, "Listing", commitment))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	res = NewRevealHandler(keeper)(memContextAt(4, 1065), types.NewRevealMsg(voter, types.DefaultRegistryID, "Listing", false, []byte("nonce"), sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 60,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(60), keeper.GetBallot(ctx, "Listing").Deny, "Vote not tallied")
+}
+
+func TestClaimRewardInMemory(t *testing.T) {
+	keeper := newMemKeeper()
+	first := utils.GenerateAddress()
+	second := utils.GenerateAddress()
+
+	// Settled ballot with a pool of 10 split between winning stakes of 1 and 2
+	ctx := memContext(30)
+	keeper.SetBallot(ctx, types.Ballot{
+		Identifier: "Listing",
+		Status: types.StatusAccepted,
+		Settled: true,
+		PollID: "poll",
+		Approve: 3,
+		Pool: 10,
+	})
+	for i, voter := range []sdk.Address{first, second} {
+		voterKey := types.Voter{
+			Owner: voter,
+			Identifier: "Listing",
+			PollID: "poll",
+		}
+		keeper.SetReveal(ctx, voterKey, types.Vote{
+			Choice: true,
+			Power: int64(i + 1),
+		})
+		keeper.AddPendingClaim(ctx, voter, "Listing")
+	}
+	keeper.Escrowed = 13
+
+	handler := NewClaimRewardHandler(keeper)
+	res := handler(ctx, types.NewClaimRewardMsg(first, types.DefaultRegistryID, "Listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(1 + 3), keeper.Balances[string(first)], "First claim not paid pro rata")
+
+	// Last winning voter gets the remainder of the pool
+	res = handler(ctx, types.NewClaimRewardMsg(second, types.DefaultRegistryID, "Listing"))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(2 + 7), keeper.Balances[string(second)], "Last claim not paid the remainder")
+	assert.Equal(t, int64(0), keeper.Escrowed, "Escrow not paid out exactly")
+
+	res = handler(ctx, types.NewClaimRewardMsg(first, types.DefaultRegistryID, "Listing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAlreadyClaimed), res.Code, "Claimed twice")
+	assert.Equal(t, []string{}, keeper.PendingClaims(ctx, first), "Claimed reveal still pending")
+}
+
+func TestApplyUnknownInMemory(t *testing.T) {
+	res := NewApplyHandler(newMemKeeper(), types.NewRatio(1, 2), types.NewRatio(1, 2), types.AppealParams{})(memContext(0), types.NewApplyMsg(utils.GenerateAddress(), types.DefaultRegistryID, "Missing"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeUnknownCandidate), res.Code, "Applied missing ballot")
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	types "github.com/AdityaSripal/token_curated_registry/types"
+	db "github.com/AdityaSripal/token_curated_registry/db"
+)
+
+// Builds the handler for one registry from its scoped keeper and params
+type RegistryHandlerBuilder func(keeper db.Keeper, params types.RegistryParams) sdk.Handler
+
+// Route a registry msg to the handler built for the registry it names
+func NewRegistryHandler(registryMapper db.RegistryMapper, ballotMapper db.BallotMapper, build RegistryHandlerBuilder) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		registryMsg, ok := msg.(types.RegistryMsg)
+		if !ok {
+			return sdk.ErrUnknownRequest("Msg does not name a registry").Result()
+		}
+		registry, found := registryMapper.GetRegistry(ctx, registryMsg.GetRegistry())
+		if !found {
+			return types.ErrUnknownRegistry("").Result()
+		}
+		// Revoking a delegation stakes nothing
+		if stakingMsg, ok := msg.(types.StakingMsg); ok && stakingMsg.GetStake().Amount > 0 {
+			denom := stakingMsg.GetStake().Denom
+			if !registryMapper.IsStakingDenom(ctx, denom) {
+				return types.ErrInvalidBond(fmt.Sprintf("%s is not a staking denom of this chain", denom)).Result()
+			}
+		}
+		res := build(ballotMapper.ForParams(registry), registry.Params)(ctx, msg)
+		if res.IsOK() {
+			res.Tags = res.Tags.AppendTag(types.TagRegistry, []byte(registry.ID))
+		}
+		return res
+	}
+}
+
+func NewCreateRegistryHandler(registryMapper db.RegistryMapper) sdk.Handler {
+	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		createMsg := msg.(types.CreateRegistryMsg)
+
+		_, found := registryMapper.GetRegistry(ctx, createMsg.Registry)
+		if found {
+			return types.ErrRegistryExists("").Result()
+		}
+
+		if !registryMapper.IsStakingDenom(ctx, createMsg.Params.Denom) {
+			return types.ErrInvalidParams(fmt.Sprintf("%s is not a staking denom of this chain", createMsg.Params.Denom)).Result()
+		}
+
+		registryMapper.SetRegistry(ctx, types.Registry{
+			ID: createMsg.Registry,
+			Creator: createMsg.Creator,
+			Params: createMsg.Params,
+		})
+
+		tags := sdk.NewTags(
+			types.TagAction, []byte("create_registry"),
+			types.TagRegistry, []byte(createMsg.Registry),
+			types.TagOwner, []byte(createMsg.Creator.String()),
+		)
+		return sdk.Result{
+			Tags: tags,
+		}
+	}
+}
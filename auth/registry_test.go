@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/AdityaSripal/token_curated_registry/db"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/tmlibs/log"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/bank"
+	"github.com/AdityaSripal/token_curated_registry/utils"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, registryKey := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+	registryMapper := db.NewRegistryMapper(registryKey, cdc)
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper = mapper.WithBank(accountKeeper)
+
+	addr := utils.GenerateAddress()
+	account := auth.NewBaseAccountWithAddress(addr)
+	account.SetCoins([]sdk.Coin{sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 1000,
+	}})
+	accountMapper.SetAccount(ctx, &account)
+
+	registryMapper.SetStakingDenoms(ctx, []string{types.DefaultDenom, "NewsCoin"})
+
+	params := types.RegistryParams{
+		Denom: types.DefaultDenom,
+		MinDeposit: 100,
+		ApplyLen: 10,
+		CommitLen: 10,
+		RevealLen: 10,
+		DispensationPct: types.NewRatio(1, 2),
+		Quorum: types.NewRatio(1, 2),
+		Deadlines: types.BlockDeadlines,
+	}
+
+	createHandler := NewCreateRegistryHandler(registryMapper)
+	declareHandler := NewRegistryHandler(registryMapper, mapper, func(keeper db.Keeper, params types.RegistryParams) sdk.Handler {
+		return NewCandidacyHandler(keeper, params.MinDeposit, params.ApplyLen, params.Fees)
+	})
+
+	bond := sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 200,
+	}
+
+	res := declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, "news", "Unique registry listing", bond))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeUnknownRegistry), res.Code, "Msg routed to missing registry")
+
+	res = createHandler(ctx, types.NewCreateRegistryMsg(addr, "news", params))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte("news"), getTag(res.Tags, types.TagRegistry), "Registry not tagged")
+
+	res = createHandler(ctx, types.NewCreateRegistryMsg(addr, "news", params))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeRegistryExists), res.Code, "Registry created twice")
+
+	// Registries can only stake in the chain's staking denoms
+	fake := params
+	fake.Denom = "FakeCoin"
+	res = createHandler(ctx, types.NewCreateRegistryMsg(addr, "fake", fake))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeInvalidParams), res.Code, "Registry created with unknown denom")
+
+	coins := params
+	coins.Denom = "NewsCoin"
+	res = createHandler(ctx, types.NewCreateRegistryMsg(addr, "coins", coins))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	// Bonds must be in the registry's own denom
+	res = declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, "coins", "Unique registry listing", bond))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeInvalidBond), res.Code, "Bond accepted in another registry's denom")
+
+	// Which must be one of the chain's staking denoms, even if well-formed
+	// and the registry's own
+	registryMapper.SetRegistry(ctx, types.Registry{ID: "fake", Params: fake})
+	res = declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, "fake", "Unique registry listing", sdk.Coin{
+		Denom: "FakeCoin",
+		Amount: 200,
+	}))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeInvalidBond), res.Code, "Bond accepted in unconfigured denom")
+	assert.Contains(t, res.Log, "not a staking denom", "Unconfigured denom not checked against the chain's staking denoms")
+
+	// Second registry requires a larger deposit and measures deadlines in time
+	strict := params
+	strict.MinDeposit = 500
+	strict.Deadlines = types.TimeDeadlines
+	createHandler(ctx, types.NewCreateRegistryMsg(addr, "strict", strict))
+
+	res = declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, "news", "Unique registry listing", bond))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, []byte("news"), getTag(res.Tags, types.TagRegistry), "Registry not tagged")
+
+	res = declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, "strict", "Unique registry listing", bond))
+	assert.NotEqual(t, sdk.ABCICodeType(0), res.Code, "Registry params not applied")
+
+	// Same identifier is free in another registry
+	res = declareHandler(ctx, types.NewDeclareCandidacyMsg(addr, "strict", "Unique registry listing", sdk.Coin{
+		Denom: "RegistryCoin",
+		Amount: 500,
+	}))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+
+	news := mapper.ForRegistry("news").GetBallot(ctx, "Unique registry listing")
+	strictBallot := mapper.ForRegistry("strict").GetBallot(ctx, "Unique registry listing")
+
+	assert.Equal(t, int64(200), news.Bond, "Ballot not stored in its registry")
+	assert.Equal(t, int64(500), strictBallot.Bond, "Ballot not stored in its registry")
+	assert.Equal(t, types.TimeDeadlines, strictBallot.Deadlines, "Registry deadline mode not applied")
+	assert.Equal(t, types.Ballot{}, mapper.GetBallot(ctx, "Unique registry listing"), "Ballot stored outside registry namespace")
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+	db "github.com/AdityaSripal/token_curated_registry/db"
+)
+
+// Bring the store to the current schema at the start of the block the
+// schema mapper schedules. The app refuses to start on an older store unless
+// its next block is that block, so no handler runs on the old layout.
+// Migrations run on a cache: one that fails leaves the store untouched and
+// is passed to halt, since no block can be processed against a store it
+// cannot decode.
+func NewBeginBlocker(schemaMapper db.SchemaMapper, stores db.Stores, halt func(err error)) sdk.BeginBlocker {
+	return func(ctx sdk.Context, req abci.RequestBeginBlock) abci.ResponseBeginBlock {
+		if !schemaMapper.Due(ctx) {
+			return abci.ResponseBeginBlock{}
+		}
+		from := schemaMapper.GetVersion(ctx)
+		cache := ctx.MultiStore().CacheMultiStore()
+		err := schemaMapper.Migrate(ctx.WithMultiStore(cache), stores)
+		if err != nil {
+			ctx.Logger().Error("Store migration failed", "height", ctx.BlockHeight(), "err", err)
+			halt(err)
+			return abci.ResponseBeginBlock{}
+		}
+		cache.Write()
+		ctx.Logger().Info("Migrated store", "height", ctx.BlockHeight(), "from", from, "to", db.SchemaVersion)
+		return abci.ResponseBeginBlock{}
+	}
+}
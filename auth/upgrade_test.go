@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/AdityaSripal/token_curated_registry/db"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/tmlibs/log"
+	"github.com/tendermint/go-amino"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/bank"
+	"github.com/AdityaSripal/token_curated_registry/utils"
+)
+
+func TestBeginBlockerMigration(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, mainKey, registryKey := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{Height: 10}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+	stores := db.Stores{
+		Ballots: db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc),
+		Registries: db.NewRegistryMapper(registryKey, cdc),
+		DefaultParams: types.RegistryParams{Denom: "RegistryCoin", Deadlines: types.BlockDeadlines},
+	}
+
+	var halted error
+	halt := func(err error) {
+		halted = err
+	}
+
+	// A failing migration halts the node and leaves the store untouched
+	failing := db.NewSchemaMapper(mainKey, cdc).WithMigration(db.Migration{From: 1, Name: "failing", Migrate: func(ctx sdk.Context, stores db.Stores) error {
+		ctx.KVStore(ballotKey).Set([]byte("written"), []byte{})
+		return errors.New("cannot decode")
+	}})
+	NewBeginBlocker(failing, stores, halt)(ctx, abci.RequestBeginBlock{})
+	assert.NotNil(t, halted, "Node not halted on failed migration")
+	assert.Nil(t, ctx.KVStore(ballotKey).Get([]byte("written")), "Failed migration written")
+	assert.Equal(t, int64(1), failing.GetVersion(ctx), "Version recorded for failed migration")
+
+	halted = nil
+	schema := db.NewSchemaMapper(mainKey, cdc)
+	NewBeginBlocker(schema, stores, halt)(ctx, abci.RequestBeginBlock{})
+	assert.Nil(t, halted)
+	assert.Equal(t, db.SchemaVersion, schema.GetVersion(ctx), "Store not migrated")
+}
+
+// Stored types of the first release, registered under the names it used
+type ballotV1 struct {
+	Identifier string
+	Owner sdk.Address
+	Challenger sdk.Address
+	Active bool
+	Approve int64
+	Deny int64
+	Bond int64
+	EndApplyBlockStamp int64
+	EndCommitBlockStamp int64
+	EndRevealBlockStamp int64
+}
+
+type listingV1 struct {
+	Identifier string
+	Votes int64
+}
+
+type voterV1 struct {
+	Owner sdk.Address
+	Identifier string
+}
+
+type voteV1 struct {
+	Choice bool
+	Power int64
+}
+
+func TestFirstReleaseVotes(t *testing.T) {
+	// The schema version shares the account store
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, registryKey := db.SetupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{Height: 50}, false, nil, log.NewNopLogger())
+
+	cdc := db.MakeCodec()
+	cdcV1 := amino.NewCodec()
+	cdcV1.RegisterConcrete(listingV1{}, "types/Listing", nil)
+	cdcV1.RegisterConcrete(voterV1{}, "types/Voter", nil)
+	cdcV1.RegisterConcrete(voteV1{}, "types/Vote", nil)
+	cdcV1.RegisterConcrete(ballotV1{}, "types/Ballot", nil)
+	setV1 := func(key sdk.StoreKey, storeKey []byte, value interface{}) {
+		bz, err := cdcV1.MarshalBinary(value)
+		assert.Nil(t, err)
+		ctx.KVStore(key).Set(storeKey, bz)
+	}
+	voterKey := func(owner sdk.Address, identifier string) []byte {
+		bz, err := cdcV1.MarshalBinary(voterV1{Owner: owner, Identifier: identifier})
+		assert.Nil(t, err)
+		return bz
+	}
+
+	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
+	accountKeeper := bank.NewKeeper(accountMapper)
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc).WithBank(accountKeeper)
+
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+	accountKeeper.AddCoins(ctx, voter, []sdk.Coin{{Denom: "RegistryCoin", Amount: 100}})
+
+	// Voter committed on a poll still open and was paid on an applied one
+	setV1(ballotKey, []byte("voting"), ballotV1{
+		Identifier: "voting",
+		Owner: owner,
+		Challenger: challenger,
+		Active: true,
+		Bond: 100,
+		EndApplyBlockStamp: 10,
+		EndCommitBlockStamp: 45,
+		EndRevealBlockStamp: 55,
+	})
+	ctx.KVStore(commitKey).Set(voterKey(voter, "voting"), types.FirstReleaseCommitment([]byte("salt")))
+	setV1(ballotKey, []byte("kept"), ballotV1{
+		Identifier: "kept",
+		Owner: owner,
+		Challenger: challenger,
+		Approve: 30,
+		Bond: 100,
+		EndApplyBlockStamp: 10,
+		EndCommitBlockStamp: 20,
+		EndRevealBlockStamp: 30,
+	})
+	setV1(listKey, []byte("kept"), listingV1{Identifier: "kept", Votes: 30})
+	setV1(revealKey, voterKey(voter, "kept"), voteV1{Choice: true, Power: 30})
+
+	stores := db.Stores{
+		Ballots: mapper,
+		Registries: db.NewRegistryMapper(registryKey, cdc),
+		DefaultParams: types.RegistryParams{Denom: "RegistryCoin", Deadlines: types.BlockDeadlines},
+	}
+	halt := func(err error) {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	NewBeginBlocker(db.NewSchemaMapper(accountKey, cdc), stores, halt)(ctx, abci.RequestBeginBlock{})
+
+	scoped := mapper.ForRegistry(types.DefaultRegistryID)
+	coin := sdk.Coin{Denom: "RegistryCoin", Amount: 50}
+
+	// Commitments made before the upgrade are revealed under the first release's rule
+	res := NewRevealHandler(scoped)(ctx, types.NewRevealMsg(voter, types.DefaultRegistryID, "voting", false, []byte("salt"), coin))
+	assert.Equal(t, sdk.ABCICodeType(0), res.Code, res.Log)
+	assert.Equal(t, int64(50), scoped.GetBallot(ctx, "voting").Deny, "First release commitment not revealed")
+
+	// Claims the first release paid are not paid again
+	res = NewClaimRewardHandler(scoped)(ctx, types.NewClaimRewardMsg(voter, types.DefaultRegistryID, "kept"))
+	assert.Equal(t, sdk.ToABCICode(types.DefaultCodespace, types.CodeAlreadyClaimed), res.Code, "Paid claim claimed again")
+	assert.Equal(t, int64(50), accountKeeper.GetCoins(ctx, voter).AmountOf("RegistryCoin"), "Paid claim paid again")
+}
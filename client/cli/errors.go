@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+// What each registry error means for the client and what to do about it
+var explanations = map[sdk.CodeType]string{
+	types.CodeInvalidBond:           "The bond must be a positive amount of the registry's staking token.",
+	types.CodeInvalidChallenge:      fmt.Sprintf("A challenge's reason may be at most %d bytes and its evidence at most %d bytes.", types.MaxReasonLength, types.MaxEvidenceLength),
+	types.CodeInvalidIdentifier:     "Identifiers must be non-empty and may not start with a 0x00 or 0x01 byte, which are reserved for the registry's indexes.",
+	types.CodeVoteMismatch:          "The revealed vote and nonce do not hash to the commitment. Reveal with the exact vote and nonce used when committing.",
+	types.CodeUnknownCandidate:      "No candidate or listing exists with this identifier. Check the identifier or declare candidacy first.",
+	types.CodeCandidateExists:       "A candidate or listing already exists with this identifier. Choose another identifier.",
+	types.CodeAlreadyChallenged:     "The candidate has already been challenged, and can only be challenged once. Vote on the open challenge instead.",
+	types.CodeNotCommitPhase:        "Votes can only be committed after a challenge and before the commit deadline.",
+	types.CodeNotRevealPhase:        "Votes can only be revealed after the commit deadline and before the reveal deadline.",
+	types.CodeChallengeBondTooLow:   "A challenge must post at least the candidate's bond.",
+	types.CodeChallengeBondMismatch: "A challenge must post exactly the candidate's bond.",
+	types.CodeApplyPhaseNotEnded:    "The application phase is still open. Apply again once it has ended.",
+	types.CodeRevealPhaseNotEnded:   "The challenge vote is still open. Apply again once the reveal phase has ended.",
+	types.CodeInvalidTransition:     "The ballot's status does not allow this. Query the ballot to see its status.",
+	types.CodeDuplicateVote:         "This address has already revealed a vote on this challenge.",
+	types.CodeBallotNotApplied:      "The challenge vote has not been applied yet. Send an apply tx before claiming.",
+	types.CodeNoVote:                "This address has no revealed vote on the listing's polls.",
+	types.CodeAlreadyClaimed:        "Rewards and refunds on each poll can only be claimed once. Query the vote to see the recorded claim.",
+	types.CodeNotAppealable:         "Appeals are only possible once, after a challenge is applied and before the appeal deadline.",
+	types.CodeNotParty:              "Only the listing owner or the challenger may appeal.",
+	types.CodeAppealBondTooLow:      "An appeal must post the candidate bond times the configured appeal factor.",
+	types.CodeAppealPhaseNotEnded:   "The decision can still be appealed. Apply again once the appeal phase has ended.",
+	types.CodeAwaitingCouncil:       "The appeal is waiting for the council to decide.",
+	types.CodeNoCouncilReview:       "There is no appeal waiting for a council decision on this listing.",
+	types.CodeNotCouncil:            "The decision must be signed by a majority of council members and by council members only.",
+	types.CodeInvalidRegistry:       "Registry IDs are 1 to 32 lowercase letters, digits, - or _.",
+	types.CodeUnknownRegistry:       "No registry exists with this ID. Pass --registry or create it first.",
+	types.CodeRegistryExists:        "A registry with this ID already exists. Choose another ID.",
+	types.CodeInvalidParams:         "Check the registry parameters: positive deposit and phases, shares between 0 and 1, deadlines block or time, fees not negative.",
+	types.CodeInvalidDelegation:     "Delegate voting power to an address other than your own.",
+	types.CodeNoDelegation:          "You have not delegated voting power on this registry, so there is nothing to revoke.",
+	types.CodeNotListed:             "The listing is not in the registry. It may have expired or lost a challenge.",
+	types.CodeNotListingOwner:       "Only the key that declared the listing can renew it.",
+	types.CodeNoExpiry:              "Listings in this registry never expire, so there is nothing to renew.",
+	types.CodeRenewalTooEarly:       "The listing is renewed for more than one lifetime ahead already. Renew it once less than a lifetime is left.",
+}
+
+// Human readable explanation of an ABCI result code. Returns the empty string
+// for codes outside the registry codespace.
+func ExplainCode(abciCode uint32) string {
+	codespace := sdk.CodespaceType(abciCode >> 16)
+	code := sdk.CodeType(abciCode & 0xFFFF)
+	if codespace != types.DefaultCodespace {
+		return ""
+	}
+	explanation, ok := explanations[code]
+	if !ok {
+		return types.CodeToDefaultMsg(code)
+	}
+	return fmt.Sprintf("%s: %s", types.CodeToDefaultMsg(code), explanation)
+}
+
+func ExplainCodeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "explain [code]",
+		Short: "Explain a result code returned by the registry",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			abciCode, err := strconv.ParseUint(args[0], 0, 32)
+			if err != nil {
+				return err
+			}
+			explanation := ExplainCode(uint32(abciCode))
+			if explanation == "" {
+				return fmt.Errorf("Code %s is not a registry error", args[0])
+			}
+			fmt.Println(explanation)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,431 @@
+package cli
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/wire"
+
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+// Query the ballot of a candidate or listing, including any challenge reason and evidence
+func GetBallotCmd(storeName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "ballot [listing_identifier]",
+		Short: "Query the ballot and status of a candidate or listing",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+
+			key := append(types.RegistryPrefix(viper.GetString(flagRegistry)), []byte(args[0])...)
+			res, err := ctx.Query(key, storeName)
+			if err != nil {
+				return err
+			}
+			if len(res) == 0 {
+				return errors.Errorf("No ballot found for %s", args[0])
+			}
+
+			ballot := types.Ballot{}
+			err = cdc.UnmarshalBinary(res, &ballot)
+			if err != nil {
+				return err
+			}
+
+			// Stored status moves from commit to reveal on the ballot's next
+			// write, show the status a tx sent now would see
+			height, blockTime, err := chainNow(ctx)
+			if err != nil {
+				return err
+			}
+			ballot.Status = ballot.StatusAt(ballot.NowAt(height, blockTime))
+
+			output, err := wire.MarshalJSONIndent(cdc, ballot)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+// Query an accepted listing
+func GetListingCmd(storeName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "listing [listing_identifier]",
+		Short: "Query a listing in the registry",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+
+			key := append(types.RegistryPrefix(viper.GetString(flagRegistry)), []byte(args[0])...)
+			res, err := ctx.Query(key, storeName)
+			if err != nil {
+				return err
+			}
+			if len(res) == 0 {
+				return errors.Errorf("%s is not listed", args[0])
+			}
+
+			listing := types.Listing{}
+			err = cdc.UnmarshalBinary(res, &listing)
+			if err != nil {
+				return err
+			}
+
+			output, err := wire.MarshalJSONIndent(cdc, listing)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+// Query why and when a listing was removed from the registry
+func GetArchivedListingCmd(storeName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "archived [listing_identifier]",
+		Short: "Query a listing removed from the registry",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+
+			key := append(types.RegistryPrefix(viper.GetString(flagRegistry)), []byte(args[0])...)
+			res, err := ctx.Query(key, storeName)
+			if err != nil {
+				return err
+			}
+			if len(res) == 0 {
+				return errors.Errorf("%s was never removed from the registry", args[0])
+			}
+
+			archived := types.ArchivedListing{}
+			err = cdc.UnmarshalBinary(res, &archived)
+			if err != nil {
+				return err
+			}
+
+			output, err := wire.MarshalJSONIndent(cdc, archived)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+const (
+	flagLimit = "limit"
+	flagPoll  = "poll"
+	flagPage  = "page"
+)
+
+// Query listings ranked by score, votes of the last survived challenge plus the owner's bond
+func GetTopListingsCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "top-listings",
+		Short: "Query the highest ranked listings in the registry",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+
+			limit := viper.GetInt64(flagLimit)
+			if limit <= 0 || limit > types.MaxTopListings {
+				return errors.Errorf("Limit must be between 1 and %d, got %d", types.MaxTopListings, limit)
+			}
+
+			// The node walks the rank index and stops after limit entries
+			query, err := cdc.MarshalBinary(types.TopListingsQuery{
+				Registry: viper.GetString(flagRegistry),
+				Limit: limit,
+			})
+			if err != nil {
+				return err
+			}
+			node, err := ctx.GetNode()
+			if err != nil {
+				return err
+			}
+			res, err := node.ABCIQuery(types.TopListingsPath, query)
+			if err != nil {
+				return err
+			}
+			if res.Response.Code != 0 {
+				return errors.Errorf("Query failed: %s", res.Response.Log)
+			}
+
+			listings := []types.Listing{}
+			err = cdc.UnmarshalBinary(res.Response.Value, &listings)
+			if err != nil {
+				return err
+			}
+
+			output, err := wire.MarshalJSONIndent(cdc, listings)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	cmd.Flags().Int64(flagLimit, 10, "Maximum number of listings to return")
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry to rank")
+	return cmd
+}
+
+// Page of a listing's event history
+type historyPage struct {
+	Total int64
+	Page int64
+	Events []types.HistoryEvent
+}
+
+// Query a listing's event history, oldest first, one page at a time
+func GetHistoryCmd(storeName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "history [listing_identifier]",
+		Short: "Query the event history of a listing",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+			registry := viper.GetString(flagRegistry)
+
+			limit := viper.GetInt64(flagLimit)
+			if limit <= 0 {
+				return errors.Errorf("Limit must be positive, got %d", limit)
+			}
+			page := viper.GetInt64(flagPage)
+			if page < 1 {
+				return errors.Errorf("Pages start at 1, got %d", page)
+			}
+
+			res, err := ctx.Query(types.HistoryPrefix(registry, args[0]), storeName)
+			if err != nil {
+				return err
+			}
+			if len(res) == 0 {
+				return errors.Errorf("No history for %s", args[0])
+			}
+
+			// Events are keyed by position, so only the requested page is fetched
+			result := historyPage{
+				Total: int64(binary.BigEndian.Uint64(res)),
+				Page: page,
+				Events: []types.HistoryEvent{},
+			}
+			for seq := (page - 1) * limit; seq < page * limit && seq < result.Total; seq++ {
+				res, err = ctx.Query(types.HistoryKey(registry, args[0], seq), storeName)
+				if err != nil {
+					return err
+				}
+				event := types.HistoryEvent{}
+				err = cdc.UnmarshalBinary(res, &event)
+				if err != nil {
+					return err
+				}
+				result.Events = append(result.Events, event)
+			}
+
+			output, err := wire.MarshalJSONIndent(cdc, result)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	cmd.Flags().Int64(flagPage, 1, "Page of the history to return")
+	cmd.Flags().Int64(flagLimit, 20, "Number of events per page")
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+// Query a registry and its parameters
+func GetRegistryCmd(storeName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "registry [registry_id]",
+		Short: "Query a registry and its parameters",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+
+			res, err := ctx.Query([]byte(args[0]), storeName)
+			if err != nil {
+				return err
+			}
+			if len(res) == 0 {
+				return errors.Errorf("No registry %s", args[0])
+			}
+
+			registry := types.Registry{}
+			err = cdc.UnmarshalBinary(res, &registry)
+			if err != nil {
+				return err
+			}
+
+			output, err := wire.MarshalJSONIndent(cdc, registry)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	return cmd
+}
+
+// Vote of an address on one poll and whether its reward was claimed
+type voteStatus struct {
+	PollID string
+	Vote *types.Vote
+	Claimed bool
+	Claim *types.Claim
+}
+
+// Query a voter's revealed vote and claim status. Defaults to the ballot's current poll
+func GetVoteCmd(ballotStoreName, revealStoreName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "vote [listing_identifier] [voter_address]",
+		Short: "Query a revealed vote and whether its reward was claimed",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper()
+			prefix := types.RegistryPrefix(viper.GetString(flagRegistry))
+
+			owner, err := sdk.GetAddress(args[1])
+			if err != nil {
+				return err
+			}
+
+			pollID := viper.GetString(flagPoll)
+			if pollID == "" {
+				res, err := ctx.Query(append(prefix, []byte(args[0])...), ballotStoreName)
+				if err != nil {
+					return err
+				}
+				if len(res) == 0 {
+					return errors.Errorf("No ballot found for %s", args[0])
+				}
+				ballot := types.Ballot{}
+				err = cdc.UnmarshalBinary(res, &ballot)
+				if err != nil {
+					return err
+				}
+				pollID = ballot.PollID
+			}
+
+			voter, err := cdc.MarshalBinary(types.Voter{
+				Owner: owner,
+				Identifier: args[0],
+				PollID: pollID,
+			})
+			if err != nil {
+				return err
+			}
+			status := voteStatus{
+				PollID: pollID,
+			}
+
+			claimKey := append(append(append([]byte{}, prefix...), types.ClaimPrefix...), voter...)
+			res, err := ctx.Query(claimKey, revealStoreName)
+			if err != nil {
+				return err
+			}
+			if len(res) > 0 {
+				claim := types.Claim{}
+				err = cdc.UnmarshalBinary(res, &claim)
+				if err != nil {
+					return err
+				}
+				status.Claimed = true
+				status.Claim = &claim
+				status.Vote = &claim.Vote
+			} else {
+				res, err = ctx.Query(append(append([]byte{}, prefix...), voter...), revealStoreName)
+				if err != nil {
+					return err
+				}
+				if len(res) == 0 {
+					return errors.Errorf("%s has no revealed vote on poll %s", args[1], pollID)
+				}
+				vote := types.Vote{}
+				err = cdc.UnmarshalBinary(res, &vote)
+				if err != nil {
+					return err
+				}
+				status.Vote = &vote
+			}
+
+			output, err := wire.MarshalJSONIndent(cdc, status)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	cmd.Flags().String(flagPoll, "", "Poll ID, defaults to the ballot's current poll")
+	return cmd
+}
+
+// Ballot of a listing on the registry
+func queryBallot(ctx context.CoreContext, storeName string, registry string, identifier string, cdc *wire.Codec) (types.Ballot, error) {
+	ballot := types.Ballot{}
+	res, err := ctx.Query(append(types.RegistryPrefix(registry), []byte(identifier)...), storeName)
+	if err != nil {
+		return ballot, err
+	}
+	if len(res) == 0 {
+		return ballot, errors.Errorf("No ballot found for %s", identifier)
+	}
+	err = cdc.UnmarshalBinary(res, &ballot)
+	return ballot, err
+}
+
+// Unrevealed commitment of a voter
+type committedVote struct {
+	Voter types.Voter
+	Commitment []byte
+}
+
+// Unrevealed commitments of owner on the registry. Commits are keyed by the
+// encoded Voter, so the registry's subspace is scanned for the owner's.
+func queryCommits(ctx context.CoreContext, storeName string, registry string, owner sdk.Address, cdc *wire.Codec) ([]committedVote, error) {
+	prefix := types.CommitPrefix(registry, owner)
+	resKVs, err := ctx.QuerySubspace(cdc, prefix, storeName)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []committedVote{}
+	for _, kv := range resKVs {
+		voter := types.Voter{}
+		err = cdc.UnmarshalBinary(kv.Key[len(prefix):], &voter)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, committedVote{
+			Voter: voter,
+			Commitment: kv.Value,
+		})
+	}
+	return commits, nil
+}
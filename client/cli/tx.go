@@ -0,0 +1,549 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/client/keys"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/wire"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+const (
+	flagReason     = "reason"
+	flagEvidence   = "evidence"
+	flagRegistry   = "registry"
+	flagAll        = "all"
+	flagRevoke     = "revoke"
+	flagPending    = "pending"
+	flagBond       = "bond"
+	flagDeriveSalt = "derive-salt"
+)
+
+// Build a tx for msg signed by the named key over types.StdSignBytes.
+// Replaces CoreContext.SignAndBuild, which signs over the SDK's sign document.
+func SignAndBuild(ctx context.CoreContext, name, passphrase string, msg sdk.Msg, cdc *wire.Codec) ([]byte, error) {
+	if ctx.ChainID == "" {
+		return nil, errors.Errorf("Chain ID required but not specified")
+	}
+	sequence := ctx.Sequence
+	fee := auth.StdFee{Gas: 10000}
+
+	keybase, err := keys.GetKeyBase()
+	if err != nil {
+		return nil, err
+	}
+
+	signBytes := types.StdSignBytes(ctx.ChainID, []int64{sequence}, fee, msg)
+	sig, pubkey, err := keybase.Sign(name, passphrase, signBytes)
+	if err != nil {
+		return nil, err
+	}
+	sigs := []auth.StdSignature{{
+		PubKey:    pubkey,
+		Signature: sig,
+		Sequence:  sequence,
+	}}
+
+	tx := auth.NewStdTx(msg, fee, sigs)
+	return cdc.MarshalBinary(tx)
+}
+
+// Sign msg with the key given by --name and broadcast it
+func signBuildBroadcast(ctx context.CoreContext, passphrase string, msg sdk.Msg, cdc *wire.Codec) (*ctypes.ResultBroadcastTxCommit, error) {
+	// default to next sequence number if none provided
+	ctx, err := context.EnsureSequence(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txBytes, err := SignAndBuild(ctx, ctx.FromAddressName, passphrase, msg, cdc)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ctx.BroadcastTx(txBytes)
+	if err != nil && res != nil {
+		if explanation := ExplainCode(res.CheckTx.Code); explanation != "" {
+			return res, errors.Wrap(err, explanation)
+		}
+		if explanation := ExplainCode(res.DeliverTx.Code); explanation != "" {
+			return res, errors.Wrap(err, explanation)
+		}
+	}
+	return res, err
+}
+
+// Build msg from the sender's address and broadcast it
+func sendMsg(cdc *wire.Codec, buildMsg func(from sdk.Address) (sdk.Msg, error)) error {
+	return sendKeyMsg(cdc, func(ctx context.CoreContext, from sdk.Address, passphrase string) (sdk.Msg, error) {
+		return buildMsg(from)
+	})
+}
+
+// Like sendMsg, for msgs that need the key's passphrase or a node query to build
+func sendKeyMsg(cdc *wire.Codec, buildMsg func(ctx context.CoreContext, from sdk.Address, passphrase string) (sdk.Msg, error)) error {
+	ctx := context.NewCoreContextFromViper().WithDecoder(types.GetAccountDecoder(cdc))
+
+	from, err := ctx.GetFromAddress()
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := ctx.GetPassphraseFromStdin(ctx.FromAddressName)
+	if err != nil {
+		return err
+	}
+
+	msg, err := buildMsg(ctx, from, passphrase)
+	if err != nil {
+		return err
+	}
+
+	res, err := signBuildBroadcast(ctx, passphrase, msg, cdc)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Committed at block %d. Hash: %s\n", res.Height, res.Hash.String())
+	return nil
+}
+
+func DeclareCandidacyCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "declare [listing_identifier] [bond]",
+		Short: "Declare candidacy for a specific listing",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				bond, err := sdk.ParseCoin(args[1])
+				if err != nil {
+					return nil, err
+				}
+				return types.NewDeclareCandidacyMsg(from, viper.GetString(flagRegistry), args[0], bond), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+func ChallengeCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "challenge [listing_identifier] [bond]",
+		Short: "Challenge a candidate or listing, matching its bond",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				bond, err := sdk.ParseCoin(args[1])
+				if err != nil {
+					return nil, err
+				}
+				reason := viper.GetString(flagReason)
+				evidence := viper.GetString(flagEvidence)
+				return types.NewChallengeMsg(from, viper.GetString(flagRegistry), args[0], bond, reason, evidence), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagReason, "", "Why the listing should be removed")
+	cmd.Flags().String(flagEvidence, "", "Content hash or URI of evidence supporting the challenge")
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+// Commit a vote. Without a nonce, a random salt is generated and kept in the
+// key's vault, or with --derive-salt derived from the key so no file is needed.
+func CommitCmd(ballotStoreName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "commit [listing_identifier] [approve|deny] [nonce]",
+		Short: "Commit a hidden vote on a challenged listing",
+		Long: "Commit a hidden vote on a challenged listing. The salt is saved in an encrypted vault tied to the --name key so that reveal --pending can reveal it. Without a nonce a random salt is generated, or with --derive-salt one is derived from the key.",
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			derive := viper.GetBool(flagDeriveSalt)
+			if derive && len(args) == 3 {
+				return errors.Errorf("Pass either a nonce or --derive-salt")
+			}
+			vote, err := parseVote(args[1])
+			if err != nil {
+				return err
+			}
+			var bond sdk.Coin
+			if viper.GetString(flagBond) != "" {
+				bond, err = sdk.ParseCoin(viper.GetString(flagBond))
+				if err != nil {
+					return err
+				}
+			}
+			registry := viper.GetString(flagRegistry)
+
+			return sendKeyMsg(cdc, func(ctx context.CoreContext, from sdk.Address, passphrase string) (sdk.Msg, error) {
+				ballot, err := queryBallot(ctx, ballotStoreName, registry, args[0], cdc)
+				if err != nil {
+					return nil, err
+				}
+
+				var nonce []byte
+				switch {
+				case derive:
+					nonce, err = DeriveSalt(ctx.FromAddressName, passphrase, registry, ballot.PollID)
+				case len(args) == 3:
+					nonce = []byte(args[2])
+				default:
+					nonce, err = RandomSalt()
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				// Saved before broadcasting so a committed vote never lacks its salt
+				if !derive {
+					vault, err := OpenVault(ctx.FromAddressName, passphrase)
+					if err != nil {
+						return nil, err
+					}
+					vault.Add(VaultEntry{
+						Registry: registry,
+						Identifier: args[0],
+						PollID: ballot.PollID,
+						Vote: vote,
+						Nonce: nonce,
+						Bond: bond,
+					})
+					err = vault.Save()
+					if err != nil {
+						return nil, err
+					}
+				}
+				return types.NewCommitMsg(from, registry, args[0], types.VoteCommitment(vote, nonce)), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	cmd.Flags().Bool(flagDeriveSalt, false, "Derive the salt from the key instead of saving it in the vault")
+	cmd.Flags().String(flagBond, "", "Stake to reveal the vote with, saved in the vault for reveal --pending")
+	return cmd
+}
+
+func RevealCmd(ballotStoreName, commitStoreName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "reveal [listing_identifier] [approve|deny] [nonce] [bond]",
+		Short: "Reveal a committed vote, staking bond as voting power",
+		Long: "Reveal a committed vote, staking bond as voting power. With --pending, reveal every vote of the --name key on the registry whose reveal phase is open, using salts from the key's vault or derived from the key.",
+		Args: cobra.RangeArgs(0, 4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pending := viper.GetBool(flagPending)
+			if (pending && len(args) != 0) || (!pending && len(args) != 4) {
+				return errors.Errorf("Pass either a listing identifier, vote, nonce and bond or --pending")
+			}
+			if pending {
+				return revealPending(ballotStoreName, commitStoreName, cdc)
+			}
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				vote, err := parseVote(args[1])
+				if err != nil {
+					return nil, err
+				}
+				bond, err := sdk.ParseCoin(args[3])
+				if err != nil {
+					return nil, err
+				}
+				return types.NewRevealMsg(from, viper.GetString(flagRegistry), args[0], vote, []byte(args[2]), bond), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	cmd.Flags().Bool(flagPending, false, "Reveal every committed vote whose reveal phase is open")
+	cmd.Flags().String(flagBond, "", "Stake for pending votes without a bond saved in the vault")
+	return cmd
+}
+
+// Reveal the key's committed votes on the registry that are in their reveal
+// phase. Votes are found on chain, so salts derived from the key need no vault.
+func revealPending(ballotStoreName, commitStoreName string, cdc *wire.Codec) error {
+	ctx := context.NewCoreContextFromViper().WithDecoder(types.GetAccountDecoder(cdc))
+	registry := viper.GetString(flagRegistry)
+
+	var defaultBond sdk.Coin
+	if viper.GetString(flagBond) != "" {
+		bond, err := sdk.ParseCoin(viper.GetString(flagBond))
+		if err != nil {
+			return err
+		}
+		defaultBond = bond
+	}
+
+	from, err := ctx.GetFromAddress()
+	if err != nil {
+		return err
+	}
+	passphrase, err := ctx.GetPassphraseFromStdin(ctx.FromAddressName)
+	if err != nil {
+		return err
+	}
+	vault, err := OpenVault(ctx.FromAddressName, passphrase)
+	if err != nil {
+		return err
+	}
+
+	commits, err := queryCommits(ctx, commitStoreName, registry, from, cdc)
+	if err != nil {
+		return err
+	}
+	height, blockTime, err := chainNow(ctx)
+	if err != nil {
+		return err
+	}
+
+	revealed := 0
+	for _, commit := range commits {
+		voter := commit.Voter
+		ballot, err := queryBallot(ctx, ballotStoreName, registry, voter.Identifier, cdc)
+		if err != nil {
+			return err
+		}
+		// Commit left over from an earlier poll on the listing
+		if ballot.PollID != voter.PollID {
+			continue
+		}
+		if !ballot.RevealOpen(ballot.NowAt(height, blockTime)) {
+			continue
+		}
+
+		entry, ok := vault.Find(registry, voter.PollID)
+		if !ok {
+			entry.Nonce, err = DeriveSalt(ctx.FromAddressName, passphrase, registry, voter.PollID)
+			if err != nil {
+				return err
+			}
+			entry.Derived = true
+		}
+		vote, ok := openCommitment(commit.Commitment, entry)
+		if !ok {
+			fmt.Printf("Skipping %s: no saved or derived salt matches the commitment\n", voter.Identifier)
+			continue
+		}
+		bond := entry.Bond
+		if bond.Amount == 0 {
+			bond = defaultBond
+		}
+		if bond.Amount == 0 {
+			fmt.Printf("Skipping %s: no bond saved, pass --%s\n", voter.Identifier, flagBond)
+			continue
+		}
+
+		msg := types.NewRevealMsg(from, registry, voter.Identifier, vote, entry.Nonce, bond)
+		res, err := signBuildBroadcast(ctx, passphrase, msg, cdc)
+		if err != nil {
+			fmt.Printf("Reveal of %s failed: %s\n", voter.Identifier, err)
+			continue
+		}
+		fmt.Printf("Revealed %s at block %d. Hash: %s\n", voter.Identifier, res.Height, res.Hash.String())
+		revealed++
+
+		vault.Remove(registry, voter.PollID)
+		err = vault.Save()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Revealed %d of %d committed votes\n", revealed, len(commits))
+	return nil
+}
+
+// Vote to reveal a commitment with. A vault entry's own vote is used if it
+// opens the commitment. Derived salts record no vote, but a commitment binds
+// its vote, so at most one of the two opens it.
+func openCommitment(commitment []byte, entry VaultEntry) (bool, bool) {
+	if !entry.Derived {
+		return entry.Vote, bytes.Equal(types.VoteCommitment(entry.Vote, entry.Nonce), commitment)
+	}
+	for _, vote := range []bool{true, false} {
+		if bytes.Equal(types.VoteCommitment(vote, entry.Nonce), commitment) {
+			return vote, true
+		}
+	}
+	return false, false
+}
+
+// Latest block height and time. Height is that of the next block, which
+// will include any tx sent now.
+func chainNow(ctx context.CoreContext) (int64, int64, error) {
+	node, err := ctx.GetNode()
+	if err != nil {
+		return 0, 0, err
+	}
+	status, err := node.Status()
+	if err != nil {
+		return 0, 0, err
+	}
+	return status.SyncInfo.LatestBlockHeight + 1, status.SyncInfo.LatestBlockTime.Unix(), nil
+}
+
+func ApplyCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "apply [listing_identifier]",
+		Short: "Apply the result of a finished application or vote",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				return types.NewApplyMsg(from, viper.GetString(flagRegistry), args[0]), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+func ClaimRewardCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "claim [listing_identifier]",
+		Short: "Claim voting reward and refund for an applied ballot",
+		Long: fmt.Sprintf("Claim voting reward and refund for an applied ballot. With --all, claim on every applied ballot of the registry, up to %d per tx.", types.MaxClaimsPerTx),
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all := viper.GetBool(flagAll)
+			if all == (len(args) == 1) {
+				return errors.Errorf("Pass either a listing identifier or --all")
+			}
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				if all {
+					return types.NewClaimAllRewardsMsg(from, viper.GetString(flagRegistry)), nil
+				}
+				return types.NewClaimRewardMsg(from, viper.GetString(flagRegistry), args[0]), nil
+			})
+		},
+	}
+	cmd.Flags().Bool(flagAll, false, "Claim on every applied ballot with unclaimed votes")
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+func AppealCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "appeal [listing_identifier] [bond]",
+		Short: "Appeal a resolved challenge with an escalated bond",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				bond, err := sdk.ParseCoin(args[1])
+				if err != nil {
+					return nil, err
+				}
+				return types.NewAppealMsg(from, viper.GetString(flagRegistry), args[0], bond), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+// Council decision signed by the --name key alone, enough for a council of one
+func AppealDecisionCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "decide-appeal [listing_identifier] [approve|deny]",
+		Short: "Decide an appealed challenge as a council member",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				approve, err := parseVote(args[1])
+				if err != nil {
+					return nil, err
+				}
+				return types.NewAppealDecisionMsg([]sdk.Address{from}, viper.GetString(flagRegistry), args[0], approve), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+func RenewListingCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "renew [listing_identifier]",
+		Short: "Extend an expiring listing, paying the registry's renewal fee",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				return types.NewRenewListingMsg(from, viper.GetString(flagRegistry), args[0]), nil
+			})
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry of the listing")
+	return cmd
+}
+
+func DelegateVotingCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "delegate-voting [delegate_address] [power]",
+		Short: "Delegate voting power on a registry to a curator",
+		Long: "Delegate voting power on a registry to a curator. Each reveal of the curator also votes the power from your balance, unless you reveal yourself. With --revoke, remove the delegation.",
+		Args: cobra.RangeArgs(0, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			revoke := viper.GetBool(flagRevoke)
+			if (revoke && len(args) != 0) || (!revoke && len(args) != 2) {
+				return errors.Errorf("Pass either a delegate address and power or --revoke")
+			}
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				if revoke {
+					return types.NewDelegateVotingMsg(from, viper.GetString(flagRegistry), nil, sdk.Coin{}), nil
+				}
+				delegate, err := sdk.GetAddress(args[0])
+				if err != nil {
+					return nil, err
+				}
+				power, err := sdk.ParseCoin(args[1])
+				if err != nil {
+					return nil, err
+				}
+				return types.NewDelegateVotingMsg(from, viper.GetString(flagRegistry), delegate, power), nil
+			})
+		},
+	}
+	cmd.Flags().Bool(flagRevoke, false, "Revoke the delegation")
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry to delegate on")
+	return cmd
+}
+
+func CreateRegistryCmd(cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "create-registry [registry_id] [params_file]",
+		Short: "Create a registry with the parameters in a JSON file",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendMsg(cdc, func(from sdk.Address) (sdk.Msg, error) {
+				bz, err := ioutil.ReadFile(args[1])
+				if err != nil {
+					return nil, err
+				}
+				params := types.RegistryParams{}
+				err = cdc.UnmarshalJSON(bz, &params)
+				if err != nil {
+					return nil, err
+				}
+				return types.NewCreateRegistryMsg(from, args[0], params), nil
+			})
+		},
+	}
+	return cmd
+}
+
+func parseVote(vote string) (bool, error) {
+	switch vote {
+	case "approve":
+		return true, nil
+	case "deny":
+		return false, nil
+	}
+	return false, errors.Errorf("Vote must be approve or deny, got %s", vote)
+}
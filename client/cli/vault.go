@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"github.com/cosmos/cosmos-sdk/client/keys"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/tmlibs/cli"
+)
+
+// Salt of a committed vote, kept until the vote is revealed. Bond is the
+// stake to reveal with, if it was given at commit time.
+type VaultEntry struct {
+	Registry string
+	Identifier string
+	PollID string
+	Vote bool
+	Nonce []byte
+	Bond sdk.Coin
+
+	// Set on entries made up for a salt derived from the key, which never
+	// reach the vault and do not know the vote
+	Derived bool `json:"-"`
+}
+
+// Salts of a key's committed votes, stored in a local file encrypted with a
+// secret only the key can derive. Losing the file only loses salts that were
+// not derived from the key.
+type Vault struct {
+	Entries []VaultEntry
+
+	path string
+	secret []byte
+}
+
+// Message signed by the key to derive the vault secret. Keybase signatures
+// are deterministic, so the same key and passphrase always open the vault.
+var vaultSecretMsg = []byte("token_curated_registry/vault")
+
+func vaultPath(name string) string {
+	return filepath.Join(viper.GetString(cli.HomeFlag), "vault", name+".vault")
+}
+
+// Hash of the key's signature over msg
+func keySecret(name, passphrase string, msg []byte) ([]byte, error) {
+	keybase, err := keys.GetKeyBase()
+	if err != nil {
+		return nil, err
+	}
+	sig, _, err := keybase.Sign(name, passphrase, msg)
+	if err != nil {
+		return nil, err
+	}
+	secret := sha256.Sum256(sig.Bytes())
+	return secret[:], nil
+}
+
+// Salt derived from the key for a vote on one poll, so the vote can be
+// revealed without a vault
+func DeriveSalt(name, passphrase, registry, pollID string) ([]byte, error) {
+	secret, err := keySecret(name, passphrase, []byte("token_curated_registry/salt/" + registry + "/" + pollID))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(secret[:16])), nil
+}
+
+// Random salt for a vote stored in the vault
+func RandomSalt() ([]byte, error) {
+	bz := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, bz); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(bz)), nil
+}
+
+// Open the vault of the named key. A key without a vault file gets an empty one.
+func OpenVault(name, passphrase string) (*Vault, error) {
+	secret, err := keySecret(name, passphrase, vaultSecretMsg)
+	if err != nil {
+		return nil, err
+	}
+	return openVaultFile(vaultPath(name), secret)
+}
+
+func openVaultFile(path string, secret []byte) (*Vault, error) {
+	vault := &Vault{
+		path: path,
+		secret: secret,
+	}
+	bz, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return vault, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openSealed(secret, bz)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decrypt vault, wrong key or passphrase")
+	}
+	err = json.Unmarshal(plaintext, &vault.Entries)
+	if err != nil {
+		return nil, err
+	}
+	return vault, nil
+}
+
+// Write the vault back, readable by the owner only
+func (vault *Vault) Save() error {
+	plaintext, err := json.Marshal(vault.Entries)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(vault.secret, plaintext)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(vault.path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(vault.path, sealed, 0600)
+}
+
+// Add the salt of a vote, replacing any earlier entry for the same poll
+func (vault *Vault) Add(entry VaultEntry) {
+	vault.Remove(entry.Registry, entry.PollID)
+	vault.Entries = append(vault.Entries, entry)
+}
+
+func (vault *Vault) Find(registry, pollID string) (VaultEntry, bool) {
+	for _, entry := range vault.Entries {
+		if entry.Registry == registry && entry.PollID == pollID {
+			return entry, true
+		}
+	}
+	return VaultEntry{}, false
+}
+
+func (vault *Vault) Remove(registry, pollID string) {
+	entries := []VaultEntry{}
+	for _, entry := range vault.Entries {
+		if entry.Registry != registry || entry.PollID != pollID {
+			entries = append(entries, entry)
+		}
+	}
+	vault.Entries = entries
+}
+
+// AES-GCM with a random nonce ahead of the ciphertext
+func seal(secret, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openSealed(secret, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.Errorf("Vault file is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+func TestVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vault", "alice.vault")
+	secret := sha256.Sum256([]byte("secret"))
+
+	// Missing file opens empty
+	vault, err := openVaultFile(path, secret[:])
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(vault.Entries))
+
+	vault.Add(VaultEntry{Registry: "default", Identifier: "listing", PollID: "listing/1", Vote: true, Nonce: []byte("nonce")})
+	vault.Add(VaultEntry{Registry: "news", Identifier: "listing", PollID: "listing/1", Nonce: []byte("other")})
+	// Same poll replaces the earlier salt
+	vault.Add(VaultEntry{Registry: "default", Identifier: "listing", PollID: "listing/1", Vote: true, Nonce: []byte("new nonce")})
+	require.NoError(t, vault.Save())
+
+	bz, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(bz), "new nonce", "Vault not encrypted")
+
+	reopened, err := openVaultFile(path, secret[:])
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(reopened.Entries))
+	entry, ok := reopened.Find("default", "listing/1")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("new nonce"), entry.Nonce)
+
+	reopened.Remove("default", "listing/1")
+	_, ok = reopened.Find("default", "listing/1")
+	assert.False(t, ok, "Entry not removed")
+
+	wrong := sha256.Sum256([]byte("wrong"))
+	_, err = openVaultFile(path, wrong[:])
+	assert.NotNil(t, err, "Vault opened with wrong secret")
+}
+
+func TestOpenCommitment(t *testing.T) {
+	deny := VaultEntry{Vote: false, Nonce: []byte("salt")}
+	vote, ok := openCommitment(types.VoteCommitment(false, []byte("salt")), deny)
+	assert.True(t, ok)
+	assert.False(t, vote, "Deny commitment opened as approve")
+
+	_, ok = openCommitment(types.VoteCommitment(true, []byte("salt")), deny)
+	assert.False(t, ok, "Opened approve commitment with the vault's deny vote")
+
+	derived := VaultEntry{Nonce: []byte("salt"), Derived: true}
+	vote, ok = openCommitment(types.VoteCommitment(false, []byte("salt")), derived)
+	assert.True(t, ok)
+	assert.False(t, vote, "Derived salt opened deny commitment as approve")
+
+	derived.Nonce = []byte("other salt")
+	_, ok = openCommitment(types.VoteCommitment(true, []byte("salt")), derived)
+	assert.False(t, ok, "Matched commitment with wrong salt")
+}
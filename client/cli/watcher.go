@@ -0,0 +1,390 @@
+package cli
+
+import (
+	"bytes"
+	gocontext "context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/wire"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"github.com/tendermint/tmlibs/log"
+
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+const flagWarnWithin = "warn-within"
+
+// Registry state the watcher reads and the txs it sends. Implemented over
+// RPC by nodeChain, and in process by tests.
+type Chain interface {
+	// Height and time a tx sent now is included at
+	Now() (int64, int64, error)
+	Ballots(registry string) ([]types.Ballot, error)
+	Commits(registry string, owner sdk.Address) ([]committedVote, error)
+	// Identifiers of ballots owner revealed on and has not claimed
+	PendingClaims(registry string, owner sdk.Address) ([]string, error)
+	Send(msg sdk.Msg) error
+}
+
+// Salt, vote and stake of a committed vote, false if the salt is unknown.
+// Entries marked Derived only know the salt.
+type SaltFunc func(registry string, pollID string) (VaultEntry, bool)
+
+// Drop what was kept to reveal a vote, once it is revealed
+type ForgetFunc func(registry string, pollID string) error
+
+// Acts for one key on one registry as blocks come in: reveals its committed
+// votes, applies ballots it is party to or voted on once they can be applied,
+// claims its rewards, and warns when a deadline it still has to act on is near.
+type Watcher struct {
+	Chain Chain
+	Cdc *wire.Codec
+	Owner sdk.Address
+	Registry string
+	Salt SaltFunc
+	// Called after each successful reveal. Optional
+	Forget ForgetFunc
+	// Blocks, or seconds for time deadlines, before a deadline to warn at
+	WarnWithin int64
+	Logger log.Logger
+
+	// Warnings already logged, by poll and kind
+	warned map[string]bool
+}
+
+func NewWatcher(chain Chain, cdc *wire.Codec, owner sdk.Address, registry string, salt SaltFunc, warnWithin int64, logger log.Logger) *Watcher {
+	return &Watcher{
+		Chain: chain,
+		Cdc: cdc,
+		Owner: owner,
+		Registry: registry,
+		Salt: salt,
+		WarnWithin: warnWithin,
+		Logger: logger,
+		warned: make(map[string]bool),
+	}
+}
+
+// Forget the salts of votes once the watcher revealed them
+func (w *Watcher) WithForget(forget ForgetFunc) *Watcher {
+	w.Forget = forget
+	return w
+}
+
+// Step once for every value received on blocks until it is closed. Failed
+// steps are logged and retried on the next block.
+func (w *Watcher) Run(blocks <-chan interface{}) {
+	for range blocks {
+		if err := w.Step(); err != nil {
+			w.Logger.Error("Watcher step failed", "err", err)
+		}
+	}
+}
+
+// Act on the current state of the registry. Txs that fail are logged, only
+// failed queries abort the step.
+func (w *Watcher) Step() error {
+	height, blockTime, err := w.Chain.Now()
+	if err != nil {
+		return err
+	}
+	ballots, err := w.Chain.Ballots(w.Registry)
+	if err != nil {
+		return err
+	}
+	byIdentifier := make(map[string]types.Ballot)
+	for _, ballot := range ballots {
+		byIdentifier[ballot.Identifier] = ballot
+	}
+	now := func(ballot types.Ballot) int64 {
+		return ballot.NowAt(height, blockTime)
+	}
+
+	commits, err := w.Chain.Commits(w.Registry, w.Owner)
+	if err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		ballot, ok := byIdentifier[commit.Voter.Identifier]
+		if !ok || ballot.PollID != commit.Voter.PollID {
+			continue
+		}
+		w.reveal(commit, ballot, now(ballot))
+	}
+
+	pending, err := w.Chain.PendingClaims(w.Registry, w.Owner)
+	if err != nil {
+		return err
+	}
+	voted := make(map[string]bool)
+	for _, identifier := range pending {
+		voted[identifier] = true
+	}
+
+	claimable := false
+	for _, ballot := range ballots {
+		party := bytes.Equal(ballot.Owner, w.Owner) || bytes.Equal(ballot.Challenger, w.Owner)
+		if !party && !voted[ballot.Identifier] {
+			continue
+		}
+		if voted[ballot.Identifier] && !ballot.Challenged() {
+			claimable = true
+		}
+		if party {
+			w.warnAppeal(ballot, now(ballot))
+		}
+		if w.applicable(ballot, now(ballot), party) {
+			w.send(types.NewApplyMsg(w.Owner, w.Registry, ballot.Identifier), "Applied", ballot.Identifier)
+		}
+	}
+
+	if claimable {
+		w.send(types.NewClaimAllRewardsMsg(w.Owner, w.Registry), "Claimed rewards", w.Registry)
+	}
+	return nil
+}
+
+// Reveal a committed vote once its reveal phase opens. Votes that cannot be
+// revealed automatically get a warning as the reveal deadline nears.
+func (w *Watcher) reveal(commit committedVote, ballot types.Ballot, now int64) {
+	if !ballot.RevealOpen(now) {
+		return
+	}
+	identifier := commit.Voter.Identifier
+
+	entry, ok := w.Salt(w.Registry, commit.Voter.PollID)
+	if !ok {
+		w.warn(ballot, "salt", now, ballot.EndRevealBlockStamp, "No salt for committed vote, reveal it manually")
+		return
+	}
+	vote, ok := openCommitment(commit.Commitment, entry)
+	if !ok {
+		w.warn(ballot, "salt", now, ballot.EndRevealBlockStamp, "Salt does not match committed vote, reveal it manually")
+		return
+	}
+	if entry.Bond.Amount == 0 {
+		w.warn(ballot, "bond", now, ballot.EndRevealBlockStamp, "No bond for committed vote, reveal it manually")
+		return
+	}
+	if !w.send(types.NewRevealMsg(w.Owner, w.Registry, identifier, vote, entry.Nonce, entry.Bond), "Revealed", identifier) {
+		return
+	}
+	if w.Forget != nil {
+		if err := w.Forget(w.Registry, commit.Voter.PollID); err != nil {
+			w.Logger.Error("Forgetting revealed vote failed", "subject", identifier, "err", err)
+		}
+	}
+}
+
+// Whether an ApplyMsg on the ballot would go through now. Unchallenged
+// candidates are only applied by their owner.
+func (w *Watcher) applicable(ballot types.Ballot, now int64, party bool) bool {
+	switch ballot.StatusAt(now) {
+	case types.StatusApplying:
+		return party && now >= ballot.EndApplyBlockStamp
+	case types.StatusReveal:
+		return now >= ballot.EndRevealBlockStamp
+	case types.StatusAccepted, types.StatusRejected:
+		return !ballot.Settled && now >= ballot.EndAppealBlockStamp
+	}
+	return false
+}
+
+// Warn the losing party of a resolved challenge before its appeal window closes
+func (w *Watcher) warnAppeal(ballot types.Ballot, now int64) {
+	if !ballot.Appealable(now) {
+		return
+	}
+	if bytes.Equal(ballot.Owner, w.Owner) == ballot.Accepted() {
+		return
+	}
+	w.warn(ballot, "appeal", now, ballot.EndAppealBlockStamp, "Challenge resolved against you, appeal window closing")
+}
+
+// Log msg once per poll and kind when deadline is within WarnWithin
+func (w *Watcher) warn(ballot types.Ballot, kind string, now int64, deadline int64, msg string) {
+	if deadline - now > w.WarnWithin {
+		return
+	}
+	key := ballot.PollID + "/" + kind
+	if w.warned[key] {
+		return
+	}
+	w.warned[key] = true
+	w.Logger.Error("Deadline near: " + msg, "listing", ballot.Identifier, "poll", ballot.PollID, "deadline", deadline, "now", now)
+}
+
+// Send msg and log the outcome. Returns whether it went through
+func (w *Watcher) send(msg sdk.Msg, action string, subject string) bool {
+	if err := w.Chain.Send(msg); err != nil {
+		w.Logger.Error(action+" failed", "subject", subject, "err", err)
+		return false
+	}
+	w.Logger.Info(action, "subject", subject)
+	return true
+}
+
+// ===================================================================================================================================
+
+// Chain backed by a node, signing with the --name key
+type nodeChain struct {
+	ctx context.CoreContext
+	cdc *wire.Codec
+	passphrase string
+	ballotStoreName string
+	commitStoreName string
+	revealStoreName string
+}
+
+func (chain nodeChain) Now() (int64, int64, error) {
+	return chainNow(chain.ctx)
+}
+
+func (chain nodeChain) Ballots(registry string) ([]types.Ballot, error) {
+	resKVs, err := chain.ctx.QuerySubspace(chain.cdc, types.RegistryPrefix(registry), chain.ballotStoreName)
+	if err != nil {
+		return nil, err
+	}
+	ballots := []types.Ballot{}
+	for _, kv := range resKVs {
+		ballot := types.Ballot{}
+		err = chain.cdc.UnmarshalBinary(kv.Value, &ballot)
+		if err != nil {
+			return nil, err
+		}
+		ballots = append(ballots, ballot)
+	}
+	return ballots, nil
+}
+
+func (chain nodeChain) Commits(registry string, owner sdk.Address) ([]committedVote, error) {
+	return queryCommits(chain.ctx, chain.commitStoreName, registry, owner, chain.cdc)
+}
+
+func (chain nodeChain) PendingClaims(registry string, owner sdk.Address) ([]string, error) {
+	prefix := append(append(types.RegistryPrefix(registry), types.PendingClaimPrefix...), owner...)
+	resKVs, err := chain.ctx.QuerySubspace(chain.cdc, prefix, chain.revealStoreName)
+	if err != nil {
+		return nil, err
+	}
+	identifiers := []string{}
+	for _, kv := range resKVs {
+		identifiers = append(identifiers, string(kv.Key[len(prefix):]))
+	}
+	return identifiers, nil
+}
+
+func (chain nodeChain) Send(msg sdk.Msg) error {
+	_, err := signBuildBroadcast(chain.ctx, chain.passphrase, msg, chain.cdc)
+	return err
+}
+
+// Run a watcher for the --name key until interrupted
+func WatcherCmd(ballotStoreName, commitStoreName, revealStoreName string, cdc *wire.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "watcher",
+		Short: "Reveal, apply and claim automatically as blocks come in",
+		Long: "Watch new blocks and act for the --name key on a registry: reveal committed votes once their reveal phase opens, using salts from the key's vault or derived from the key, apply ballots the key is party to or voted on, and claim rewards. Warns when a deadline the key still has to act on is near.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.NewCoreContextFromViper().WithDecoder(types.GetAccountDecoder(cdc))
+			registry := viper.GetString(flagRegistry)
+
+			var defaultBond sdk.Coin
+			if viper.GetString(flagBond) != "" {
+				bond, err := sdk.ParseCoin(viper.GetString(flagBond))
+				if err != nil {
+					return err
+				}
+				defaultBond = bond
+			}
+
+			from, err := ctx.GetFromAddress()
+			if err != nil {
+				return err
+			}
+			passphrase, err := ctx.GetPassphraseFromStdin(ctx.FromAddressName)
+			if err != nil {
+				return err
+			}
+			vault, err := OpenVault(ctx.FromAddressName, passphrase)
+			if err != nil {
+				return err
+			}
+
+			// Vault is reopened for each lookup so salts of later commits are found
+			salt := func(registry string, pollID string) (VaultEntry, bool) {
+				if reopened, err := OpenVault(ctx.FromAddressName, passphrase); err == nil {
+					vault = reopened
+				}
+				if entry, ok := vault.Find(registry, pollID); ok {
+					if entry.Bond.Amount == 0 {
+						entry.Bond = defaultBond
+					}
+					return entry, true
+				}
+				nonce, err := DeriveSalt(ctx.FromAddressName, passphrase, registry, pollID)
+				if err != nil {
+					return VaultEntry{}, false
+				}
+				return VaultEntry{
+					Registry: registry,
+					PollID: pollID,
+					Nonce: nonce,
+					Bond: defaultBond,
+					Derived: true,
+				}, true
+			}
+
+			chain := nodeChain{
+				ctx: ctx,
+				cdc: cdc,
+				passphrase: passphrase,
+				ballotStoreName: ballotStoreName,
+				commitStoreName: commitStoreName,
+				revealStoreName: revealStoreName,
+			}
+			logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout)).With("module", "watcher")
+			// Salts of revealed votes are not needed any more, as after reveal --pending
+			forget := func(registry string, pollID string) error {
+				reopened, err := OpenVault(ctx.FromAddressName, passphrase)
+				if err != nil {
+					return err
+				}
+				vault = reopened
+				vault.Remove(registry, pollID)
+				return vault.Save()
+			}
+			watcher := NewWatcher(chain, cdc, from, registry, salt, viper.GetInt64(flagWarnWithin), logger).WithForget(forget)
+
+			node, err := ctx.GetNode()
+			if err != nil {
+				return err
+			}
+			err = node.Start()
+			if err != nil {
+				return err
+			}
+			defer node.Stop()
+
+			blocks := make(chan interface{}, 1)
+			err = node.Subscribe(gocontext.Background(), "tcrcli-watcher", tmtypes.EventQueryNewBlock, blocks)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Watching registry %s for %s\n", registry, from)
+			watcher.Run(blocks)
+			return nil
+		},
+	}
+	cmd.Flags().String(flagRegistry, types.DefaultRegistryID, "Registry to watch")
+	cmd.Flags().String(flagBond, "", "Stake for votes without a bond saved in the vault")
+	cmd.Flags().Int64(flagWarnWithin, 5, "Warn this many blocks, or seconds for time deadlines, before a deadline")
+	return cmd
+}
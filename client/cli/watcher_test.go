@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/wire"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/bank"
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/tmlibs/log"
+
+	handle "github.com/AdityaSripal/token_curated_registry/auth"
+	"github.com/AdityaSripal/token_curated_registry/db"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/AdityaSripal/token_curated_registry/utils"
+)
+
+// In-process stand-in for RegistryApp: delivers msgs to the registry
+// handlers on one block context that tests move forward
+type standIn struct {
+	ctx sdk.Context
+	cdc *wire.Codec
+	mapper db.BallotMapper
+	accountKeeper bank.Keeper
+	handlers map[string]sdk.Handler
+}
+
+func newStandIn() *standIn {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, _ := db.SetupMultiStore()
+	cdc := db.MakeCodec()
+	accountKeeper := bank.NewKeeper(auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{}))
+	mapper := db.NewBallotMapper(db.BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc).ForRegistry(types.DefaultRegistryID).WithBank(accountKeeper)
+	ratio := types.NewRatio(1, 2)
+
+	return &standIn{
+		ctx: sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger()),
+		cdc: cdc,
+		mapper: mapper,
+		accountKeeper: accountKeeper,
+		handlers: map[string]sdk.Handler{
+			"DeclareCandidacy": handle.NewCandidacyHandler(mapper, 100, 10, types.FeeParams{}),
+			"Challenge": handle.NewChallengeHandler(mapper, 10, 10, 100),
+			"Commit": handle.NewCommitHandler(mapper),
+			"Reveal": handle.NewRevealHandler(mapper),
+			"Apply": handle.NewApplyHandler(mapper, ratio, ratio, types.AppealParams{}),
+			"ClaimAllRewards": handle.NewClaimAllRewardsHandler(mapper),
+		},
+	}
+}
+
+func (chain *standIn) advance(height int64) {
+	chain.ctx = chain.ctx.WithBlockHeight(height)
+}
+
+func (chain *standIn) Now() (int64, int64, error) {
+	return chain.ctx.BlockHeight(), chain.ctx.BlockHeader().Time, nil
+}
+
+func (chain *standIn) Ballots(registry string) ([]types.Ballot, error) {
+	iter := sdk.KVStorePrefixIterator(chain.ctx.KVStore(chain.mapper.BallotKey), types.RegistryPrefix(registry))
+	defer iter.Close()
+
+	ballots := []types.Ballot{}
+	for ; iter.Valid(); iter.Next() {
+		ballot := types.Ballot{}
+		err := chain.cdc.UnmarshalBinary(iter.Value(), &ballot)
+		if err != nil {
+			return nil, err
+		}
+		ballots = append(ballots, ballot)
+	}
+	return ballots, nil
+}
+
+func (chain *standIn) Commits(registry string, owner sdk.Address) ([]committedVote, error) {
+	prefix := types.CommitPrefix(registry, owner)
+	iter := sdk.KVStorePrefixIterator(chain.ctx.KVStore(chain.mapper.CommitKey), prefix)
+	defer iter.Close()
+
+	commits := []committedVote{}
+	for ; iter.Valid(); iter.Next() {
+		voter := types.Voter{}
+		err := chain.cdc.UnmarshalBinary(iter.Key()[len(prefix):], &voter)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, committedVote{
+			Voter: voter,
+			Commitment: iter.Value(),
+		})
+	}
+	return commits, nil
+}
+
+func (chain *standIn) PendingClaims(registry string, owner sdk.Address) ([]string, error) {
+	return chain.mapper.ForRegistry(registry).PendingClaims(chain.ctx, owner), nil
+}
+
+func (chain *standIn) Send(msg sdk.Msg) error {
+	res := chain.handlers[msg.Type()](chain.ctx, msg)
+	if !res.IsOK() {
+		return errors.New(res.Log)
+	}
+	return nil
+}
+
+func TestWatcher(t *testing.T) {
+	chain := newStandIn()
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+	for _, addr := range []sdk.Address{owner, challenger, voter} {
+		chain.accountKeeper.AddCoins(chain.ctx, addr, chain.mapper.Coins(1000))
+	}
+	bond := sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 100,
+	}
+
+	require.Nil(t, chain.Send(types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Watched listing", bond)))
+	require.Nil(t, chain.Send(types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Forgotten listing", bond)))
+	require.Nil(t, chain.Send(types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Watched listing", bond, "", "")))
+	require.Nil(t, chain.Send(types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Forgotten listing", bond, "", "")))
+
+	// Salt of the first vote is known, the second one's is lost
+	watchedPoll := chain.mapper.GetBallot(chain.ctx, "Watched listing").PollID
+	require.Nil(t, chain.Send(types.NewCommitMsg(voter, types.DefaultRegistryID, "Watched listing", types.VoteCommitment(true, []byte("salt")))))
+	require.Nil(t, chain.Send(types.NewCommitMsg(voter, types.DefaultRegistryID, "Forgotten listing", types.VoteCommitment(true, []byte("lost")))))
+	salt := func(registry string, pollID string) (VaultEntry, bool) {
+		if registry == types.DefaultRegistryID && pollID == watchedPoll {
+			return VaultEntry{Vote: true, Nonce: []byte("salt"), Bond: bond}, true
+		}
+		return VaultEntry{}, false
+	}
+
+	forgotten := []string{}
+	forget := func(registry string, pollID string) error {
+		forgotten = append(forgotten, pollID)
+		return nil
+	}
+
+	logs := &bytes.Buffer{}
+	watcher := NewWatcher(chain, chain.cdc, voter, types.DefaultRegistryID, salt, 5, log.NewTMLogger(logs)).WithForget(forget)
+
+	// Nothing to do in the commit phase
+	chain.advance(5)
+	require.Nil(t, watcher.Step())
+	assert.Equal(t, int64(0), chain.mapper.GetBallot(chain.ctx, "Watched listing").Approve, "Revealed during commit phase")
+
+	// Reveal phase opens, deadline of the forgotten vote is not near yet
+	chain.advance(11)
+	require.Nil(t, watcher.Step())
+	assert.Equal(t, int64(100), chain.mapper.GetBallot(chain.ctx, "Watched listing").Approve, "Vote not revealed")
+	assert.NotContains(t, logs.String(), "Deadline near", "Warned too early")
+	assert.Equal(t, []string{watchedPoll}, forgotten, "Salt of revealed vote kept")
+
+	chain.advance(16)
+	require.Nil(t, watcher.Step())
+	assert.Contains(t, logs.String(), "Deadline near: No salt for committed vote", "No warning for unrevealable vote")
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "Deadline near") {
+			assert.True(t, strings.HasPrefix(line, "E["), "Warning not logged as an error")
+		}
+	}
+
+	// Warned once per poll
+	warnings := bytes.Count(logs.Bytes(), []byte("Deadline near"))
+	require.Nil(t, watcher.Step())
+	assert.Equal(t, warnings, bytes.Count(logs.Bytes(), []byte("Deadline near")), "Warned twice")
+
+	// Reveal phase over, the watcher applies the ballot it voted on
+	chain.advance(21)
+	require.Nil(t, watcher.Step())
+	ballot := chain.mapper.GetBallot(chain.ctx, "Watched listing")
+	assert.Equal(t, types.StatusAccepted, ballot.Status, "Ballot not applied")
+	assert.Equal(t, "Watched listing", chain.mapper.GetListing(chain.ctx, "Watched listing").Identifier, "Listing not accepted")
+
+	// Then claims the stake and whole pool of 50 on the next block
+	chain.advance(22)
+	require.Nil(t, watcher.Step())
+	assert.Equal(t, int64(1050), chain.accountKeeper.GetCoins(chain.ctx, voter).AmountOf(types.DefaultDenom), "Reward not claimed")
+	assert.Equal(t, 0, len(chain.mapper.PendingClaims(chain.ctx, voter)), "Claim still pending")
+}
+
+func TestWatcherRevealsDeny(t *testing.T) {
+	chain := newStandIn()
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+	for _, addr := range []sdk.Address{owner, challenger, voter} {
+		chain.accountKeeper.AddCoins(chain.ctx, addr, chain.mapper.Coins(1000))
+	}
+	bond := sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 100,
+	}
+
+	require.Nil(t, chain.Send(types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Denied listing", bond)))
+	require.Nil(t, chain.Send(types.NewChallengeMsg(challenger, types.DefaultRegistryID, "Denied listing", bond, "", "")))
+	require.Nil(t, chain.Send(types.NewCommitMsg(voter, types.DefaultRegistryID, "Denied listing", types.VoteCommitment(false, []byte("salt")))))
+	salt := func(registry string, pollID string) (VaultEntry, bool) {
+		return VaultEntry{Vote: false, Nonce: []byte("salt"), Bond: bond}, true
+	}
+	watcher := NewWatcher(chain, chain.cdc, voter, types.DefaultRegistryID, salt, 5, log.NewNopLogger())
+
+	chain.advance(11)
+	require.Nil(t, watcher.Step())
+	ballot := chain.mapper.GetBallot(chain.ctx, "Denied listing")
+	assert.Equal(t, int64(0), ballot.Approve, "Deny vote revealed as approve")
+	assert.Equal(t, int64(100), ballot.Deny, "Deny vote not revealed")
+}
+
+func TestWatcherAppliesOwnCandidacy(t *testing.T) {
+	chain := newStandIn()
+	owner := utils.GenerateAddress()
+	chain.accountKeeper.AddCoins(chain.ctx, owner, chain.mapper.Coins(1000))
+	require.Nil(t, chain.Send(types.NewDeclareCandidacyMsg(owner, types.DefaultRegistryID, "Own listing", sdk.Coin{
+		Denom: types.DefaultDenom,
+		Amount: 100,
+	})))
+
+	noSalt := func(registry string, pollID string) (VaultEntry, bool) {
+		return VaultEntry{}, false
+	}
+	watcher := NewWatcher(chain, chain.cdc, owner, types.DefaultRegistryID, noSalt, 5, log.NewNopLogger())
+
+	chain.advance(9)
+	require.Nil(t, watcher.Step())
+	assert.Equal(t, "", chain.mapper.GetListing(chain.ctx, "Own listing").Identifier, "Applied during application phase")
+
+	chain.advance(10)
+	require.Nil(t, watcher.Step())
+	assert.Equal(t, "Own listing", chain.mapper.GetListing(chain.ctx, "Own listing").Identifier, "Candidacy not applied")
+}
@@ -14,13 +14,13 @@ import (
 	"github.com/cosmos/cosmos-sdk/client/tx"
 
 	"github.com/cosmos/cosmos-sdk/version"
-	"github.com/cosmos/cosmos-sdk/wire"
 	authcmd "github.com/cosmos/cosmos-sdk/x/auth/client/cli"
 	bankcmd "github.com/cosmos/cosmos-sdk/x/bank/client/cli"
 	ibccmd "github.com/cosmos/cosmos-sdk/x/ibc/client/cli"
 	stakecmd "github.com/cosmos/cosmos-sdk/x/stake/client/cli"
 
 	"github.com/AdityaSripal/token_curated_registry/app"
+	registrycmd "github.com/AdityaSripal/token_curated_registry/client/cli"
 	"github.com/AdityaSripal/token_curated_registry/types"
 )
 
@@ -53,8 +53,32 @@ func main() {
 	rootCmd.AddCommand(
 		client.GetCommands(
 			authcmd.GetAccountCmd("acc", cdc, types.GetAccountDecoder(cdc)),
+			registrycmd.GetBallotCmd("ballots", cdc),
+			registrycmd.GetListingCmd("listings", cdc),
+			registrycmd.GetArchivedListingCmd("archive", cdc),
+			registrycmd.GetHistoryCmd("history", cdc),
+			registrycmd.GetTopListingsCmd(cdc),
+			registrycmd.GetRegistryCmd("registries", cdc),
+			registrycmd.GetVoteCmd("ballots", "reveals", cdc),
 		)...)
 
+	rootCmd.AddCommand(
+		client.PostCommands(
+			registrycmd.CreateRegistryCmd(cdc),
+			registrycmd.DeclareCandidacyCmd(cdc),
+			registrycmd.ChallengeCmd(cdc),
+			registrycmd.CommitCmd("ballots", cdc),
+			registrycmd.RevealCmd("ballots", "commits", cdc),
+			registrycmd.ApplyCmd(cdc),
+			registrycmd.ClaimRewardCmd(cdc),
+			registrycmd.AppealCmd(cdc),
+			registrycmd.AppealDecisionCmd(cdc),
+			registrycmd.DelegateVotingCmd(cdc),
+			registrycmd.RenewListingCmd(cdc),
+		)...)
+	rootCmd.AddCommand(registrycmd.ExplainCodeCmd())
+	rootCmd.AddCommand(registrycmd.WatcherCmd("ballots", "commits", "reveals", cdc))
+
 	rootCmd.AddCommand(
 		client.PostCommands(
 			bankcmd.SendTxCmd(cdc),
@@ -79,14 +103,3 @@ func main() {
 	executor := cli.PrepareMainCmd(rootCmd, "BC", os.ExpandEnv("$HOME/.basecli"))
 	executor.Execute()
 }
-
-func DeclareCandidacyCmd(cdc *wire.Codec) *cobra.Command {
-	cmd := &cobra.Command{
-		Use: "declare [listing_identifier] [bond]",
-		Short: "Declare candidacy for a specific listing",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return nil
-		},
-	}
-	return cmd
-}
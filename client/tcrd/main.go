@@ -5,16 +5,23 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/tmlibs/cli"
+	cmn "github.com/tendermint/tmlibs/common"
 	dbm "github.com/tendermint/tmlibs/db"
 	"github.com/tendermint/tmlibs/log"
 
 	"github.com/AdityaSripal/token_curated_registry/app"
+	"github.com/AdityaSripal/token_curated_registry/types"
 	"github.com/cosmos/cosmos-sdk/server"
 )
 
+// Block that migrates a store written in an older schema. Zero migrates in
+// the first block after start. Any other height has to be the next block.
+const flagUpgradeHeight = "upgrade-height"
+
 func main() {
 	cdc := app.MakeCodec()
 	ctx := server.NewDefaultContext()
@@ -30,16 +37,43 @@ func main() {
 		server.ConstructAppExporter(exportAppState, "basecoin"))
 
 	// prepare and add flags
+	rootCmd.PersistentFlags().Int64(flagUpgradeHeight, 0, "Height of the block that migrates the store to the current schema")
 	rootDir := os.ExpandEnv("$HOME/.tcrd")
 	executor := cli.PrepareBaseCmd(rootCmd, "TCR", rootDir)
 	executor.Execute()
 }
 
+// Params of the default registry when genesis defines none.
+// Resolved challenges can be appealed for 10 blocks with double the bond,
+// triggering a vote twice as long that needs two thirds approval
+var defaultParams = types.RegistryParams{
+	Denom: types.DefaultDenom,
+	MinDeposit: 100,
+	ApplyLen: 10,
+	CommitLen: 10,
+	RevealLen: 10,
+	DispensationPct: types.NewRatio(1, 2),
+	Quorum: types.NewRatio(1, 2),
+	Deadlines: types.BlockDeadlines,
+	Appeal: types.AppealParams{
+		AppealLen: 10,
+		BondFactor: 2,
+		CommitLen: 20,
+		RevealLen: 20,
+		Quorum: types.NewRatio(66, 100),
+	},
+}
+
 func newApp(logger log.Logger, db dbm.DB) abci.Application {
-	return app.NewRegistryApp(logger, db, 100, 10, 10, 10, 0.5, 0.5)
+	rapp := app.NewRegistryApp(logger, db, defaultParams)
+	err := rapp.SetUpgradeHeight(viper.GetInt64(flagUpgradeHeight))
+	if err != nil {
+		cmn.Exit(err.Error())
+	}
+	return rapp
 }
 
 func exportAppState(logger log.Logger, db dbm.DB) (json.RawMessage, error) {
-	rapp := app.NewRegistryApp(logger, db, 100, 10, 10, 10, 0.5, 0.5)
+	rapp := app.NewRegistryApp(logger, db, defaultParams)
 	return rapp.ExportAppStateJSON()
 }
\ No newline at end of file
@@ -0,0 +1,92 @@
+package db
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+// Typed access to the state of one registry. Handlers only see these
+// interfaces, so they can be tested against in-memory fakes and the store
+// layout behind BallotMapper can change without touching them.
+type Keeper interface {
+	BallotStore
+	CommitStore
+	RevealStore
+	ListingStore
+	HistoryStore
+	Escrow
+
+	// Current point in time in the unit of the registry's deadlines
+	Now(ctx sdk.Context) int64
+	// ID of the registry the keeper is scoped to
+	RegistryID() string
+	// Denom every bond and payout of the registry is in
+	StakingDenom() string
+	// Lifetime given to new and renewed listings. Zero never expires
+	ListingLifetime() int64
+}
+
+// Ballots by identifier. GetBallot returns the zero Ballot for unknown identifiers.
+// A ballot whose poll is replaced by a new challenge or candidacy is archived
+// by poll ID, so its voters can still claim.
+type BallotStore interface {
+	GetBallot(ctx sdk.Context, identifier string) types.Ballot
+	SetBallot(ctx sdk.Context, ballot types.Ballot)
+	AddBallot(ctx sdk.Context, identifier string, owner sdk.Address, applyLen int64, bond int64) sdk.Error
+	VoteBallot(ctx sdk.Context, owner sdk.Address, identifier string, vote bool, power int64) sdk.Error
+	DeleteBallot(ctx sdk.Context, identifier string)
+	ArchivePoll(ctx sdk.Context, ballot types.Ballot)
+	ArchivedPolls(ctx sdk.Context, identifier string) []types.Ballot
+}
+
+// Vote commitments by voter. GetCommitment returns nil if the voter has not committed.
+type CommitStore interface {
+	GetCommitment(ctx sdk.Context, voter types.Voter) []byte
+	SetCommitment(ctx sdk.Context, voter types.Voter, commitment []byte)
+	DeleteCommitment(ctx sdk.Context, voter types.Voter)
+}
+
+// Reveals, the claim ledger and delegations
+type RevealStore interface {
+	GetReveal(ctx sdk.Context, voter types.Voter) (types.Vote, bool)
+	SetReveal(ctx sdk.Context, voter types.Voter, vote types.Vote)
+	GetClaim(ctx sdk.Context, voter types.Voter) (types.Claim, bool)
+	SetClaim(ctx sdk.Context, voter types.Voter, claim types.Claim)
+	AddPendingClaim(ctx sdk.Context, owner sdk.Address, identifier string)
+	DeletePendingClaim(ctx sdk.Context, owner sdk.Address, identifier string)
+	PendingClaims(ctx sdk.Context, owner sdk.Address) []string
+	GetDelegation(ctx sdk.Context, delegator sdk.Address) (types.Delegation, bool)
+	SetDelegation(ctx sdk.Context, delegation types.Delegation)
+	DeleteDelegation(ctx sdk.Context, delegator sdk.Address)
+	Delegations(ctx sdk.Context, delegate sdk.Address) []types.Delegation
+}
+
+// Listings, their indexes and their archive. GetListing returns the zero
+// Listing for identifiers that are not listed.
+type ListingStore interface {
+	GetListing(ctx sdk.Context, identifier string) types.Listing
+	AddListing(ctx sdk.Context, identifier string, votes int64, bond int64)
+	RenewListing(ctx sdk.Context, identifier string) types.Listing
+	DeleteListing(ctx sdk.Context, identifier string)
+	ArchiveListing(ctx sdk.Context, ballot types.Ballot, reason types.RemovalReason)
+	GetArchivedListing(ctx sdk.Context, identifier string) (types.ArchivedListing, bool)
+	TopListings(ctx sdk.Context, limit int) []types.Listing
+	ExpiredListings(ctx sdk.Context, now int64) []types.Listing
+}
+
+// Event log of every listing
+type HistoryStore interface {
+	AppendHistory(ctx sdk.Context, identifier string, event types.HistoryEvent)
+	HistoryLen(ctx sdk.Context, identifier string) int64
+	History(ctx sdk.Context, identifier string, offset int64, limit int64) []types.HistoryEvent
+}
+
+// Bonds, stakes and fees held by the registry, in its staking denom
+type Escrow interface {
+	// Take amount from the address. Fails without effect if the balance is too low
+	Lock(ctx sdk.Context, from sdk.Address, amount int64) sdk.Error
+	// Pay amount to the address
+	Release(ctx sdk.Context, to sdk.Address, amount int64) sdk.Error
+}
+
+var _ Keeper = BallotMapper{}
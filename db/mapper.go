@@ -1,6 +1,8 @@
 package db
 
 import (
+	"encoding/binary"
+	"math"
 	"github.com/cosmos/cosmos-sdk/x/bank"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/tendermint/go-amino"
@@ -16,23 +18,347 @@ type BallotMapper struct {
 
 	BallotKey sdk.StoreKey
 
+	// Listings removed from their registry and polls of re-challenged listings
+	ArchiveKey sdk.StoreKey
+
+	// Append-only event log of every listing
+	HistoryKey sdk.StoreKey
+
 	Cdc *amino.Codec
+
+	// Unit of deadlines for new ballots. Defaults to block heights
+	Deadlines types.DeadlineMode
+
+	// Registry whose namespace the mapper reads and writes
+	Registry string
+
+	// Staking denom of the registry, used for every bond and payout
+	Denom string
+
+	// Lifetime of listings added from now on, in the unit of Deadlines. Zero never expires
+	ListingLen int64
+
+	// Holds the coins locked in the registry's escrow
+	Bank bank.Keeper
 }
 
-func NewBallotMapper(listingKey sdk.StoreKey, ballotkey sdk.StoreKey, commitKey sdk.StoreKey, revealKey sdk.StoreKey, _cdc *amino.Codec) BallotMapper {
+// Stores of a BallotMapper. Every registry shares them, each under its own prefix.
+type BallotKeys struct {
+	Listings sdk.StoreKey
+	Ballots sdk.StoreKey
+	Commits sdk.StoreKey
+	Reveals sdk.StoreKey
+	// Listings removed from their registry and polls of re-challenged listings
+	Archive sdk.StoreKey
+	// Append-only event log of every listing
+	History sdk.StoreKey
+}
+
+func NewBallotMapper(keys BallotKeys, _cdc *amino.Codec) BallotMapper {
 	return BallotMapper{
-		ListingKey: listingKey,
-		CommitKey: commitKey,
-		RevealKey: revealKey,
-		BallotKey: ballotkey,
+		ListingKey: keys.Listings,
+		CommitKey: keys.Commits,
+		RevealKey: keys.Reveals,
+		BallotKey: keys.Ballots,
+		ArchiveKey: keys.Archive,
+		HistoryKey: keys.History,
 		Cdc: _cdc,
+		Denom: types.DefaultDenom,
+	}
+}
+
+// Measure deadlines of ballots added from now on in the given unit
+func (bm BallotMapper) WithDeadlines(mode types.DeadlineMode) BallotMapper {
+	bm.Deadlines = mode
+	return bm
+}
+
+// Read and write in the namespace of the given registry
+func (bm BallotMapper) ForRegistry(id string) BallotMapper {
+	bm.Registry = id
+	return bm
+}
+
+// Post and pay out bonds in the given denom
+func (bm BallotMapper) WithDenom(denom string) BallotMapper {
+	bm.Denom = denom
+	return bm
+}
+
+// Give listings added from now on the given lifetime
+func (bm BallotMapper) WithListingLen(listingLen int64) BallotMapper {
+	bm.ListingLen = listingLen
+	return bm
+}
+
+// Lock and release escrowed coins through the given bank
+func (bm BallotMapper) WithBank(accountKeeper bank.Keeper) BallotMapper {
+	bm.Bank = accountKeeper
+	return bm
+}
+
+// Scope the mapper to a registry and its params
+func (bm BallotMapper) ForParams(registry types.Registry) BallotMapper {
+	return bm.ForRegistry(registry.ID).
+		WithDeadlines(registry.Params.Deadlines).
+		WithDenom(registry.Params.Denom).
+		WithListingLen(registry.Params.ListingLen)
+}
+
+// Current point in time in the unit of the mapper's deadlines
+func (bm BallotMapper) Now(ctx sdk.Context) int64 {
+	if bm.Deadlines == types.TimeDeadlines {
+		return ctx.BlockHeader().Time
+	}
+	return ctx.BlockHeight()
+}
+
+func (bm BallotMapper) RegistryID() string {
+	return bm.Registry
+}
+
+func (bm BallotMapper) StakingDenom() string {
+	return bm.Denom
+}
+
+func (bm BallotMapper) ListingLifetime() int64 {
+	return bm.ListingLen
+}
+
+// Subtract amount of the staking denom from the address. Bonds and stakes
+// are held by no account while locked.
+func (bm BallotMapper) Lock(ctx sdk.Context, from sdk.Address, amount int64) sdk.Error {
+	_, _, err := bm.Bank.SubtractCoins(ctx, from, bm.Coins(amount))
+	return err
+}
+
+func (bm BallotMapper) Release(ctx sdk.Context, to sdk.Address, amount int64) sdk.Error {
+	_, _, err := bm.Bank.AddCoins(ctx, to, bm.Coins(amount))
+	return err
+}
+
+// Coin of the mapper's staking denom
+func (bm BallotMapper) Coins(amount int64) []sdk.Coin {
+	return []sdk.Coin{sdk.Coin{
+		Denom: bm.Denom,
+		Amount: amount,
+	}}
+}
+
+// Store key of a ballot or listing in the mapper's registry
+func (bm BallotMapper) Key(identifier string) []byte {
+	return append(types.RegistryPrefix(bm.Registry), []byte(identifier)...)
+}
+
+// Store key of a voter's reveal in the mapper's registry
+func (bm BallotMapper) VoterKey(voter types.Voter) []byte {
+	bz, _ := bm.Cdc.MarshalBinary(voter)
+	return append(types.RegistryPrefix(bm.Registry), bz...)
+}
+
+// Store key of a voter's entry in the claim ledger
+func (bm BallotMapper) ClaimKey(voter types.Voter) []byte {
+	bz, _ := bm.Cdc.MarshalBinary(voter)
+	key := append(types.RegistryPrefix(bm.Registry), types.ClaimPrefix...)
+	return append(key, bz...)
+}
+
+// Store key of a voter's commitment, under the prefix of the voter's
+// commitments so they can be queried without scanning everyone's
+func (bm BallotMapper) CommitmentKey(voter types.Voter) []byte {
+	bz, _ := bm.Cdc.MarshalBinary(voter)
+	return append(types.CommitPrefix(bm.Registry, voter.Owner), bz...)
+}
+
+func (bm BallotMapper) GetCommitment(ctx sdk.Context, voter types.Voter) []byte {
+	store := ctx.KVStore(bm.CommitKey)
+	return store.Get(bm.CommitmentKey(voter))
+}
+
+func (bm BallotMapper) SetCommitment(ctx sdk.Context, voter types.Voter, commitment []byte) {
+	store := ctx.KVStore(bm.CommitKey)
+	store.Set(bm.CommitmentKey(voter), commitment)
+}
+
+func (bm BallotMapper) DeleteCommitment(ctx sdk.Context, voter types.Voter) {
+	store := ctx.KVStore(bm.CommitKey)
+	store.Delete(bm.CommitmentKey(voter))
+}
+
+// Returns false if the voter has not revealed on the poll or the reveal was pruned
+func (bm BallotMapper) GetReveal(ctx sdk.Context, voter types.Voter) (types.Vote, bool) {
+	store := ctx.KVStore(bm.RevealKey)
+	bz := store.Get(bm.VoterKey(voter))
+	if bz == nil {
+		return types.Vote{}, false
+	}
+	vote := types.Vote{}
+	err := bm.Cdc.UnmarshalBinary(bz, &vote)
+	if err != nil {
+		panic(err)
+	}
+	return vote, true
+}
+
+// Call process on every unclaimed reveal of the mapper's registry until it
+// returns true. Encoded voter keys never start with the prefix of an index
+// kept in the reveal store, which are all below 0x04.
+func (bm BallotMapper) IterateReveals(ctx sdk.Context, process func(types.Voter, types.Vote) (stop bool)) {
+	store := ctx.KVStore(bm.RevealKey)
+	prefix := types.RegistryPrefix(bm.Registry)
+
+	iter := sdk.KVStorePrefixIterator(store, prefix)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()[len(prefix):]
+		if key[0] <= types.DelegationPrefix[0] {
+			continue
+		}
+		voter := types.Voter{}
+		err := bm.Cdc.UnmarshalBinary(key, &voter)
+		if err != nil {
+			panic(err)
+		}
+		vote := types.Vote{}
+		err = bm.Cdc.UnmarshalBinary(iter.Value(), &vote)
+		if err != nil {
+			panic(err)
+		}
+		if process(voter, vote) {
+			return
+		}
+	}
+}
+
+func (bm BallotMapper) SetReveal(ctx sdk.Context, voter types.Voter, vote types.Vote) {
+	store := ctx.KVStore(bm.RevealKey)
+	bz, _ := bm.Cdc.MarshalBinary(vote)
+	store.Set(bm.VoterKey(voter), bz)
+}
+
+// Returns false if the voter has not claimed on the poll
+func (bm BallotMapper) GetClaim(ctx sdk.Context, voter types.Voter) (types.Claim, bool) {
+	store := ctx.KVStore(bm.RevealKey)
+	bz := store.Get(bm.ClaimKey(voter))
+	if bz == nil {
+		return types.Claim{}, false
+	}
+	claim := types.Claim{}
+	err := bm.Cdc.UnmarshalBinary(bz, &claim)
+	if err != nil {
+		panic(err)
+	}
+	return claim, true
+}
+
+// Record a paid claim and prune the reveal it paid out
+func (bm BallotMapper) SetClaim(ctx sdk.Context, voter types.Voter, claim types.Claim) {
+	store := ctx.KVStore(bm.RevealKey)
+	bz, _ := bm.Cdc.MarshalBinary(claim)
+	store.Set(bm.ClaimKey(voter), bz)
+	store.Delete(bm.VoterKey(voter))
+}
+
+// Store key of the index entry marking an unclaimed reveal of owner on a ballot
+func (bm BallotMapper) pendingClaimKey(owner sdk.Address, identifier string) []byte {
+	return append(bm.pendingClaimPrefix(owner), []byte(identifier)...)
+}
+
+func (bm BallotMapper) pendingClaimPrefix(owner sdk.Address) []byte {
+	key := append(types.RegistryPrefix(bm.Registry), types.PendingClaimPrefix...)
+	return append(key, owner...)
+}
+
+func (bm BallotMapper) AddPendingClaim(ctx sdk.Context, owner sdk.Address, identifier string) {
+	store := ctx.KVStore(bm.RevealKey)
+	store.Set(bm.pendingClaimKey(owner, identifier), []byte{})
+}
+
+func (bm BallotMapper) DeletePendingClaim(ctx sdk.Context, owner sdk.Address, identifier string) {
+	store := ctx.KVStore(bm.RevealKey)
+	store.Delete(bm.pendingClaimKey(owner, identifier))
+}
+
+// Identifiers of ballots the owner revealed on and has not claimed, in order
+func (bm BallotMapper) PendingClaims(ctx sdk.Context, owner sdk.Address) []string {
+	store := ctx.KVStore(bm.RevealKey)
+	prefix := bm.pendingClaimPrefix(owner)
+
+	iter := sdk.KVStorePrefixIterator(store, prefix)
+	defer iter.Close()
+
+	identifiers := []string{}
+	for ; iter.Valid(); iter.Next() {
+		identifiers = append(identifiers, string(iter.Key()[len(prefix):]))
+	}
+	return identifiers
+}
+
+func (bm BallotMapper) delegationKey(delegator sdk.Address) []byte {
+	key := append(types.RegistryPrefix(bm.Registry), types.DelegationPrefix...)
+	return append(key, delegator...)
+}
+
+func (bm BallotMapper) delegatePrefix(delegate sdk.Address) []byte {
+	key := append(types.RegistryPrefix(bm.Registry), types.DelegatePrefix...)
+	return append(key, delegate...)
+}
+
+// Returns false if the delegator has not delegated on the registry
+func (bm BallotMapper) GetDelegation(ctx sdk.Context, delegator sdk.Address) (types.Delegation, bool) {
+	store := ctx.KVStore(bm.RevealKey)
+	bz := store.Get(bm.delegationKey(delegator))
+	if bz == nil {
+		return types.Delegation{}, false
+	}
+	delegation := types.Delegation{}
+	err := bm.Cdc.UnmarshalBinary(bz, &delegation)
+	if err != nil {
+		panic(err)
+	}
+	return delegation, true
+}
+
+// Set the delegator's delegation, replacing any previous one
+func (bm BallotMapper) SetDelegation(ctx sdk.Context, delegation types.Delegation) {
+	bm.DeleteDelegation(ctx, delegation.Delegator)
+
+	store := ctx.KVStore(bm.RevealKey)
+	bz, _ := bm.Cdc.MarshalBinary(delegation)
+	store.Set(bm.delegationKey(delegation.Delegator), bz)
+	store.Set(append(bm.delegatePrefix(delegation.Delegate), delegation.Delegator...), []byte{})
+}
+
+func (bm BallotMapper) DeleteDelegation(ctx sdk.Context, delegator sdk.Address) {
+	delegation, ok := bm.GetDelegation(ctx, delegator)
+	if !ok {
+		return
+	}
+	store := ctx.KVStore(bm.RevealKey)
+	store.Delete(bm.delegationKey(delegator))
+	store.Delete(append(bm.delegatePrefix(delegation.Delegate), delegator...))
+}
+
+// Delegations to the delegate on the registry, ordered by delegator
+func (bm BallotMapper) Delegations(ctx sdk.Context, delegate sdk.Address) []types.Delegation {
+	store := ctx.KVStore(bm.RevealKey)
+	prefix := bm.delegatePrefix(delegate)
+
+	iter := sdk.KVStorePrefixIterator(store, prefix)
+	defer iter.Close()
+
+	delegations := []types.Delegation{}
+	for ; iter.Valid(); iter.Next() {
+		delegation, _ := bm.GetDelegation(ctx, sdk.Address(iter.Key()[len(prefix):]))
+		delegations = append(delegations, delegation)
 	}
+	return delegations
 }
 
 // Will get Ballot using unique identifier. Do not need to specify status
 func (bm BallotMapper) GetBallot(ctx sdk.Context, identifier string) types.Ballot {
 	store := ctx.KVStore(bm.BallotKey)
-	key := []byte(identifier)
+	key := bm.Key(identifier)
 	val := store.Get(key)
 	if val == nil {
 		return types.Ballot{}
@@ -45,61 +371,57 @@ func (bm BallotMapper) GetBallot(ctx sdk.Context, identifier string) types.Ballo
 	return *ballot
 }
 
+// Call process on every ballot of the mapper's registry, in identifier
+// order, until it returns true
+func (bm BallotMapper) IterateBallots(ctx sdk.Context, process func(types.Ballot) (stop bool)) {
+	store := ctx.KVStore(bm.BallotKey)
+
+	iter := sdk.KVStorePrefixIterator(store, types.RegistryPrefix(bm.Registry))
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		ballot := types.Ballot{}
+		err := bm.Cdc.UnmarshalBinary(iter.Value(), &ballot)
+		if err != nil {
+			panic(err)
+		}
+		if process(ballot) {
+			return
+		}
+	}
+}
+
+func (bm BallotMapper) SetBallot(ctx sdk.Context, ballot types.Ballot) {
+	store := ctx.KVStore(bm.BallotKey)
+	key := bm.Key(ballot.Identifier)
+	val, _ := bm.Cdc.MarshalBinary(ballot)
+	store.Set(key, val)
+}
+
 func (bm BallotMapper) AddBallot(ctx sdk.Context, identifier string, owner sdk.Address, applyLen int64, bond int64) sdk.Error {
 	store := ctx.KVStore(bm.BallotKey)
 
 	newBallot := types.Ballot{
 		Identifier: identifier,
 		Owner: owner,
+		Status: types.StatusApplying,
 		Bond: bond,
-		EndApplyBlockStamp: ctx.BlockHeight() + applyLen,
+		Deadlines: bm.Deadlines,
 	}
+	newBallot.EndApplyBlockStamp = newBallot.Now(ctx) + applyLen
 	// Add ballot with Pending Status
-	key := []byte(identifier)
+	key := bm.Key(identifier)
 	val, _ := bm.Cdc.MarshalBinary(newBallot)
 	store.Set(key, val)
 	return nil
 }
 
-func (bm BallotMapper) ActivateBallot(ctx sdk.Context, accountKeeper bank.Keeper, owner sdk.Address, challenger sdk.Address, identifier string, commitLen int64, revealLen, minBond int64, challengeBond int64) sdk.Error {
-	store := ctx.KVStore(bm.BallotKey)
-	ballot := bm.GetBallot(ctx, identifier)
-
-	if ballot.Bond < minBond {
-		bm.DeleteBallot(ctx, identifier)
-		refund := sdk.Coin{
-			Denom: "RegistryCoin",
-			Amount: challengeBond,
-		}
-		_, _, err := accountKeeper.AddCoins(ctx, challenger, []sdk.Coin{refund})
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	if ballot.Bond != challengeBond {
-		return sdk.NewError(2, 115, "Must match candidate's bond")
-	}
-
-	ballot.Active = true
-	ballot.Challenger = challenger
-	ballot.EndCommitBlockStamp = ctx.BlockHeight() + commitLen
-	ballot.EndRevealBlockStamp = ballot.EndCommitBlockStamp + revealLen
-
-	newBallot, _ := bm.Cdc.MarshalBinary(ballot)
-	key:= []byte(identifier)
-	store.Set(key, newBallot)
-
-	return nil
-}
-
 func (bm BallotMapper) VoteBallot(ctx sdk.Context, owner sdk.Address, identifier string, vote bool, power int64) sdk.Error {
 	ballotStore := ctx.KVStore(bm.BallotKey)
 
-	ballotKey := []byte(identifier)
+	ballotKey := bm.Key(identifier)
 	bz := ballotStore.Get(ballotKey)
 	if bz == nil {
-		return sdk.NewError(2, 107, "Ballot does not exist")
+		return types.ErrUnknownCandidate("")
 	}
 	ballot := &types.Ballot{}
 	err := bm.Cdc.UnmarshalBinary(bz, ballot)
@@ -118,26 +440,90 @@ func (bm BallotMapper) VoteBallot(ctx sdk.Context, owner sdk.Address, identifier
 }
 
 func (bm BallotMapper) DeleteBallot(ctx sdk.Context, identifier string) {
-	key := []byte(identifier)
+	key := bm.Key(identifier)
 	store := ctx.KVStore(bm.BallotKey)
 	store.Delete(key)
 }
 
-func (bm BallotMapper) AddListing(ctx sdk.Context, identifier string, votes int64) {
-	key := []byte(identifier)
-	store := ctx.KVStore(bm.ListingKey)
+// Archived polls live in the registry's archive namespace under
+// types.PollArchivePrefix, keyed by poll ID
+func (bm BallotMapper) pollArchiveKey(pollID string) []byte {
+	return bm.Key(string(types.PollArchivePrefix) + pollID)
+}
+
+// Keep the ballot as it stood on its poll. Archiving it again overwrites it.
+func (bm BallotMapper) ArchivePoll(ctx sdk.Context, ballot types.Ballot) {
+	store := ctx.KVStore(bm.ArchiveKey)
+	val, _ := bm.Cdc.MarshalBinary(ballot)
+	store.Set(bm.pollArchiveKey(ballot.PollID), val)
+}
+
+// Archived polls of a listing, in poll ID order
+func (bm BallotMapper) ArchivedPolls(ctx sdk.Context, identifier string) []types.Ballot {
+	store := ctx.KVStore(bm.ArchiveKey)
+	iter := sdk.KVStorePrefixIterator(store, bm.pollArchiveKey(identifier + "/"))
+	defer iter.Close()
+
+	polls := []types.Ballot{}
+	for ; iter.Valid(); iter.Next() {
+		ballot := types.Ballot{}
+		err := bm.Cdc.UnmarshalBinary(iter.Value(), &ballot)
+		if err != nil {
+			panic(err)
+		}
+		// Poll IDs of identifiers containing a slash share the prefix
+		if ballot.Identifier == identifier {
+			polls = append(polls, ballot)
+		}
+	}
+	return polls
+}
+
+// Add or replace a listing and keep the rank index in step. A listing is
+// re-ranked with the approving votes of each challenge it survives, or of the
+// appeal of it; while a challenge is open it keeps its previous score.
+func (bm BallotMapper) AddListing(ctx sdk.Context, identifier string, votes int64, bond int64) {
+	bm.DeleteListing(ctx, identifier)
 
 	listing := types.Listing{
 		Identifier: identifier,
 		Votes: votes,
+		Bond: bond,
+	}
+	if bm.ListingLen > 0 {
+		listing.Expiry = bm.Now(ctx) + bm.ListingLen
 	}
+	bm.setListing(ctx, listing)
+}
+
+// Extend a listing's expiry by the mapper's listing lifetime. Listings that
+// lapsed, or never had an expiry, run for one lifetime from now.
+func (bm BallotMapper) RenewListing(ctx sdk.Context, identifier string) types.Listing {
+	listing := bm.GetListing(ctx, identifier)
+	bm.DeleteListing(ctx, identifier)
+
+	if now := bm.Now(ctx); listing.Expiry < now {
+		listing.Expiry = now
+	}
+	listing.Expiry += bm.ListingLen
+	bm.setListing(ctx, listing)
+	return listing
+}
+
+// Write a listing and its index entries
+func (bm BallotMapper) setListing(ctx sdk.Context, listing types.Listing) {
+	store := ctx.KVStore(bm.ListingKey)
 	val, _ := bm.Cdc.MarshalBinary(listing)
 
-	store.Set(key, val)
+	store.Set(bm.Key(listing.Identifier), val)
+	store.Set(bm.rankKey(listing), val)
+	if listing.Expiry > 0 {
+		store.Set(bm.expiryKey(listing), []byte{})
+	}
 }
 
 func (bm BallotMapper) GetListing(ctx sdk.Context, identifier string) types.Listing {
-	key := []byte(identifier)
+	key := bm.Key(identifier)
 	store := ctx.KVStore(bm.ListingKey)
 
 	bz := store.Get(key)
@@ -154,8 +540,152 @@ func (bm BallotMapper) GetListing(ctx sdk.Context, identifier string) types.List
 }
 
 func (bm BallotMapper) DeleteListing(ctx sdk.Context, identifier string) {
-	key := []byte(identifier)
+	key := bm.Key(identifier)
 	store := ctx.KVStore(bm.ListingKey)
 
+	listing := bm.GetListing(ctx, identifier)
+	if listing.Identifier != "" {
+		store.Delete(bm.rankKey(listing))
+		store.Delete(bm.expiryKey(listing))
+	}
 	store.Delete(key)
-}
\ No newline at end of file
+}
+
+// Remove the ballot's listing and archive it with the reason and the ballot's
+// last tallies. Does nothing if the identifier is not listed.
+func (bm BallotMapper) ArchiveListing(ctx sdk.Context, ballot types.Ballot, reason types.RemovalReason) {
+	listing := bm.GetListing(ctx, ballot.Identifier)
+	if listing.Identifier == "" {
+		return
+	}
+	bm.DeleteListing(ctx, ballot.Identifier)
+
+	archived := types.ArchivedListing{
+		Listing: listing,
+		Owner: ballot.Owner,
+		Height: ctx.BlockHeight(),
+		Reason: reason,
+		PollID: ballot.PollID,
+		Approve: ballot.Approve,
+		Deny: ballot.Deny,
+	}
+	val, _ := bm.Cdc.MarshalBinary(archived)
+	store := ctx.KVStore(bm.ArchiveKey)
+	store.Set(bm.Key(ballot.Identifier), val)
+}
+
+// Last removal of a listing. A listing that is accepted again keeps its
+// archive entry until it is removed again.
+func (bm BallotMapper) GetArchivedListing(ctx sdk.Context, identifier string) (types.ArchivedListing, bool) {
+	store := ctx.KVStore(bm.ArchiveKey)
+	bz := store.Get(bm.Key(identifier))
+	if bz == nil {
+		return types.ArchivedListing{}, false
+	}
+	archived := types.ArchivedListing{}
+	err := bm.Cdc.UnmarshalBinary(bz, &archived)
+	if err != nil {
+		panic(err)
+	}
+	return archived, true
+}
+
+// Append an event at the current height to the end of a listing's history
+func (bm BallotMapper) AppendHistory(ctx sdk.Context, identifier string, event types.HistoryEvent) {
+	store := ctx.KVStore(bm.HistoryKey)
+	seq := bm.HistoryLen(ctx, identifier)
+
+	event.Height = ctx.BlockHeight()
+	val, _ := bm.Cdc.MarshalBinary(event)
+	store.Set(types.HistoryKey(bm.Registry, identifier, seq), val)
+
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, uint64(seq + 1))
+	store.Set(types.HistoryPrefix(bm.Registry, identifier), count)
+}
+
+// Number of events in a listing's history
+func (bm BallotMapper) HistoryLen(ctx sdk.Context, identifier string) int64 {
+	store := ctx.KVStore(bm.HistoryKey)
+	bz := store.Get(types.HistoryPrefix(bm.Registry, identifier))
+	if bz == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(bz))
+}
+
+// Up to limit events of a listing's history starting at position offset, oldest first
+func (bm BallotMapper) History(ctx sdk.Context, identifier string, offset int64, limit int64) []types.HistoryEvent {
+	store := ctx.KVStore(bm.HistoryKey)
+
+	iter := store.Iterator(types.HistoryKey(bm.Registry, identifier, offset), types.HistoryKey(bm.Registry, identifier, offset + limit))
+	defer iter.Close()
+
+	events := []types.HistoryEvent{}
+	for ; iter.Valid(); iter.Next() {
+		event := types.HistoryEvent{}
+		err := bm.Cdc.UnmarshalBinary(iter.Value(), &event)
+		if err != nil {
+			panic(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Up to limit listings, highest score first. Ties are ordered by identifier.
+func (bm BallotMapper) TopListings(ctx sdk.Context, limit int) []types.Listing {
+	store := ctx.KVStore(bm.ListingKey)
+
+	iter := sdk.KVStorePrefixIterator(store, bm.Key(string(types.RankPrefix)))
+	defer iter.Close()
+
+	listings := []types.Listing{}
+	for ; iter.Valid() && len(listings) < limit; iter.Next() {
+		listing := types.Listing{}
+		err := bm.Cdc.UnmarshalBinary(iter.Value(), &listing)
+		if err != nil {
+			panic(err)
+		}
+		listings = append(listings, listing)
+	}
+	return listings
+}
+
+// Rank index entries live in the registry's listing namespace under types.RankPrefix.
+// Inverting the score makes ascending iteration yield the highest score first.
+func (bm BallotMapper) rankKey(listing types.Listing) []byte {
+	inverted := make([]byte, 8)
+	binary.BigEndian.PutUint64(inverted, uint64(math.MaxInt64 - listing.Score()))
+
+	key := bm.Key(string(types.RankPrefix))
+	key = append(key, inverted...)
+	return append(key, []byte(listing.Identifier)...)
+}
+
+// Listings whose expiry is before now, soonest first
+func (bm BallotMapper) ExpiredListings(ctx sdk.Context, now int64) []types.Listing {
+	store := ctx.KVStore(bm.ListingKey)
+	prefix := bm.Key(string(types.ExpiryPrefix))
+
+	iter := store.Iterator(prefix, bm.expiryKey(types.Listing{Expiry: now}))
+	defer iter.Close()
+
+	listings := []types.Listing{}
+	for ; iter.Valid(); iter.Next() {
+		identifier := string(iter.Key()[len(prefix) + 8:])
+		listings = append(listings, bm.GetListing(ctx, identifier))
+	}
+	return listings
+}
+
+// Expiry index entries live in the registry's listing namespace under
+// types.ExpiryPrefix, ordered by expiry
+func (bm BallotMapper) expiryKey(listing types.Listing) []byte {
+	expiry := make([]byte, 8)
+	binary.BigEndian.PutUint64(expiry, uint64(listing.Expiry))
+
+	key := bm.Key(string(types.ExpiryPrefix))
+	key = append(key, expiry...)
+	return append(key, []byte(listing.Identifier)...)
+}
@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"testing"
 	"github.com/stretchr/testify/assert"
-	"github.com/cosmos/cosmos-sdk/x/auth"
-	"github.com/cosmos/cosmos-sdk/x/bank"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	abci "github.com/tendermint/abci/types"
 
@@ -15,13 +13,13 @@ import (
 )
 
 func TestAddGet(t *testing.T) {
-	ms, listKey, ballotKey, commitKey, revealKey, _ := SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
 	cdc := MakeCodec()
 
 
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 
-	mapper := NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	addr := utils.GenerateAddress()
 	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 50)
@@ -29,6 +27,7 @@ func TestAddGet(t *testing.T) {
 	ballot := types.Ballot{
 		Identifier: "Unique registry listing",
 		Owner: addr,
+		Status: types.StatusApplying,
 		Bond: 50,
 		EndApplyBlockStamp: 5,
 	}
@@ -39,13 +38,13 @@ func TestAddGet(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	ms, listKey, ballotKey, commitKey, revealKey, _ := SetupMultiStore()
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
 	cdc := MakeCodec()
 
 
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 	ctx.WithBlockHeight(10)
-	mapper := NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	addr := utils.GenerateAddress()
 	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 50)
@@ -57,104 +56,189 @@ func TestDelete(t *testing.T) {
 	assert.Equal(t, types.Ballot{}, ballot, "Ballot was not correctly deleted")
 }
 
-func TestActivate(t *testing.T) {
-	ms, listKey, ballotKey, commitKey, revealKey, accountKey := SetupMultiStore()
+func TestVote(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
 	cdc := MakeCodec()
 
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
 	ctx.WithBlockHeight(10)
-	mapper := NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
 	addr := utils.GenerateAddress()
-	account := auth.NewBaseAccountWithAddress(addr)
 	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 50)
 
-	accountMapper := auth.NewAccountMapper(cdc, accountKey, &auth.BaseAccount{})
-	accountKeeper :=  bank.NewKeeper(accountMapper)
-
-	accountMapper.SetAccount(ctx, &account)
-	testaccount := accountMapper.GetAccount(ctx, addr)
-
-	assert.Equal(t, &account, testaccount, "Accounts don't match")
-
-
-	// Touch and remove case: Bond posted is less than new minBond
-	challenger := utils.GenerateAddress()
-	mapper.ActivateBallot(ctx, accountKeeper, addr, challenger, "Unique registry listing", 10, 10, 100, 100)
+	mapper.VoteBallot(ctx, addr, "Unique registry listing", true, 50)
 
-	delBallot := mapper.GetBallot(ctx, "Unique registry listing")
+	ballot := mapper.GetBallot(ctx, "Unique registry listing")
 
-	assert.Equal(t, types.Ballot{}, delBallot, "Outdated ballot was not deleted")
-	
-	// Check that challenger is refunded
-	coins := accountKeeper.GetCoins(ctx, challenger)
-	assert.Equal(t, int64(100), coins.AmountOf("RegistryCoin"), "Challenger did not get refunded after deleted ballot")
+	assert.Equal(t, int64(50), ballot.Approve, "Votes did not increment correctly")
+}
 
+func TestAddDeleteList(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
+	cdc := MakeCodec()
 
-	// Test Activating with less than posted bond
-	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 150)
-	err := mapper.ActivateBallot(ctx, accountKeeper, addr, challenger, "Unique registry listing", 10, 10, 100, 100)
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+	ctx.WithBlockHeight(10)
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
-	assert.Equal(t, sdk.CodeType(115), err.Code(), err.Error())
+	mapper.AddListing(ctx, "Unique registry listing", 200, 100)
 
-	err = mapper.ActivateBallot(ctx, accountKeeper, addr, challenger, "Unique registry listing", 10, 10, 100, 200)
+	listing := mapper.GetListing(ctx, "Unique registry listing")
 
-	assert.Equal(t, sdk.CodeType(115), err.Code(), err.Error())
+	expected := types.Listing{
+		Identifier: "Unique registry listing",
+		Votes: 200,
+		Bond: 100,
+	}
 
+	assert.Equal(t, expected, listing, "Listing not added correctly")
 
-	// Test valid activation
-	err = mapper.ActivateBallot(ctx, accountKeeper, addr, challenger, "Unique registry listing", 10, 10, 100, 150)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	mapper.DeleteListing(ctx, "Unique registry listing")
 
-	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	delListing := mapper.GetListing(ctx, "Unique registry listing")
 
-	assert.Equal(t, true, ballot.Active, "Ballot not activated")
+	assert.Equal(t, types.Listing{}, delListing, "Listing not added correctly")
 }
 
-func TestVote(t *testing.T) {
-	ms, listKey, ballotKey, commitKey, revealKey, _ := SetupMultiStore()
+func TestTopListings(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
 	cdc := MakeCodec()
 
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
-	ctx.WithBlockHeight(10)
-	mapper := NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
-	addr := utils.GenerateAddress()
-	mapper.AddBallot(ctx, "Unique registry listing", addr, 5, 50)
+	mapper.AddListing(ctx, "Unchallenged", 0, 100)
+	mapper.AddListing(ctx, "Survived challenge", 300, 100)
+	mapper.AddListing(ctx, "Small bond", 0, 50)
+	mapper.AddListing(ctx, "Also unchallenged", 0, 100)
 
-	mapper.VoteBallot(ctx, addr, "Unique registry listing", true, 50)
+	top := mapper.TopListings(ctx, 3)
 
-	ballot := mapper.GetBallot(ctx, "Unique registry listing")
+	assert.Equal(t, 3, len(top), "Limit not applied")
+	assert.Equal(t, "Survived challenge", top[0].Identifier, "Highest score not first")
+	// Equal scores are ordered by identifier
+	assert.Equal(t, "Also unchallenged", top[1].Identifier, "Ties not ordered by identifier")
+	assert.Equal(t, "Unchallenged", top[2].Identifier, "Ties not ordered by identifier")
 
-	assert.Equal(t, int64(50), ballot.Approve, "Votes did not increment correctly")
+	// Re-adding after another challenge replaces the old score
+	mapper.AddListing(ctx, "Small bond", 500, 50)
+	top = mapper.TopListings(ctx, 10)
+
+	assert.Equal(t, 4, len(top), "Stale rank entry left behind")
+	assert.Equal(t, "Small bond", top[0].Identifier, "Listing not re-ranked")
+
+	// Removed listings leave the ranking
+	mapper.DeleteListing(ctx, "Survived challenge")
+	top = mapper.TopListings(ctx, 10)
+
+	assert.Equal(t, 3, len(top), "Deleted listing still ranked")
+	assert.Equal(t, types.Listing{}, mapper.GetListing(ctx, string(types.RankPrefix)), "Rank index visible as a listing")
 }
 
-func TestAddDeleteList(t *testing.T) {
-	ms, listKey, ballotKey, commitKey, revealKey, _ := SetupMultiStore()
+func TestHistory(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
 	cdc := MakeCodec()
 
 	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
-	ctx.WithBlockHeight(10)
-	mapper := NewBallotMapper(listKey, ballotKey, commitKey, revealKey, cdc)
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
 
-	mapper.AddListing(ctx, "Unique registry listing", 200)
+	addr := utils.GenerateAddress()
+	for i := int64(0); i < 5; i++ {
+		ctx = ctx.WithBlockHeight(i)
+		mapper.AppendHistory(ctx, "Listing", types.HistoryEvent{
+			Action: fmt.Sprintf("action %d", i),
+			Actor: addr,
+		})
+	}
+	// Identifier extending another one keeps a separate history
+	mapper.AppendHistory(ctx, "Listing 2", types.HistoryEvent{
+		Action: "other",
+	})
+
+	assert.Equal(t, int64(5), mapper.HistoryLen(ctx, "Listing"), "Wrong history length")
+	assert.Equal(t, int64(1), mapper.HistoryLen(ctx, "Listing 2"), "Wrong history length")
+	assert.Equal(t, int64(0), mapper.HistoryLen(ctx, "Unknown"), "History of unknown listing")
+
+	page := mapper.History(ctx, "Listing", 2, 2)
+	assert.Equal(t, 2, len(page), "Wrong page size")
+	assert.Equal(t, "action 2", page[0].Action, "Page starts at wrong event")
+	assert.Equal(t, int64(3), page[1].Height, "Height not recorded")
+	assert.Equal(t, addr, page[1].Actor, "Actor not recorded")
+
+	// Last page is cut short
+	assert.Equal(t, 1, len(mapper.History(ctx, "Listing", 4, 2)), "Read past end of history")
+	assert.Equal(t, 0, len(mapper.History(ctx, "Listing", 5, 2)), "Read past end of history")
+
+	// Histories are kept per registry
+	assert.Equal(t, int64(0), mapper.ForRegistry("news").HistoryLen(ctx, "Listing"), "History leaked across registries")
+}
 
-	listing := mapper.GetListing(ctx, "Unique registry listing")
+func TestIterateReveals(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
+	cdc := MakeCodec()
 
-	expected := types.Listing{
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc).ForRegistry(types.DefaultRegistryID)
+
+	voter := types.Voter{
+		Owner: utils.GenerateAddress(),
 		Identifier: "Unique registry listing",
-		Votes: 200,
+		PollID: "poll",
 	}
+	claimed := voter
+	claimed.Owner = utils.GenerateAddress()
+
+	mapper.SetReveal(ctx, voter, types.Vote{Choice: true, Power: 30})
+	mapper.SetReveal(ctx, claimed, types.Vote{Choice: false, Power: 20})
+	mapper.SetClaim(ctx, claimed, types.Claim{Paid: 20})
+
+	// Indexes in the reveal store are skipped
+	mapper.AddPendingClaim(ctx, voter.Owner, voter.Identifier)
+	mapper.SetDelegation(ctx, types.Delegation{
+		Delegator: utils.GenerateAddress(),
+		Delegate: voter.Owner,
+		Power: 10,
+	})
+	// So are other registries
+	mapper.ForRegistry("other").SetReveal(ctx, voter, types.Vote{Power: 40})
+
+	var voters []types.Voter
+	var power int64
+	mapper.IterateReveals(ctx, func(v types.Voter, vote types.Vote) bool {
+		voters = append(voters, v)
+		power += vote.Power
+		return false
+	})
+	assert.Equal(t, []types.Voter{voter}, voters, "Wrong reveals iterated")
+	assert.Equal(t, int64(30), power, "Wrong reveal power iterated")
+}
 
-	assert.Equal(t, expected, listing, "Listing not added correctly")
+func TestCommitmentsByOwner(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, _, _ := SetupMultiStore()
+	cdc := MakeCodec()
 
-	mapper.DeleteListing(ctx, "Unique registry listing")
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc).ForRegistry(types.DefaultRegistryID)
 
-	delListing := mapper.GetListing(ctx, "Unique registry listing")
+	owner := utils.GenerateAddress()
+	other := utils.GenerateAddress()
+	for _, poll := range []string{"first", "second"} {
+		mapper.SetCommitment(ctx, types.Voter{Owner: owner, Identifier: "Unique registry listing", PollID: poll}, []byte(poll))
+		mapper.SetCommitment(ctx, types.Voter{Owner: other, Identifier: "Unique registry listing", PollID: poll}, []byte("other"))
+	}
 
-	assert.Equal(t, types.Listing{}, delListing, "Listing not added correctly")
+	// Only the owner's commitments are under its prefix
+	prefix := types.CommitPrefix(types.DefaultRegistryID, owner)
+	iter := sdk.KVStorePrefixIterator(ctx.KVStore(commitKey), prefix)
+	defer iter.Close()
+	var polls []string
+	for ; iter.Valid(); iter.Next() {
+		voter := types.Voter{}
+		assert.Nil(t, cdc.UnmarshalBinary(iter.Key()[len(prefix):], &voter))
+		assert.Equal(t, owner, voter.Owner, "Other owner's commitment under prefix")
+		assert.Equal(t, []byte(voter.PollID), iter.Value())
+		polls = append(polls, voter.PollID)
+	}
+	assert.Equal(t, 2, len(polls), "Owner's commitments not under prefix")
 }
-
-
@@ -0,0 +1,93 @@
+package db
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/go-amino"
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+// Key of the denoms registries may stake in. Registry IDs never start with 0x00
+var stakingDenomsKey = []byte{0x00}
+
+// Stores each registry and its parameters under its ID
+type RegistryMapper struct {
+	RegistryKey sdk.StoreKey
+
+	Cdc *amino.Codec
+}
+
+func NewRegistryMapper(registryKey sdk.StoreKey, _cdc *amino.Codec) RegistryMapper {
+	return RegistryMapper{
+		RegistryKey: registryKey,
+		Cdc: _cdc,
+	}
+}
+
+// Returns false if no registry exists with the given ID
+func (rm RegistryMapper) GetRegistry(ctx sdk.Context, id string) (types.Registry, bool) {
+	store := ctx.KVStore(rm.RegistryKey)
+	bz := store.Get([]byte(id))
+	if bz == nil {
+		return types.Registry{}, false
+	}
+	registry := types.Registry{}
+	err := rm.Cdc.UnmarshalBinary(bz, &registry)
+	if err != nil {
+		panic(err)
+	}
+	return registry, true
+}
+
+func (rm RegistryMapper) SetRegistry(ctx sdk.Context, registry types.Registry) {
+	store := ctx.KVStore(rm.RegistryKey)
+	bz, _ := rm.Cdc.MarshalBinary(registry)
+	store.Set([]byte(registry.ID), bz)
+}
+
+// Calls process on every registry in ID order until it returns true
+func (rm RegistryMapper) IterateRegistries(ctx sdk.Context, process func(types.Registry) (stop bool)) {
+	store := ctx.KVStore(rm.RegistryKey)
+	// Start after the staking denoms key
+	iter := store.Iterator(append(stakingDenomsKey, 0x00), nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		registry := types.Registry{}
+		err := rm.Cdc.UnmarshalBinary(iter.Value(), &registry)
+		if err != nil {
+			panic(err)
+		}
+		if process(registry) {
+			return
+		}
+	}
+}
+
+// Denoms that registries may choose as their staking denom, set at genesis
+func (rm RegistryMapper) GetStakingDenoms(ctx sdk.Context) []string {
+	store := ctx.KVStore(rm.RegistryKey)
+	bz := store.Get(stakingDenomsKey)
+	if bz == nil {
+		return nil
+	}
+	denoms := []string{}
+	err := rm.Cdc.UnmarshalBinary(bz, &denoms)
+	if err != nil {
+		panic(err)
+	}
+	return denoms
+}
+
+func (rm RegistryMapper) SetStakingDenoms(ctx sdk.Context, denoms []string) {
+	store := ctx.KVStore(rm.RegistryKey)
+	bz, _ := rm.Cdc.MarshalBinary(denoms)
+	store.Set(stakingDenomsKey, bz)
+}
+
+func (rm RegistryMapper) IsStakingDenom(ctx sdk.Context, denom string) bool {
+	for _, stakingDenom := range rm.GetStakingDenoms(ctx) {
+		if stakingDenom == denom {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,358 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/go-amino"
+	"github.com/AdityaSripal/token_curated_registry/types"
+)
+
+// Layout of the values this code writes. Bump it with every change to the
+// encoding of a stored type and register a migration from the previous one.
+const SchemaVersion int64 = 2
+
+// Stores written before the version was recorded have the layout of the
+// first release
+const unversionedSchema int64 = 1
+
+var schemaVersionKey = []byte("schemaVersion")
+
+// Mappers over the stores a migration rewrites
+type Stores struct {
+	Ballots BallotMapper
+	Registries RegistryMapper
+	// Params the app gives the default registry, for layouts without registries
+	DefaultParams types.RegistryParams
+}
+
+// Rewrites stored values from layout From to layout From + 1
+type Migration struct {
+	From int64
+	Name string
+	Migrate func(ctx sdk.Context, stores Stores) error
+}
+
+// Every migration this code knows, by the version it starts from
+var DefaultMigrations = []Migration{
+	{From: 1, Name: "default-registry", Migrate: migrateFirstRelease},
+}
+
+// Records the schema version in the main store and brings older stores up to date
+type SchemaMapper struct {
+	MainKey sdk.StoreKey
+
+	Cdc *amino.Codec
+
+	// Height of the block that runs pending migrations. Zero runs them in the
+	// first block after the app starts with a newer SchemaVersion.
+	UpgradeHeight int64
+
+	migrations map[int64]Migration
+}
+
+func NewSchemaMapper(mainKey sdk.StoreKey, cdc *amino.Codec) SchemaMapper {
+	sm := SchemaMapper{
+		MainKey: mainKey,
+		Cdc: cdc,
+		migrations: map[int64]Migration{},
+	}
+	for _, migration := range DefaultMigrations {
+		sm = sm.WithMigration(migration)
+	}
+	return sm
+}
+
+// Run migration for stores at its From version, replacing any registered for it
+func (sm SchemaMapper) WithMigration(migration Migration) SchemaMapper {
+	migrations := make(map[int64]Migration, len(sm.migrations) + 1)
+	for from, m := range sm.migrations {
+		migrations[from] = m
+	}
+	migrations[migration.From] = migration
+	sm.migrations = migrations
+	return sm
+}
+
+// Hold pending migrations back until the block at height
+func (sm SchemaMapper) WithUpgradeHeight(height int64) SchemaMapper {
+	sm.UpgradeHeight = height
+	return sm
+}
+
+// Version of the values in the store
+func (sm SchemaMapper) GetVersion(ctx sdk.Context) int64 {
+	bz := ctx.KVStore(sm.MainKey).Get(schemaVersionKey)
+	if bz == nil {
+		return unversionedSchema
+	}
+	return int64(binary.BigEndian.Uint64(bz))
+}
+
+func (sm SchemaMapper) SetVersion(ctx sdk.Context, version int64) {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, uint64(version))
+	ctx.KVStore(sm.MainKey).Set(schemaVersionKey, bz)
+}
+
+// Whether the block of ctx has to migrate the store
+func (sm SchemaMapper) Due(ctx sdk.Context) bool {
+	return sm.GetVersion(ctx) != SchemaVersion && ctx.BlockHeight() >= sm.UpgradeHeight
+}
+
+// Whether this code can run the block after lastHeight on the committed
+// store of ctx. A store in an older schema has to be migrated by that very
+// block, any block before the migration would run the current handlers on
+// the old layout.
+func (sm SchemaMapper) CheckStart(ctx sdk.Context, lastHeight int64) error {
+	// InitChain records the current version
+	if lastHeight == 0 {
+		return nil
+	}
+	version := sm.GetVersion(ctx)
+	if version > SchemaVersion {
+		return fmt.Errorf("store has schema version %d, newer than %d", version, SchemaVersion)
+	}
+	next := lastHeight + 1
+	if version < SchemaVersion && sm.UpgradeHeight != 0 && sm.UpgradeHeight != next {
+		return fmt.Errorf("store has schema version %d and is migrated at height %d, but the next block is %d", version, sm.UpgradeHeight, next)
+	}
+	return nil
+}
+
+// Apply every migration from the store's version up to SchemaVersion and
+// record each step. Fails on stores written by a newer version, or if a
+// step has no migration registered.
+func (sm SchemaMapper) Migrate(ctx sdk.Context, stores Stores) error {
+	version := sm.GetVersion(ctx)
+	if version > SchemaVersion {
+		return fmt.Errorf("store has schema version %d, newer than %d", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		migration, ok := sm.migrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from schema version %d", version)
+		}
+		err := migration.Migrate(ctx, stores)
+		if err != nil {
+			return fmt.Errorf("migration %s from schema version %d: %v", migration.Name, version, err)
+		}
+		sm.SetVersion(ctx, version + 1)
+	}
+	return nil
+}
+
+// Ballot as stored by the first release, before the status machine and polls
+type ballotV1 struct {
+	Identifier string
+	Owner sdk.Address
+	Challenger sdk.Address
+	Active bool
+	Approve int64
+	Deny int64
+	Bond int64
+	EndApplyBlockStamp int64
+	EndCommitBlockStamp int64
+	EndRevealBlockStamp int64
+}
+
+// Listing as stored by the first release, before bonds were ranked and listings expired
+type listingV1 struct {
+	Identifier string
+	Votes int64
+}
+
+// Voter as encoded into commitment and reveal keys by the first release,
+// which kept one poll per listing
+type voterV1 struct {
+	Owner sdk.Address
+	Identifier string
+}
+
+type voteV1 struct {
+	Choice bool
+	Power int64
+}
+
+// Codec of the first release's stored types. They are registered under the
+// names the current types still use, so the encodings match byte for byte.
+func codecV1() *amino.Codec {
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(listingV1{}, "types/Listing", nil)
+	cdc.RegisterConcrete(voterV1{}, "types/Voter", nil)
+	cdc.RegisterConcrete(voteV1{}, "types/Vote", nil)
+	cdc.RegisterConcrete(ballotV1{}, "types/Ballot", nil)
+	return cdc
+}
+
+type kvPair struct {
+	key []byte
+	value []byte
+}
+
+// Read every entry of a store and delete it. All entries are read first,
+// the store cannot be written while iterating.
+func drain(store sdk.KVStore) []kvPair {
+	pairs := []kvPair{}
+	iter := store.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		pairs = append(pairs, kvPair{
+			key: iter.Key(),
+			value: iter.Value(),
+		})
+	}
+	iter.Close()
+
+	for _, pair := range pairs {
+		store.Delete(pair.key)
+	}
+	return pairs
+}
+
+// The first release ran a single registry with block deadlines and kept its
+// values under the bare identifier or encoded voter. Move them under the
+// default registry, which is created with the app's default params, and give
+// them their current layout:
+//  - ballots get the status their flags described and a poll ID if challenged
+//  - listings keep their votes and are bonded with their ballot's bond. They
+//    never expired, and keep doing so until renewed.
+//  - commitments and reveals are keyed by the ballot's poll, commitments
+//    under their voter's prefix. Commitments keep the first release's form,
+//    which the reveal handler accepts on its polls.
+//  - that release recorded no claims, and paid them out once the ballot was
+//    applied. Reveals on polls still open are left pending a claim, those on
+//    applied ballots are entered in the claim ledger as paid.
+func migrateFirstRelease(ctx sdk.Context, stores Stores) error {
+	registry, ok := stores.Registries.GetRegistry(ctx, types.DefaultRegistryID)
+	if !ok {
+		registry = types.Registry{
+			ID: types.DefaultRegistryID,
+			Params: stores.DefaultParams,
+		}
+		stores.Registries.SetRegistry(ctx, registry)
+	}
+	if !stores.Registries.IsStakingDenom(ctx, registry.Params.Denom) {
+		stores.Registries.SetStakingDenoms(ctx, append(stores.Registries.GetStakingDenoms(ctx), registry.Params.Denom))
+	}
+
+	cdc := codecV1()
+	bm := stores.Ballots.ForRegistry(types.DefaultRegistryID).WithListingLen(0)
+	ballotPairs := drain(ctx.KVStore(bm.BallotKey))
+	listingPairs := drain(ctx.KVStore(bm.ListingKey))
+	commitPairs := drain(ctx.KVStore(bm.CommitKey))
+	revealPairs := drain(ctx.KVStore(bm.RevealKey))
+
+	listings := []listingV1{}
+	listed := map[string]bool{}
+	for _, pair := range listingPairs {
+		listing := listingV1{}
+		err := cdc.UnmarshalBinary(pair.value, &listing)
+		if err != nil {
+			return fmt.Errorf("undecodable listing at %X: %v", pair.key, err)
+		}
+		listings = append(listings, listing)
+		listed[listing.Identifier] = true
+	}
+
+	bonds := map[string]int64{}
+	voting := map[string]bool{}
+	for _, pair := range ballotPairs {
+		old := ballotV1{}
+		err := cdc.UnmarshalBinary(pair.value, &old)
+		if err != nil {
+			return fmt.Errorf("undecodable ballot at %X: %v", pair.key, err)
+		}
+		ballot := ballotFromV1(old, listed[old.Identifier], registry.Params.DispensationPct)
+		bm.SetBallot(ctx, ballot)
+		bonds[old.Identifier] = old.Bond
+		voting[old.Identifier] = ballot.Status == types.StatusCommit
+	}
+
+	for _, listing := range listings {
+		bm.AddListing(ctx, listing.Identifier, listing.Votes, bonds[listing.Identifier])
+	}
+
+	for _, pair := range commitPairs {
+		old := voterV1{}
+		err := cdc.UnmarshalBinary(pair.key, &old)
+		if err != nil {
+			return fmt.Errorf("undecodable commitment key %X: %v", pair.key, err)
+		}
+		bm.SetCommitment(ctx, voterFromV1(old), pair.value)
+	}
+
+	for _, pair := range revealPairs {
+		old := voterV1{}
+		err := cdc.UnmarshalBinary(pair.key, &old)
+		if err != nil {
+			return fmt.Errorf("undecodable reveal key %X: %v", pair.key, err)
+		}
+		vote := voteV1{}
+		err = cdc.UnmarshalBinary(pair.value, &vote)
+		if err != nil {
+			return fmt.Errorf("undecodable reveal at %X: %v", pair.key, err)
+		}
+		migrated := types.Vote{
+			Choice: vote.Choice,
+			Power: vote.Power,
+		}
+		if !voting[old.Identifier] {
+			// Paid and Height are unknown, the first release kept no ledger
+			bm.SetClaim(ctx, voterFromV1(old), types.Claim{Vote: migrated})
+			continue
+		}
+		bm.SetReveal(ctx, voterFromV1(old), migrated)
+		bm.AddPendingClaim(ctx, old.Owner, old.Identifier)
+	}
+	return nil
+}
+
+func voterFromV1(old voterV1) types.Voter {
+	return types.Voter{
+		Owner: old.Owner,
+		Identifier: old.Identifier,
+		PollID: types.FirstReleasePollID(old.Identifier),
+	}
+}
+
+// The first release paid out a challenge when it was applied, leaving the
+// winning voters a pool of the bond less the dispensation. Their claims are
+// migrated as paid, so the pool is too.
+func ballotFromV1(old ballotV1, listed bool, dispPct types.Ratio) types.Ballot {
+	ballot := types.Ballot{
+		Identifier: old.Identifier,
+		Owner: old.Owner,
+		Challenger: old.Challenger,
+		Approve: old.Approve,
+		Deny: old.Deny,
+		Bond: old.Bond,
+		EndApplyBlockStamp: old.EndApplyBlockStamp,
+		EndCommitBlockStamp: old.EndCommitBlockStamp,
+		EndRevealBlockStamp: old.EndRevealBlockStamp,
+		Deadlines: types.BlockDeadlines,
+	}
+	if old.EndCommitBlockStamp != 0 {
+		ballot.PollID = types.FirstReleasePollID(old.Identifier)
+	}
+
+	switch {
+	case old.Active:
+		ballot.Status = types.StatusCommit
+	case old.EndCommitBlockStamp != 0:
+		ballot.Status = types.DecisionStatus(listed)
+		ballot.Settled = true
+		ballot.Pool = old.Bond - dispPct.MulFloor(old.Bond)
+		ballot.PoolPaid = ballot.Pool
+		ballot.PowerClaimed = old.Deny
+		if listed {
+			ballot.PowerClaimed = old.Approve
+		}
+	case listed:
+		ballot.Status = types.StatusAccepted
+		ballot.Settled = true
+	default:
+		ballot.Status = types.StatusApplying
+	}
+	return ballot
+}
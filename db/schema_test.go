@@ -0,0 +1,253 @@
+package db
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/abci/types"
+
+	"github.com/tendermint/tmlibs/log"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	"github.com/AdityaSripal/token_curated_registry/utils"
+)
+
+// Write a value the way the first release did, under its bare key
+func setV1(ctx sdk.Context, key sdk.StoreKey, storeKey []byte, value interface{}) {
+	bz, err := codecV1().MarshalBinary(value)
+	if err != nil {
+		panic(err)
+	}
+	ctx.KVStore(key).Set(storeKey, bz)
+}
+
+func voterKeyV1(owner sdk.Address, identifier string) []byte {
+	bz, err := codecV1().MarshalBinary(voterV1{Owner: owner, Identifier: identifier})
+	if err != nil {
+		panic(err)
+	}
+	return bz
+}
+
+func testParams() types.RegistryParams {
+	return types.RegistryParams{
+		Denom: "RegistryCoin",
+		MinDeposit: 100,
+		ApplyLen: 10,
+		CommitLen: 10,
+		RevealLen: 10,
+		DispensationPct: types.NewRatio(1, 2),
+		Deadlines: types.BlockDeadlines,
+	}
+}
+
+func TestMigrateFirstRelease(t *testing.T) {
+	// The account store stands in for the app's main store
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, mainKey, registryKey := SetupMultiStore()
+	cdc := MakeCodec()
+
+	ctx := sdk.NewContext(ms, abci.Header{Height: 50}, false, nil, log.NewNopLogger())
+
+	mapper := NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc)
+	registries := NewRegistryMapper(registryKey, cdc)
+	stores := Stores{
+		Ballots: mapper,
+		Registries: registries,
+		DefaultParams: testParams(),
+	}
+	schema := NewSchemaMapper(mainKey, cdc)
+	owner := utils.GenerateAddress()
+	challenger := utils.GenerateAddress()
+	voter := utils.GenerateAddress()
+
+	// Fixtures for every state a first release ballot could be in
+	setV1(ctx, ballotKey, []byte("applying"), ballotV1{Identifier: "applying", Owner: owner, Bond: 100, EndApplyBlockStamp: 60})
+	setV1(ctx, ballotKey, []byte("listed"), ballotV1{Identifier: "listed", Owner: owner, Bond: 100, EndApplyBlockStamp: 10})
+	setV1(ctx, listKey, []byte("listed"), listingV1{Identifier: "listed"})
+	setV1(ctx, ballotKey, []byte("voting"), ballotV1{
+		Identifier: "voting",
+		Owner: owner,
+		Challenger: challenger,
+		Active: true,
+		Approve: 20,
+		Bond: 100,
+		EndApplyBlockStamp: 10,
+		EndCommitBlockStamp: 45,
+		EndRevealBlockStamp: 55,
+	})
+	ctx.KVStore(commitKey).Set(voterKeyV1(voter, "voting"), types.FirstReleaseCommitment([]byte("salt")))
+	setV1(ctx, revealKey, voterKeyV1(owner, "voting"), voteV1{Choice: true, Power: 20})
+	setV1(ctx, ballotKey, []byte("kept"), ballotV1{
+		Identifier: "kept",
+		Owner: owner,
+		Challenger: challenger,
+		Approve: 30,
+		Bond: 100,
+		EndApplyBlockStamp: 10,
+		EndCommitBlockStamp: 20,
+		EndRevealBlockStamp: 30,
+	})
+	setV1(ctx, listKey, []byte("kept"), listingV1{Identifier: "kept", Votes: 30})
+	setV1(ctx, revealKey, voterKeyV1(voter, "kept"), voteV1{Choice: true, Power: 30})
+	setV1(ctx, ballotKey, []byte("removed"), ballotV1{
+		Identifier: "removed",
+		Owner: owner,
+		Challenger: challenger,
+		Deny: 40,
+		Bond: 100,
+		EndApplyBlockStamp: 10,
+		EndCommitBlockStamp: 20,
+		EndRevealBlockStamp: 30,
+	})
+
+	assert.Equal(t, int64(1), schema.GetVersion(ctx), "Unversioned store not at version 1")
+	assert.True(t, schema.Due(ctx), "Migration of old store not due")
+
+	err := schema.Migrate(ctx, stores)
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion, schema.GetVersion(ctx), "Version not recorded")
+	assert.False(t, schema.Due(ctx), "Migration due after migrating")
+
+	registry, found := registries.GetRegistry(ctx, types.DefaultRegistryID)
+	assert.True(t, found, "Default registry not created")
+	assert.Equal(t, testParams(), registry.Params)
+	assert.True(t, registries.IsStakingDenom(ctx, "RegistryCoin"), "Staking denom not recorded")
+
+	bm := mapper.ForRegistry(types.DefaultRegistryID)
+	for _, key := range []sdk.StoreKey{ballotKey, listKey} {
+		assert.Nil(t, ctx.KVStore(key).Get([]byte("listed")), "Bare key left behind")
+	}
+	assert.Nil(t, ctx.KVStore(commitKey).Get(voterKeyV1(voter, "voting")), "Bare commitment key left behind")
+	assert.Nil(t, ctx.KVStore(revealKey).Get(voterKeyV1(voter, "kept")), "Bare reveal key left behind")
+
+	applying := bm.GetBallot(ctx, "applying")
+	assert.Equal(t, types.StatusApplying, applying.Status)
+	assert.Equal(t, types.BlockDeadlines, applying.Deadlines)
+	assert.Equal(t, "", applying.PollID, "Poll ID given to unchallenged ballot")
+
+	listed := bm.GetBallot(ctx, "listed")
+	assert.Equal(t, types.StatusAccepted, listed.Status, "Applied listing not accepted")
+	assert.True(t, listed.Settled, "Applied listing not settled")
+	assert.Equal(t, types.Listing{Identifier: "listed", Bond: 100}, bm.GetListing(ctx, "listed"), "Listing not bonded")
+
+	voting := bm.GetBallot(ctx, "voting")
+	pollID := types.FirstReleasePollID("voting")
+	assert.Equal(t, types.StatusCommit, voting.Status)
+	assert.Equal(t, types.StatusReveal, voting.StatusAt(50), "Commit phase did not end after migration")
+	assert.Equal(t, pollID, voting.PollID)
+	assert.Equal(t, int64(20), voting.Approve)
+	commitment := bm.GetCommitment(ctx, types.Voter{Owner: voter, Identifier: "voting", PollID: pollID})
+	assert.True(t, types.CommitmentMatches(commitment, "voting", pollID, false, []byte("salt")), "Commitment not moved")
+	vote, found := bm.GetReveal(ctx, types.Voter{Owner: owner, Identifier: "voting", PollID: pollID})
+	assert.True(t, found, "Reveal not moved")
+	assert.Equal(t, types.Vote{Choice: true, Power: 20}, vote)
+	assert.Equal(t, []string{"voting"}, bm.PendingClaims(ctx, owner), "Reveal on open poll not pending a claim")
+
+	kept := bm.GetBallot(ctx, "kept")
+	assert.Equal(t, types.StatusAccepted, kept.Status)
+	assert.True(t, kept.Settled, "Paid out challenge not settled")
+	assert.Equal(t, int64(50), kept.Pool, "Pool not the bond less the dispensation")
+	assert.Equal(t, int64(50), kept.PoolPaid, "Pool paid out by the first release left open")
+	assert.Equal(t, int64(30), kept.PowerClaimed)
+
+	// The first release paid claims on applied ballots
+	keptVoter := types.Voter{Owner: voter, Identifier: "kept", PollID: types.FirstReleasePollID("kept")}
+	claim, found := bm.GetClaim(ctx, keptVoter)
+	assert.True(t, found, "Paid reveal not entered in the claim ledger")
+	assert.Equal(t, types.Vote{Choice: true, Power: 30}, claim.Vote)
+	_, found = bm.GetReveal(ctx, keptVoter)
+	assert.False(t, found, "Paid reveal left claimable")
+	assert.Equal(t, 0, len(bm.PendingClaims(ctx, voter)), "Paid reveal pending a claim")
+
+	removed := bm.GetBallot(ctx, "removed")
+	assert.Equal(t, types.StatusRejected, removed.Status)
+	assert.True(t, removed.Settled)
+
+	// Listings are ranked by votes and bond, and never expire
+	assert.Equal(t, []types.Listing{
+		{Identifier: "kept", Votes: 30, Bond: 100},
+		{Identifier: "listed", Bond: 100},
+	}, bm.TopListings(ctx, 10))
+	assert.Equal(t, 0, len(bm.ExpiredListings(ctx, 1000)), "Migrated listing expires")
+
+	// Stores in the current layout are not touched again
+	assert.Nil(t, schema.Migrate(ctx, stores))
+	assert.Equal(t, SchemaVersion, schema.GetVersion(ctx))
+}
+
+func TestMigrateUndecodable(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, mainKey, registryKey := SetupMultiStore()
+	cdc := MakeCodec()
+
+	ctx := sdk.NewContext(ms, abci.Header{Height: 50}, false, nil, log.NewNopLogger())
+	stores := Stores{
+		Ballots: NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc),
+		Registries: NewRegistryMapper(registryKey, cdc),
+		DefaultParams: testParams(),
+	}
+	schema := NewSchemaMapper(mainKey, cdc)
+
+	ctx.KVStore(ballotKey).Set([]byte("garbage"), []byte{0x01})
+	assert.NotNil(t, schema.Migrate(ctx, stores), "Undecodable ballot migrated")
+	assert.Equal(t, int64(1), schema.GetVersion(ctx), "Version recorded for failed migration")
+}
+
+func TestSchemaUpgradeHeight(t *testing.T) {
+	ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, mainKey, registryKey := SetupMultiStore()
+	cdc := MakeCodec()
+
+	stores := Stores{
+		Ballots: NewBallotMapper(BallotKeys{Listings: listKey, Ballots: ballotKey, Commits: commitKey, Reveals: revealKey, Archive: archiveKey, History: historyKey}, cdc),
+		Registries: NewRegistryMapper(registryKey, cdc),
+		DefaultParams: testParams(),
+	}
+	schema := NewSchemaMapper(mainKey, cdc).WithUpgradeHeight(10)
+
+	ctx := sdk.NewContext(ms, abci.Header{Height: 9}, false, nil, log.NewNopLogger())
+	assert.False(t, schema.Due(ctx), "Migration due before upgrade height")
+	ctx = sdk.NewContext(ms, abci.Header{Height: 10}, false, nil, log.NewNopLogger())
+	assert.True(t, schema.Due(ctx), "Migration not due at upgrade height")
+
+	// Every step needs a migration
+	unregistered := SchemaMapper{MainKey: mainKey, Cdc: cdc}
+	assert.NotNil(t, unregistered.Migrate(ctx, stores), "Migrated without a registered migration")
+	assert.Equal(t, int64(1), unregistered.GetVersion(ctx), "Version recorded without migrating")
+
+	// A registered migration replaces the default one and runs before its step is recorded
+	var steps []int64
+	schema = schema.WithMigration(Migration{From: 1, Name: "first", Migrate: func(ctx sdk.Context, stores Stores) error {
+		steps = append(steps, schema.GetVersion(ctx))
+		return nil
+	}})
+	assert.Nil(t, schema.Migrate(ctx, stores))
+	assert.Equal(t, []int64{1}, steps)
+	assert.False(t, schema.Due(ctx))
+
+	// Stores written by newer code cannot be read
+	schema.SetVersion(ctx, SchemaVersion + 1)
+	assert.True(t, schema.Due(ctx), "Newer store not flagged")
+	assert.NotNil(t, schema.Migrate(ctx, stores), "Newer store migrated")
+}
+
+func TestSchemaCheckStart(t *testing.T) {
+	ms, _, _, _, _, _, _, mainKey, _ := SetupMultiStore()
+	cdc := MakeCodec()
+
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil, log.NewNopLogger())
+	schema := NewSchemaMapper(mainKey, cdc)
+
+	// Chains that have not started yet are initialised in the current layout
+	assert.Nil(t, schema.CheckStart(ctx, 0))
+
+	// Old stores start if the next block migrates them
+	assert.Nil(t, schema.CheckStart(ctx, 20), "Refused to migrate in the next block")
+	assert.Nil(t, schema.WithUpgradeHeight(21).CheckStart(ctx, 20), "Refused upgrade at the next block")
+	assert.NotNil(t, schema.WithUpgradeHeight(30).CheckStart(ctx, 20), "Started old store before its upgrade height")
+	assert.NotNil(t, schema.WithUpgradeHeight(10).CheckStart(ctx, 20), "Started old store past its upgrade height")
+
+	// Current stores start whatever the upgrade height
+	schema.SetVersion(ctx, SchemaVersion)
+	assert.Nil(t, schema.WithUpgradeHeight(30).CheckStart(ctx, 20))
+
+	schema.SetVersion(ctx, SchemaVersion + 1)
+	assert.NotNil(t, schema.CheckStart(ctx, 20), "Started store of newer code")
+}
@@ -10,22 +10,28 @@ import (
 	"github.com/cosmos/cosmos-sdk/x/auth"
 )
 
-func SetupMultiStore() (sdk.MultiStore, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey) {
+func SetupMultiStore() (sdk.MultiStore, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey, *sdk.KVStoreKey) {
 	db := dbm.NewMemDB()
 	listKey := sdk.NewKVStoreKey("ListKey")
 	ballotKey := sdk.NewKVStoreKey("BallotKey")
 	commitKey := sdk.NewKVStoreKey("CommitKey")
 	revealKey := sdk.NewKVStoreKey("RevealKey")
+	archiveKey := sdk.NewKVStoreKey("ArchiveKey")
+	historyKey := sdk.NewKVStoreKey("HistoryKey")
 	accountKey := sdk.NewKVStoreKey("AccountKey")
+	registryKey := sdk.NewKVStoreKey("RegistryKey")
 	ms := store.NewCommitMultiStore(db)
 	ms.MountStoreWithDB(listKey, sdk.StoreTypeIAVL, db)
 	ms.MountStoreWithDB(ballotKey, sdk.StoreTypeIAVL, db)
 	ms.MountStoreWithDB(commitKey, sdk.StoreTypeIAVL, db)
 	ms.MountStoreWithDB(revealKey, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(archiveKey, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(historyKey, sdk.StoreTypeIAVL, db)
 	ms.MountStoreWithDB(accountKey, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(registryKey, sdk.StoreTypeIAVL, db)
 
 	ms.LoadLatestVersion()
-	return ms, listKey, ballotKey, commitKey, revealKey, accountKey
+	return ms, listKey, ballotKey, commitKey, revealKey, archiveKey, historyKey, accountKey, registryKey
 }
 
 func MakeCodec() *amino.Codec {
@@ -0,0 +1,249 @@
+// In-process harness that drives a RegistryApp block by block, so
+// integration tests can script full listing lifecycles without building
+// genesis files, signatures and sequences by hand.
+package testutil
+
+import (
+	"testing"
+
+	"github.com/AdityaSripal/token_curated_registry/app"
+	dbl "github.com/AdityaSripal/token_curated_registry/db"
+	"github.com/AdityaSripal/token_curated_registry/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/wire"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/go-crypto"
+	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
+)
+
+const ChainID = "registry-test"
+
+// Params of the default registry used when a test does not need its own
+func DefaultParams() types.RegistryParams {
+	return types.RegistryParams{
+		Denom: types.DefaultDenom,
+		MinDeposit: 100,
+		ApplyLen: 10,
+		CommitLen: 10,
+		RevealLen: 10,
+		DispensationPct: types.NewRatio(1, 2),
+		Quorum: types.NewRatio(1, 2),
+		Deadlines: types.BlockDeadlines,
+	}
+}
+
+// Key the harness signs with and the address it controls
+type Account struct {
+	Key crypto.PrivKey
+	Address sdk.Address
+}
+
+type Harness struct {
+	t testing.TB
+
+	App *app.RegistryApp
+	// Header of the block currently open for delivery
+	Header abci.Header
+	// Header time added by NextBlock
+	BlockTime int64
+	// Tags emitted by the EndBlocker of the last finished block
+	EndBlockTags sdk.Tags
+	// Registries created at genesis. Without any the app creates the
+	// default registry with the harness params.
+	Registries []types.Registry
+
+	params types.RegistryParams
+	genesis []*types.GenesisAccount
+	started bool
+}
+
+// New harness around a fresh app on an in-memory db. The default registry
+// gets params. Accounts created before the first block are funded at genesis.
+func NewHarness(t testing.TB, params types.RegistryParams) *Harness {
+	return &Harness{
+		t: t,
+		App: app.NewRegistryApp(log.NewNopLogger(), dbm.NewMemDB(), params),
+		Header: abci.Header{ChainID: ChainID},
+		BlockTime: 5,
+		params: params,
+	}
+}
+
+// New key holding amount of the default registry's denom
+func (h *Harness) NewAccount(amount int64) Account {
+	key := crypto.GenPrivKeyEd25519()
+	acc := Account{
+		Key: key,
+		Address: key.PubKey().Address(),
+	}
+
+	base := auth.NewBaseAccountWithAddress(acc.Address)
+	base.SetCoins(sdk.Coins{h.Coin(amount)})
+	if !h.started {
+		h.genesis = append(h.genesis, types.NewGenesisAccount(&base))
+		return acc
+	}
+
+	// Accounts made mid-chain are written into the open block
+	h.App.AccountMapper().SetAccount(h.Context(), &base)
+	return acc
+}
+
+// Coin of the default registry's denom
+func (h *Harness) Coin(amount int64) sdk.Coin {
+	return sdk.Coin{
+		Denom: h.params.Denom,
+		Amount: amount,
+	}
+}
+
+// Run InitChain with the accounts created so far and open block 1.
+// Deliver and the block methods start the chain on first use.
+func (h *Harness) Start() {
+	if h.started {
+		return
+	}
+	h.started = true
+
+	genesisState := types.GenesisState{
+		Accounts: h.genesis,
+		Registries: h.Registries,
+	}
+	stateBytes, err := wire.MarshalJSONIndent(app.MakeCodec(), genesisState)
+	require.Nil(h.t, err)
+
+	h.App.InitChain(abci.RequestInitChain{Validators: []abci.Validator{}, AppStateBytes: stateBytes})
+	h.App.Commit()
+
+	h.Header.Height = 1
+	h.Header.Time = h.BlockTime
+	h.App.BeginBlock(abci.RequestBeginBlock{Header: h.Header})
+}
+
+// Sign msg by signers in order and deliver it in the open block
+func (h *Harness) Deliver(msg sdk.Msg, signers ...Account) sdk.Result {
+	return h.App.Deliver(h.sign(msg, signers))
+}
+
+// Sign msg by signers in order and run it through CheckTx, as the mempool would
+func (h *Harness) Check(msg sdk.Msg, signers ...Account) sdk.Result {
+	return h.App.Check(h.sign(msg, signers))
+}
+
+// Tx of msg signed with the signers' sequences in the open block
+func (h *Harness) sign(msg sdk.Msg, signers []Account) auth.StdTx {
+	h.Start()
+	ctx := h.Context()
+
+	sequences := make([]int64, len(signers))
+	for i, signer := range signers {
+		if acc := h.App.AccountMapper().GetAccount(ctx, signer.Address); acc != nil {
+			sequences[i] = acc.GetSequence()
+		}
+	}
+
+	signBytes := types.StdSignBytes(h.Header.ChainID, sequences, auth.StdFee{}, msg)
+	sigs := make([]auth.StdSignature, len(signers))
+	for i, signer := range signers {
+		sigs[i] = auth.StdSignature{
+			PubKey: signer.Key.PubKey(),
+			Signature: signer.Key.Sign(signBytes),
+			Sequence: sequences[i],
+		}
+	}
+
+	return auth.NewStdTx(msg, auth.StdFee{}, sigs)
+}
+
+// Finish the open block and open the next one BlockTime later
+func (h *Harness) NextBlock() {
+	h.BlockAt(h.Header.Height+1, h.Header.Time+h.BlockTime)
+}
+
+// Finish the open block and open one at height and time, skipping the
+// blocks in between. Both must lie after the open block.
+func (h *Harness) BlockAt(height int64, time int64) {
+	h.Start()
+	require.True(h.t, height > h.Header.Height, "Block %d is not after %d", height, h.Header.Height)
+	require.True(h.t, time >= h.Header.Time, "Block time %d is before %d", time, h.Header.Time)
+
+	res := h.App.EndBlock(abci.RequestEndBlock{})
+	h.EndBlockTags = res.Tags
+	h.App.Commit()
+
+	h.Header.Height = height
+	h.Header.Time = time
+	h.App.BeginBlock(abci.RequestBeginBlock{Header: h.Header})
+}
+
+// Open blocks one at a time until height is reached, running every EndBlocker on the way
+func (h *Harness) AdvanceTo(height int64) {
+	h.Start()
+	for h.Header.Height < height {
+		h.NextBlock()
+	}
+}
+
+// Open n more blocks one at a time
+func (h *Harness) AdvanceBlocks(n int64) {
+	h.AdvanceTo(h.Header.Height + n)
+}
+
+// Context of the open block. Writes land in the block's state.
+func (h *Harness) Context() sdk.Context {
+	h.Start()
+	return h.App.NewContext(false, h.Header)
+}
+
+// Keeper scoped to the registry with its stored params
+func (h *Harness) Keeper(registry string) dbl.BallotMapper {
+	stored, ok := h.App.RegistryMapper().GetRegistry(h.Context(), registry)
+	require.True(h.t, ok, "Unknown registry %s", registry)
+	return h.App.BallotMapper().ForParams(stored)
+}
+
+// Ballot of identifier on the default registry
+func (h *Harness) Ballot(identifier string) types.Ballot {
+	return h.Keeper(types.DefaultRegistryID).GetBallot(h.Context(), identifier)
+}
+
+// Listing of identifier on the default registry
+func (h *Harness) Listing(identifier string) types.Listing {
+	return h.Keeper(types.DefaultRegistryID).GetListing(h.Context(), identifier)
+}
+
+// Balance of the account in the default registry's denom
+func (h *Harness) Balance(acc Account) int64 {
+	account := h.App.AccountMapper().GetAccount(h.Context(), acc.Address)
+	if account == nil {
+		return 0
+	}
+	return account.GetCoins().AmountOf(h.params.Denom)
+}
+
+func (h *Harness) RequireOK(res sdk.Result) {
+	require.True(h.t, res.IsOK(), "Unexpected failure: %s", res.Log)
+}
+
+// Require res failed with a registry error code
+func (h *Harness) RequireCode(res sdk.Result, code sdk.CodeType) {
+	require.Equal(h.t, sdk.ToABCICode(types.DefaultCodespace, code), res.Code, res.Log)
+}
+
+func (h *Harness) RequireBalance(acc Account, amount int64) {
+	require.Equal(h.t, amount, h.Balance(acc), "Balance of %s", acc.Address)
+}
+
+// Require tags carry key with value
+func (h *Harness) RequireTag(tags sdk.Tags, key string, value string) {
+	for _, tag := range tags {
+		if string(tag.Key) == key {
+			require.Equal(h.t, value, string(tag.Value), "Value of tag %s", key)
+			return
+		}
+	}
+	require.Fail(h.t, "Missing tag", key)
+}
@@ -0,0 +1,283 @@
+package types
+
+import (
+	"fmt"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Codespace of all errors returned by the registry. Codes within it are
+// stable and must never be reassigned.
+const (
+	DefaultCodespace sdk.CodespaceType = 10
+
+	CodeInvalidBond           sdk.CodeType = 101
+	CodeInvalidChallenge      sdk.CodeType = 102
+	CodeInvalidIdentifier     sdk.CodeType = 103
+	CodeVoteMismatch          sdk.CodeType = 106
+	CodeUnknownCandidate      sdk.CodeType = 108
+	CodeCandidateExists       sdk.CodeType = 110
+	CodeAlreadyChallenged     sdk.CodeType = 111
+	CodeNotCommitPhase        sdk.CodeType = 112
+	CodeNotRevealPhase        sdk.CodeType = 113
+	CodeChallengeBondTooLow   sdk.CodeType = 115
+	CodeChallengeBondMismatch sdk.CodeType = 116
+	CodeApplyPhaseNotEnded    sdk.CodeType = 120
+	CodeRevealPhaseNotEnded   sdk.CodeType = 121
+	CodeInvalidTransition     sdk.CodeType = 122
+	CodeDuplicateVote         sdk.CodeType = 128
+	CodeBallotNotApplied      sdk.CodeType = 130
+	CodeNoVote                sdk.CodeType = 131
+	CodeAlreadyClaimed        sdk.CodeType = 132
+	CodeNotAppealable         sdk.CodeType = 140
+	CodeNotParty              sdk.CodeType = 141
+	CodeAppealBondTooLow      sdk.CodeType = 142
+	CodeAppealPhaseNotEnded   sdk.CodeType = 143
+	CodeAwaitingCouncil       sdk.CodeType = 144
+	CodeNoCouncilReview       sdk.CodeType = 145
+	CodeNotCouncil            sdk.CodeType = 146
+	CodeInvalidRegistry       sdk.CodeType = 150
+	CodeUnknownRegistry       sdk.CodeType = 151
+	CodeRegistryExists        sdk.CodeType = 152
+	CodeInvalidParams         sdk.CodeType = 153
+	CodeInvalidDelegation     sdk.CodeType = 160
+	CodeNoDelegation          sdk.CodeType = 161
+	CodeNotListed             sdk.CodeType = 170
+	CodeNotListingOwner       sdk.CodeType = 171
+	CodeNoExpiry              sdk.CodeType = 172
+	CodeRenewalTooEarly       sdk.CodeType = 173
+)
+
+// Default message for each registry error code
+func CodeToDefaultMsg(code sdk.CodeType) string {
+	switch code {
+	case CodeInvalidBond:
+		return "Bond must be a positive amount of the registry's staking denom"
+	case CodeInvalidChallenge:
+		return "Challenge reason or evidence is invalid"
+	case CodeInvalidIdentifier:
+		return "Listing identifier is empty or reserved"
+	case CodeVoteMismatch:
+		return "Vote does not match commitment"
+	case CodeUnknownCandidate:
+		return "Candidate with given identifier does not exist"
+	case CodeCandidateExists:
+		return "Candidate already exists"
+	case CodeAlreadyChallenged:
+		return "Candidate has already been challenged"
+	case CodeNotCommitPhase:
+		return "Candidate not in commit phase"
+	case CodeNotRevealPhase:
+		return "Candidate not in reveal phase"
+	case CodeChallengeBondTooLow:
+		return "Must match candidate bond to challenge"
+	case CodeChallengeBondMismatch:
+		return "Must match candidate's bond"
+	case CodeApplyPhaseNotEnded:
+		return "Cannot apply until application phase ends"
+	case CodeRevealPhaseNotEnded:
+		return "Cannot apply until reveal phase ends"
+	case CodeInvalidTransition:
+		return "Ballot cannot make this move from its current status"
+	case CodeDuplicateVote:
+		return "Cannot vote more than once"
+	case CodeBallotNotApplied:
+		return "Cannot claim reward until after ballot vote is applied"
+	case CodeNoVote:
+		return "No revealed vote to claim"
+	case CodeAlreadyClaimed:
+		return "Reward already claimed"
+	case CodeNotAppealable:
+		return "Ballot is not open for appeal"
+	case CodeNotParty:
+		return "Only the owner or challenger can appeal"
+	case CodeAppealBondTooLow:
+		return "Appeal bond too low"
+	case CodeAppealPhaseNotEnded:
+		return "Cannot apply until appeal phase ends"
+	case CodeAwaitingCouncil:
+		return "Appeal is awaiting a council decision"
+	case CodeNoCouncilReview:
+		return "Ballot is not awaiting a council decision"
+	case CodeNotCouncil:
+		return "Decision must be signed by a majority of the council"
+	case CodeInvalidRegistry:
+		return "Registry ID must be 1 to 32 lowercase letters, digits, - or _"
+	case CodeUnknownRegistry:
+		return "Registry with given ID does not exist"
+	case CodeRegistryExists:
+		return "Registry already exists"
+	case CodeInvalidParams:
+		return "Registry parameters are invalid"
+	case CodeInvalidDelegation:
+		return "Voting power must be delegated to another address"
+	case CodeNoDelegation:
+		return "No voting power delegated on this registry"
+	case CodeNotListed:
+		return "Listing with given identifier is not in the registry"
+	case CodeNotListingOwner:
+		return "Only the owner of the listing can do this"
+	case CodeNoExpiry:
+		return "Listings in this registry do not expire"
+	case CodeRenewalTooEarly:
+		return "Listing already runs for more than one listing lifetime"
+	default:
+		return fmt.Sprintf("Unknown code %d", code)
+	}
+}
+
+//----------------------------------------
+// Error constructors
+
+func ErrInvalidBond(msg string) sdk.Error {
+	return newError(CodeInvalidBond, msg)
+}
+
+func ErrInvalidChallenge(msg string) sdk.Error {
+	return newError(CodeInvalidChallenge, msg)
+}
+
+func ErrInvalidIdentifier(msg string) sdk.Error {
+	return newError(CodeInvalidIdentifier, msg)
+}
+
+func ErrVoteMismatch(msg string) sdk.Error {
+	return newError(CodeVoteMismatch, msg)
+}
+
+func ErrUnknownCandidate(msg string) sdk.Error {
+	return newError(CodeUnknownCandidate, msg)
+}
+
+func ErrCandidateExists(msg string) sdk.Error {
+	return newError(CodeCandidateExists, msg)
+}
+
+func ErrAlreadyChallenged(msg string) sdk.Error {
+	return newError(CodeAlreadyChallenged, msg)
+}
+
+func ErrNotCommitPhase(msg string) sdk.Error {
+	return newError(CodeNotCommitPhase, msg)
+}
+
+func ErrNotRevealPhase(msg string) sdk.Error {
+	return newError(CodeNotRevealPhase, msg)
+}
+
+func ErrChallengeBondTooLow(msg string) sdk.Error {
+	return newError(CodeChallengeBondTooLow, msg)
+}
+
+func ErrChallengeBondMismatch(msg string) sdk.Error {
+	return newError(CodeChallengeBondMismatch, msg)
+}
+
+func ErrApplyPhaseNotEnded(msg string) sdk.Error {
+	return newError(CodeApplyPhaseNotEnded, msg)
+}
+
+func ErrRevealPhaseNotEnded(msg string) sdk.Error {
+	return newError(CodeRevealPhaseNotEnded, msg)
+}
+
+func ErrInvalidTransition(msg string) sdk.Error {
+	return newError(CodeInvalidTransition, msg)
+}
+
+func ErrDuplicateVote(msg string) sdk.Error {
+	return newError(CodeDuplicateVote, msg)
+}
+
+func ErrBallotNotApplied(msg string) sdk.Error {
+	return newError(CodeBallotNotApplied, msg)
+}
+
+func ErrNoVote(msg string) sdk.Error {
+	return newError(CodeNoVote, msg)
+}
+
+func ErrAlreadyClaimed(msg string) sdk.Error {
+	return newError(CodeAlreadyClaimed, msg)
+}
+
+func ErrNotAppealable(msg string) sdk.Error {
+	return newError(CodeNotAppealable, msg)
+}
+
+func ErrNotParty(msg string) sdk.Error {
+	return newError(CodeNotParty, msg)
+}
+
+func ErrAppealBondTooLow(msg string) sdk.Error {
+	return newError(CodeAppealBondTooLow, msg)
+}
+
+func ErrAppealPhaseNotEnded(msg string) sdk.Error {
+	return newError(CodeAppealPhaseNotEnded, msg)
+}
+
+func ErrAwaitingCouncil(msg string) sdk.Error {
+	return newError(CodeAwaitingCouncil, msg)
+}
+
+func ErrNoCouncilReview(msg string) sdk.Error {
+	return newError(CodeNoCouncilReview, msg)
+}
+
+func ErrNotCouncil(msg string) sdk.Error {
+	return newError(CodeNotCouncil, msg)
+}
+
+func ErrInvalidRegistry(msg string) sdk.Error {
+	return newError(CodeInvalidRegistry, msg)
+}
+
+func ErrUnknownRegistry(msg string) sdk.Error {
+	return newError(CodeUnknownRegistry, msg)
+}
+
+func ErrRegistryExists(msg string) sdk.Error {
+	return newError(CodeRegistryExists, msg)
+}
+
+func ErrInvalidParams(msg string) sdk.Error {
+	return newError(CodeInvalidParams, msg)
+}
+
+func ErrInvalidDelegation(msg string) sdk.Error {
+	return newError(CodeInvalidDelegation, msg)
+}
+
+func ErrNoDelegation(msg string) sdk.Error {
+	return newError(CodeNoDelegation, msg)
+}
+
+func ErrNotListed(msg string) sdk.Error {
+	return newError(CodeNotListed, msg)
+}
+
+func ErrNotListingOwner(msg string) sdk.Error {
+	return newError(CodeNotListingOwner, msg)
+}
+
+func ErrNoExpiry(msg string) sdk.Error {
+	return newError(CodeNoExpiry, msg)
+}
+
+func ErrRenewalTooEarly(msg string) sdk.Error {
+	return newError(CodeRenewalTooEarly, msg)
+}
+
+//----------------------------------------
+
+func msgOrDefaultMsg(msg string, code sdk.CodeType) string {
+	if msg != "" {
+		return msg
+	}
+	return CodeToDefaultMsg(code)
+}
+
+func newError(code sdk.CodeType, msg string) sdk.Error {
+	msg = msgOrDefaultMsg(msg, code)
+	return sdk.NewError(DefaultCodespace, code, msg)
+}
@@ -8,6 +8,9 @@ import (
 
 type GenesisState struct {
 	Accounts []*GenesisAccount `json:"accounts"`
+	Registries []Registry `json:"registries"`
+	// Denoms registries may stake in. Defaults to the default registry's denom
+	StakingDenoms []string `json:"staking_denoms"`
 }
 
 // GenesisAccount doesn't need pubkey or sequence
@@ -1,26 +1,52 @@
 package types
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	amino "github.com/tendermint/go-amino"
 )
 
 const (
-	TokenName = "RegistryCoin"
+	// Staking denom of the default registry and of mappers that are not given one
+	DefaultDenom = "RegistryCoin"
+
+	// Bounds on challenge justification stored with each ballot
+	MaxReasonLength = 512
+	MaxEvidenceLength = 256
+
+	// Most ballots paid out by one ClaimAllRewardsMsg
+	MaxClaimsPerTx = 20
 )
 
+// Implemented by every msg that acts on a single registry
+type RegistryMsg interface {
+	sdk.Msg
+	GetRegistry() string
+}
+
+// Implemented by registry msgs that post coins. The stake must be in one of
+// the chain's staking denoms, which ValidateBasic cannot check.
+type StakingMsg interface {
+	RegistryMsg
+	GetStake() sdk.Coin
+}
+
 // ===================================================================================================================================
 
 type DeclareCandidacyMsg struct {
 	Owner sdk.Address
+	Registry string
 	Identifier string
 	Bond sdk.Coin
 }
 
-func NewDeclareCandidacyMsg(owner sdk.Address, identifier string, bond sdk.Coin) DeclareCandidacyMsg  {
+func NewDeclareCandidacyMsg(owner sdk.Address, registry string, identifier string, bond sdk.Coin) DeclareCandidacyMsg  {
 	return DeclareCandidacyMsg{
 		Owner: owner,
+		Registry: registry,
 		Identifier: identifier,
 		Bond: bond,
 	}
@@ -31,8 +57,14 @@ func (msg DeclareCandidacyMsg) Type() string {
 }
 
 func (msg DeclareCandidacyMsg) ValidateBasic() sdk.Error {
-	if (msg.Bond.Amount <= 0 || msg.Bond.Denom != TokenName) {
-		return sdk.NewError(2, 101, "Must submit a bond in RegistryCoins")
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if (msg.Bond.Amount <= 0 || !ValidDenom(msg.Bond.Denom)) {
+		return ErrInvalidBond("")
+	}
+	if len(msg.Identifier) == 0 || ReservedIdentifier(msg.Identifier) {
+		return ErrInvalidIdentifier("")
 	}
 	return nil
 }
@@ -42,26 +74,41 @@ func (msg DeclareCandidacyMsg) GetSignBytes() []byte {
 	if err != nil {
 		panic(err)
 	}
-	return b
+	return MustSortJSON(b)
 }
 
 func (msg DeclareCandidacyMsg) GetSigners() []sdk.Address {
 	return []sdk.Address{msg.Owner}
 }
 
+func (msg DeclareCandidacyMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+func (msg DeclareCandidacyMsg) GetStake() sdk.Coin {
+	return msg.Bond
+}
+
 // ===================================================================================================================================
 
+// Reason tells voters why the listing is challenged. Evidence is a content hash or URI backing it up.
 type ChallengeMsg struct {
 	Owner sdk.Address
+	Registry string
 	Identifier string
 	Bond sdk.Coin
+	Reason string
+	Evidence string
 }
 
-func NewChallengeMsg(owner sdk.Address, identifier string, bond sdk.Coin) ChallengeMsg {
+func NewChallengeMsg(owner sdk.Address, registry string, identifier string, bond sdk.Coin, reason string, evidence string) ChallengeMsg {
 	return ChallengeMsg{
 		Owner: owner,
+		Registry: registry,
 		Identifier: identifier,
 		Bond: bond,
+		Reason: reason,
+		Evidence: evidence,
 	}
 }
 
@@ -70,8 +117,17 @@ func (msg ChallengeMsg) Type() string {
 }
 
 func (msg ChallengeMsg) ValidateBasic() sdk.Error {
-	if (msg.Bond.Amount <= 0 || msg.Bond.Denom != TokenName) {
-		return sdk.NewError(2, 101, "Must submit a bond in RegistryCoins")
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if (msg.Bond.Amount <= 0 || !ValidDenom(msg.Bond.Denom)) {
+		return ErrInvalidBond("")
+	}
+	if len(msg.Reason) > MaxReasonLength {
+		return ErrInvalidChallenge(fmt.Sprintf("Reason must be at most %d bytes", MaxReasonLength))
+	}
+	if len(msg.Evidence) > MaxEvidenceLength {
+		return ErrInvalidChallenge(fmt.Sprintf("Evidence must be at most %d bytes", MaxEvidenceLength))
 	}
 	return nil
 }
@@ -81,24 +137,34 @@ func (msg ChallengeMsg) GetSignBytes() []byte {
 	if err != nil {
 		panic(err)
 	}
-	return b
+	return MustSortJSON(b)
 }
 
 func (msg ChallengeMsg) GetSigners() []sdk.Address {
 	return []sdk.Address{msg.Owner}
 }
 
+func (msg ChallengeMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+func (msg ChallengeMsg) GetStake() sdk.Coin {
+	return msg.Bond
+}
+
 // ===================================================================================================================================
 
 type CommitMsg struct {
 	Owner sdk.Address
+	Registry string
 	Identifier string
 	Commitment []byte
 }
 
-func NewCommitMsg(owner sdk.Address, identifier string, commitment []byte) CommitMsg {
+func NewCommitMsg(owner sdk.Address, registry string, identifier string, commitment []byte) CommitMsg {
 	return CommitMsg{
 		Owner: owner,
+		Registry: registry,
 		Identifier: identifier,
 		Commitment: commitment,
 	}
@@ -109,6 +175,9 @@ func (msg CommitMsg) Type() string {
 }
 
 func (msg CommitMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
 	return nil
 }
 
@@ -117,26 +186,60 @@ func (msg CommitMsg) GetSignBytes() []byte {
 	if err != nil {
 		panic(err)
 	}
-	return b
+	return MustSortJSON(b)
 }
 
 func (msg CommitMsg) GetSigners() []sdk.Address {
 	return []sdk.Address{msg.Owner}
 }
 
+func (msg CommitMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+var voteCdc = amino.NewCodec()
+
+// Commitment a CommitMsg carries for a vote and nonce: sha256 over the encoded
+// vote followed by the nonce, so it can only be revealed with the vote it was made for
+func VoteCommitment(vote bool, nonce []byte) []byte {
+	hasher := sha256.New()
+	vz, _ := voteCdc.MarshalBinary(vote)
+	hasher.Write(vz)
+	hasher.Write(nonce)
+	return hasher.Sum(nil)
+}
+
+// Commitment the first release checked reveals against: the nonce followed by
+// the hash of nothing. It does not bind the vote.
+func FirstReleaseCommitment(nonce []byte) []byte {
+	return sha256.New().Sum(nonce)
+}
+
+// Whether revealing vote and nonce opens a commitment made on the given poll.
+// Polls migrated from the first release still accept commitments made under
+// its rule, their voters could not have committed any other way.
+func CommitmentMatches(commitment []byte, identifier string, pollID string, vote bool, nonce []byte) bool {
+	if bytes.Equal(VoteCommitment(vote, nonce), commitment) {
+		return true
+	}
+	return pollID == FirstReleasePollID(identifier) && bytes.Equal(FirstReleaseCommitment(nonce), commitment)
+}
+
 // ===================================================================================================================================
 
 type RevealMsg struct {
 	Owner sdk.Address
+	Registry string
 	Identifier string
 	Vote bool
 	Nonce []byte
 	Bond sdk.Coin
 }
 
-func NewRevealMsg(owner sdk.Address, identifier string, vote bool, nonce []byte, bond sdk.Coin) RevealMsg {
+func NewRevealMsg(owner sdk.Address, registry string, identifier string, vote bool, nonce []byte, bond sdk.Coin) RevealMsg {
 	return RevealMsg{
 		Owner: owner,
+		Registry: registry,
 		Identifier: identifier,
 		Vote: vote,
 		Nonce: nonce,
@@ -149,8 +252,11 @@ func (msg RevealMsg) Type() string {
 }
 
 func (msg RevealMsg) ValidateBasic() sdk.Error {
-	if (msg.Bond.Amount <= 0 || msg.Bond.Denom != TokenName) {
-		return sdk.NewError(2, 101, "Must submit a bond in RegistryCoins")
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if (msg.Bond.Amount <= 0 || !ValidDenom(msg.Bond.Denom)) {
+		return ErrInvalidBond("")
 	}
 	return nil
 }
@@ -160,23 +266,33 @@ func (msg RevealMsg) GetSignBytes() []byte {
 	if err != nil {
 		panic(err)
 	}
-	return b
+	return MustSortJSON(b)
 }
 
 func (msg RevealMsg) GetSigners() []sdk.Address {
 	return []sdk.Address{msg.Owner}
 }
 
+func (msg RevealMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+func (msg RevealMsg) GetStake() sdk.Coin {
+	return msg.Bond
+}
+
 // ===================================================================================================================================
 
 type ApplyMsg struct {
 	Owner sdk.Address
+	Registry string
 	Identifier string
 }
 
-func NewApplyMsg(owner sdk.Address, identifier string) ApplyMsg {
+func NewApplyMsg(owner sdk.Address, registry string, identifier string) ApplyMsg {
 	return ApplyMsg{
 		Owner: owner,
+		Registry: registry,
 		Identifier: identifier,
 	}
 }
@@ -186,6 +302,9 @@ func (msg ApplyMsg) Type() string {
 }
 
 func (msg ApplyMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
 	return nil
 }
 
@@ -194,23 +313,29 @@ func (msg ApplyMsg) GetSignBytes() []byte {
 	if err != nil {
 		panic(err)
 	}
-	return b
+	return MustSortJSON(b)
 }
 
 func (msg ApplyMsg) GetSigners() []sdk.Address {
 	return []sdk.Address{msg.Owner}
 }
 
+func (msg ApplyMsg) GetRegistry() string {
+	return msg.Registry
+}
+
 // ===================================================================================================================================
 
 type ClaimRewardMsg struct {
 	Owner sdk.Address
+	Registry string
 	Identifier string
 }
 
-func NewClaimRewardMsg(owner sdk.Address, identifier string) ClaimRewardMsg {
+func NewClaimRewardMsg(owner sdk.Address, registry string, identifier string) ClaimRewardMsg {
 	return ClaimRewardMsg{
 		Owner: owner,
+		Registry: registry,
 		Identifier: identifier,
 	}
 }
@@ -220,6 +345,9 @@ func (msg ClaimRewardMsg) Type() string {
 }
 
 func (msg ClaimRewardMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
 	return nil
 }
 
@@ -228,13 +356,308 @@ func (msg ClaimRewardMsg) GetSignBytes() []byte {
 	if err != nil {
 		panic(err)
 	}
-	return b
+	return MustSortJSON(b)
 }
 
 func (msg ClaimRewardMsg) GetSigners() []sdk.Address {
 	return []sdk.Address{msg.Owner}
 }
 
+func (msg ClaimRewardMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+// ===================================================================================================================================
+
+// Claim on every applied ballot of the registry the owner revealed on, up to MaxClaimsPerTx
+type ClaimAllRewardsMsg struct {
+	Owner sdk.Address
+	Registry string
+}
+
+func NewClaimAllRewardsMsg(owner sdk.Address, registry string) ClaimAllRewardsMsg {
+	return ClaimAllRewardsMsg{
+		Owner: owner,
+		Registry: registry,
+	}
+}
+
+func (msg ClaimAllRewardsMsg) Type() string {
+	return "ClaimAllRewards"
+}
+
+func (msg ClaimAllRewardsMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	return nil
+}
+
+func (msg ClaimAllRewardsMsg) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+func (msg ClaimAllRewardsMsg) GetSigners() []sdk.Address {
+	return []sdk.Address{msg.Owner}
+}
+
+func (msg ClaimAllRewardsMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+// ===================================================================================================================================
+
+// Delegate voting power to a curator on a registry. A reveal by the delegate
+// also votes the delegated power unless the delegator reveals directly.
+// A msg without a delegate revokes the delegation.
+type DelegateVotingMsg struct {
+	Owner sdk.Address
+	Registry string
+	Delegate sdk.Address
+	Power sdk.Coin
+}
+
+func NewDelegateVotingMsg(owner sdk.Address, registry string, delegate sdk.Address, power sdk.Coin) DelegateVotingMsg {
+	return DelegateVotingMsg{
+		Owner: owner,
+		Registry: registry,
+		Delegate: delegate,
+		Power: power,
+	}
+}
+
+func (msg DelegateVotingMsg) Type() string {
+	return "DelegateVoting"
+}
+
+func (msg DelegateVotingMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if len(msg.Delegate) == 0 {
+		return nil
+	}
+	if bytes.Equal(msg.Owner, msg.Delegate) {
+		return ErrInvalidDelegation("Cannot delegate to yourself")
+	}
+	if (msg.Power.Amount <= 0 || !ValidDenom(msg.Power.Denom)) {
+		return ErrInvalidBond("")
+	}
+	return nil
+}
+
+func (msg DelegateVotingMsg) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+func (msg DelegateVotingMsg) GetSigners() []sdk.Address {
+	return []sdk.Address{msg.Owner}
+}
+
+func (msg DelegateVotingMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+func (msg DelegateVotingMsg) GetStake() sdk.Coin {
+	return msg.Power
+}
+
+// ===================================================================================================================================
+
+// Extend an expiring listing by the registry's listing lifetime. Sent by the owner
+type RenewListingMsg struct {
+	Owner sdk.Address
+	Registry string
+	Identifier string
+}
+
+func NewRenewListingMsg(owner sdk.Address, registry string, identifier string) RenewListingMsg {
+	return RenewListingMsg{
+		Owner: owner,
+		Registry: registry,
+		Identifier: identifier,
+	}
+}
+
+func (msg RenewListingMsg) Type() string {
+	return "RenewListing"
+}
+
+func (msg RenewListingMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if len(msg.Identifier) == 0 {
+		return ErrInvalidIdentifier("")
+	}
+	return nil
+}
+
+func (msg RenewListingMsg) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+func (msg RenewListingMsg) GetSigners() []sdk.Address {
+	return []sdk.Address{msg.Owner}
+}
+
+func (msg RenewListingMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+// ===================================================================================================================================
+
+// Appeal a resolved challenge. Sent by the owner or challenger with an escalated bond
+type AppealMsg struct {
+	Owner sdk.Address
+	Registry string
+	Identifier string
+	Bond sdk.Coin
+}
+
+func NewAppealMsg(owner sdk.Address, registry string, identifier string, bond sdk.Coin) AppealMsg {
+	return AppealMsg{
+		Owner: owner,
+		Registry: registry,
+		Identifier: identifier,
+		Bond: bond,
+	}
+}
+
+func (msg AppealMsg) Type() string {
+	return "Appeal"
+}
+
+func (msg AppealMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if (msg.Bond.Amount <= 0 || !ValidDenom(msg.Bond.Denom)) {
+		return ErrInvalidBond("")
+	}
+	return nil
+}
+
+func (msg AppealMsg) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+func (msg AppealMsg) GetSigners() []sdk.Address {
+	return []sdk.Address{msg.Owner}
+}
+
+func (msg AppealMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+func (msg AppealMsg) GetStake() sdk.Coin {
+	return msg.Bond
+}
+
+// ===================================================================================================================================
+
+// Council decision on an appealed challenge, signed by a majority of the council
+type AppealDecisionMsg struct {
+	Signers []sdk.Address
+	Registry string
+	Identifier string
+	Approve bool
+}
+
+func NewAppealDecisionMsg(signers []sdk.Address, registry string, identifier string, approve bool) AppealDecisionMsg {
+	return AppealDecisionMsg{
+		Signers: signers,
+		Registry: registry,
+		Identifier: identifier,
+		Approve: approve,
+	}
+}
+
+func (msg AppealDecisionMsg) Type() string {
+	return "AppealDecision"
+}
+
+func (msg AppealDecisionMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	if len(msg.Signers) == 0 {
+		return ErrNotCouncil("Decision must have at least one signer")
+	}
+	return nil
+}
+
+func (msg AppealDecisionMsg) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+func (msg AppealDecisionMsg) GetSigners() []sdk.Address {
+	return msg.Signers
+}
+
+func (msg AppealDecisionMsg) GetRegistry() string {
+	return msg.Registry
+}
+
+// ===================================================================================================================================
+
+// Create a new registry with its own parameters and listing namespace
+type CreateRegistryMsg struct {
+	Creator sdk.Address
+	Registry string
+	Params RegistryParams
+}
+
+func NewCreateRegistryMsg(creator sdk.Address, registry string, params RegistryParams) CreateRegistryMsg {
+	return CreateRegistryMsg{
+		Creator: creator,
+		Registry: registry,
+		Params: params,
+	}
+}
+
+func (msg CreateRegistryMsg) Type() string {
+	return "CreateRegistry"
+}
+
+func (msg CreateRegistryMsg) ValidateBasic() sdk.Error {
+	if !ValidRegistryID(msg.Registry) {
+		return ErrInvalidRegistry("")
+	}
+	return msg.Params.Validate()
+}
+
+func (msg CreateRegistryMsg) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+func (msg CreateRegistryMsg) GetSigners() []sdk.Address {
+	return []sdk.Address{msg.Creator}
+}
 
 func RegisterAmino(cdc *amino.Codec) {
 	cdc.RegisterConcrete(DeclareCandidacyMsg{}, "types/DeclareCandidacyMsg", nil)
@@ -243,8 +666,19 @@ func RegisterAmino(cdc *amino.Codec) {
 	cdc.RegisterConcrete(RevealMsg{}, "types/RevealMsg", nil)
 	cdc.RegisterConcrete(ApplyMsg{}, "types/ApplyMsg", nil)
 	cdc.RegisterConcrete(ClaimRewardMsg{}, "types/ClaimRewardMsg", nil)
+	cdc.RegisterConcrete(ClaimAllRewardsMsg{}, "types/ClaimAllRewardsMsg", nil)
+	cdc.RegisterConcrete(AppealMsg{}, "types/AppealMsg", nil)
+	cdc.RegisterConcrete(AppealDecisionMsg{}, "types/AppealDecisionMsg", nil)
+	cdc.RegisterConcrete(DelegateVotingMsg{}, "types/DelegateVotingMsg", nil)
+	cdc.RegisterConcrete(RenewListingMsg{}, "types/RenewListingMsg", nil)
+	cdc.RegisterConcrete(CreateRegistryMsg{}, "types/CreateRegistryMsg", nil)
 	cdc.RegisterConcrete(Listing{}, "types/Listing", nil)
+	cdc.RegisterConcrete(ArchivedListing{}, "types/ArchivedListing", nil)
+	cdc.RegisterConcrete(HistoryEvent{}, "types/HistoryEvent", nil)
 	cdc.RegisterConcrete(Voter{}, "types/Voter", nil)
 	cdc.RegisterConcrete(Vote{}, "types/Vote", nil)
+	cdc.RegisterConcrete(Claim{}, "types/Claim", nil)
+	cdc.RegisterConcrete(Delegation{}, "types/Delegation", nil)
 	cdc.RegisterConcrete(Ballot{}, "types/Ballot", nil)
+	cdc.RegisterConcrete(Registry{}, "types/Registry", nil)
 }
\ No newline at end of file
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"strings"
 	"testing"
 	"github.com/stretchr/testify/assert"
 	sdk "github.com/cosmos/cosmos-sdk/types"
@@ -17,10 +18,16 @@ func TestValidMsg(t *testing.T) {
 func TestInvalidDenom(t *testing.T) {
 	msg := GenerateCandidacyMsg()
 
+	// Well formed denoms pass, handlers check them against the registry's params
 	msg.Bond.Denom = "FakeCoin"
-	err := msg.ValidateBasic()
+	assert.Nil(t, msg.ValidateBasic())
+
+	for _, denom := range []string{"", "1coin", "fake coin", strings.Repeat("a", 17)} {
+		msg.Bond.Denom = denom
+		err := msg.ValidateBasic()
 
-	assert.Equal(t, sdk.CodeType(101), err.Code(), err.Error())
+		assert.Equal(t, CodeInvalidBond, err.Code(), denom)
+	}
 }
 
 func TestInvalidAmount(t *testing.T) {
@@ -29,11 +36,111 @@ func TestInvalidAmount(t *testing.T) {
 	msg.Bond.Amount = 0
 	err := msg.ValidateBasic()
 
-	assert.Equal(t, sdk.CodeType(101), err.Code(), err.Error())
+	assert.Equal(t, CodeInvalidBond, err.Code(), err.Error())
 
 	msg.Bond.Amount = -100
 	err = msg.ValidateBasic()
 
-	assert.Equal(t, sdk.CodeType(101), err.Code(), err.Error())
+	assert.Equal(t, CodeInvalidBond, err.Code(), err.Error())
+}
+
+
+func TestChallengeJustificationBounds(t *testing.T) {
+	candidacy := GenerateCandidacyMsg()
+	msg := NewChallengeMsg(candidacy.Owner, DefaultRegistryID, candidacy.Identifier, candidacy.Bond, "Listing is spam", "ipfs://QmEvidence")
+
+	err := msg.ValidateBasic()
+	assert.Nil(t, err)
+
+	msg.Reason = strings.Repeat("a", MaxReasonLength + 1)
+	err = msg.ValidateBasic()
+	assert.Equal(t, CodeInvalidChallenge, err.Code(), err.Error())
+
+	msg.Reason = ""
+	msg.Evidence = strings.Repeat("a", MaxEvidenceLength + 1)
+	err = msg.ValidateBasic()
+	assert.Equal(t, CodeInvalidChallenge, err.Code(), err.Error())
+}
+
+func TestInvalidRegistry(t *testing.T) {
+	msg := GenerateCandidacyMsg()
+
+	for _, id := range []string{"", "Upper", "has/slash", strings.Repeat("a", MaxRegistryIDLength + 1)} {
+		msg.Registry = id
+		err := msg.ValidateBasic()
+
+		assert.Equal(t, CodeInvalidRegistry, err.Code(), id)
+	}
+
+	msg.Registry = "news-sites_2"
+	assert.Nil(t, msg.ValidateBasic())
+}
+
+func TestCreateRegistryParams(t *testing.T) {
+	params := RegistryParams{
+		Denom: DefaultDenom,
+		MinDeposit: 100,
+		ApplyLen: 10,
+		CommitLen: 10,
+		RevealLen: 10,
+		DispensationPct: NewRatio(1, 2),
+		Quorum: NewRatio(1, 2),
+		Deadlines: BlockDeadlines,
+	}
+	msg := NewCreateRegistryMsg(GenerateCandidacyMsg().Owner, "news", params)
+
+	assert.Nil(t, msg.ValidateBasic())
+
+	msg.Params.Quorum = NewRatio(3, 2)
+	err := msg.ValidateBasic()
+	assert.Equal(t, CodeInvalidParams, err.Code(), err.Error())
+
+	msg.Params = params
+	msg.Params.Deadlines = ""
+	err = msg.ValidateBasic()
+	assert.Equal(t, CodeInvalidParams, err.Code(), err.Error())
+
+	msg.Params = params
+	msg.Params.Denom = "x"
+	err = msg.ValidateBasic()
+	assert.Equal(t, CodeInvalidParams, err.Code(), err.Error())
+
+	// Appeal vote without phases
+	msg.Params = params
+	msg.Params.Appeal = AppealParams{AppealLen: 10, BondFactor: 2}
+	err = msg.ValidateBasic()
+	assert.Equal(t, CodeInvalidParams, err.Code(), err.Error())
 }
 
+func TestDelegateVotingMsg(t *testing.T) {
+	owner := GenerateCandidacyMsg().Owner
+	delegate := sdk.Address([]byte("delegate address bytes"))
+	power := sdk.Coin{
+		Denom: DefaultDenom,
+		Amount: 100,
+	}
+
+	assert.Nil(t, NewDelegateVotingMsg(owner, DefaultRegistryID, delegate, power).ValidateBasic())
+
+	// Revoking needs no power
+	assert.Nil(t, NewDelegateVotingMsg(owner, DefaultRegistryID, nil, sdk.Coin{}).ValidateBasic())
+
+	err := NewDelegateVotingMsg(owner, DefaultRegistryID, owner, power).ValidateBasic()
+	assert.Equal(t, CodeInvalidDelegation, err.Code(), err.Error())
+
+	err = NewDelegateVotingMsg(owner, DefaultRegistryID, delegate, sdk.Coin{Denom: DefaultDenom, Amount: 0}).ValidateBasic()
+	assert.Equal(t, CodeInvalidBond, err.Code(), err.Error())
+}
+
+func TestCommitmentMatches(t *testing.T) {
+	nonce := []byte("salt")
+	poll := NewPollID("Listing", 12)
+	assert.True(t, CommitmentMatches(VoteCommitment(true, nonce), "Listing", poll, true, nonce))
+	assert.False(t, CommitmentMatches(VoteCommitment(true, nonce), "Listing", poll, false, nonce), "Commitment opened with the other vote")
+
+	// First release commitments only open on first release polls, with either vote
+	legacy := FirstReleaseCommitment(nonce)
+	assert.False(t, CommitmentMatches(legacy, "Listing", poll, true, nonce), "First release commitment opened on a new poll")
+	assert.True(t, CommitmentMatches(legacy, "Listing", FirstReleasePollID("Listing"), false, nonce))
+	assert.False(t, CommitmentMatches(legacy, "Listing", FirstReleasePollID("Listing"), false, []byte("other")), "Opened with the wrong nonce")
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Exact fraction used for registry percentages and thresholds, so payouts
+// and decisions never depend on float rounding. Amounts derived from a
+// Ratio are rounded down; callers route the remainder explicitly.
+type Ratio struct {
+	Num int64
+	Denom int64
+}
+
+func NewRatio(num int64, denom int64) Ratio {
+	return Ratio{
+		Num: num,
+		Denom: denom,
+	}
+}
+
+// Between 0 and 1 inclusive with a positive denominator
+func (r Ratio) Valid() bool {
+	return r.Denom > 0 && r.Num >= 0 && r.Num <= r.Denom
+}
+
+// amount * r, rounded down
+func (r Ratio) MulFloor(amount int64) int64 {
+	return MulDivFloor(amount, r.Num, r.Denom)
+}
+
+// Whether part / whole is strictly greater than r. Never true for a zero whole.
+func (r Ratio) ExceededBy(part int64, whole int64) bool {
+	if whole <= 0 {
+		return false
+	}
+	lhs := new(big.Int).Mul(big.NewInt(part), big.NewInt(r.Denom))
+	rhs := new(big.Int).Mul(big.NewInt(r.Num), big.NewInt(whole))
+	return lhs.Cmp(rhs) > 0
+}
+
+func (r Ratio) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Denom)
+}
+
+// amount * num / denom rounded down, without overflowing the product
+func MulDivFloor(amount int64, num int64, denom int64) int64 {
+	product := new(big.Int).Mul(big.NewInt(amount), big.NewInt(num))
+	return new(big.Int).Div(product, big.NewInt(denom)).Int64()
+}
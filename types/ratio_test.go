@@ -0,0 +1,29 @@
+package types
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRatio(t *testing.T) {
+	half := NewRatio(1, 2)
+	assert.True(t, half.Valid())
+	assert.False(t, NewRatio(3, 2).Valid())
+	assert.False(t, NewRatio(1, 0).Valid())
+	assert.False(t, NewRatio(-1, 2).Valid())
+
+	// Rounded down
+	assert.Equal(t, int64(50), half.MulFloor(101))
+	assert.Equal(t, int64(33), NewRatio(1, 3).MulFloor(100))
+
+	// No overflow on large amounts
+	assert.Equal(t, int64(math.MaxInt64/2), half.MulFloor(math.MaxInt64))
+
+	// Strictly greater than the threshold
+	assert.False(t, half.ExceededBy(1, 2))
+	assert.True(t, half.ExceededBy(2, 3))
+	assert.False(t, NewRatio(2, 3).ExceededBy(2, 3))
+	assert.False(t, NewRatio(0, 1).ExceededBy(0, 0), "Empty poll exceeded threshold")
+}
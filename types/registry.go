@@ -1,35 +1,339 @@
 package types
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
+// Prefix of the rank index in the listing store. Identifiers may not start with it.
+var RankPrefix = []byte{0x00}
+
+// Prefix of the expiry index in the listing store. Identifiers may not start with it.
+var ExpiryPrefix = []byte{0x01}
+
+// Prefix of the polls of re-challenged listings in the archive store, ahead
+// of the poll ID. Shares RankPrefix's byte, so no identifier starts with it.
+var PollArchivePrefix = []byte{0x00}
+
+// Whether an identifier collides with an index in the listing store
+func ReservedIdentifier(identifier string) bool {
+	return bytes.HasPrefix([]byte(identifier), RankPrefix) || bytes.HasPrefix([]byte(identifier), ExpiryPrefix)
+}
+
+// Votes is the approving weight of the last challenge the listing survived,
+// 0 if it was never challenged. Bond is the owner's stake still locked in it.
 type Listing struct {
 	Identifier string
 	Votes int64
+	Bond int64
+	// Height, or time for time deadlines, after which the listing is
+	// removed and its bond refunded. Zero if it never expires
+	Expiry int64
+}
+
+// Support used to rank listings
+func (listing Listing) Score() int64 {
+	return listing.Votes + listing.Bond
 }
 
-// Create new Voter for address on each Listing
+// ABCI query path for the highest ranked listings of a registry. The data is
+// an amino encoded TopListingsQuery, the value the amino encoded []Listing.
+const TopListingsPath = "/registry/top-listings"
+
+// Most listings a single top listings query returns
+const MaxTopListings = 100
+
+type TopListingsQuery struct {
+	Registry string
+	Limit int64
+}
+
+// Why a listing was removed from its registry
+type RemovalReason string
+
+const (
+	RemovedLostChallenge RemovalReason = "lost_challenge"
+	RemovedExpired RemovalReason = "expired"
+	RemovedUnderBonded RemovalReason = "under_bonded"
+)
+
+// Listing removed from its registry, kept so clients can tell a delisted
+// identifier from one that was never listed. Approve and Deny are the final
+// tallies of the listing's last poll, PollID is empty if it was never challenged.
+type ArchivedListing struct {
+	Listing Listing
+	Owner sdk.Address
+	Height int64
+	Reason RemovalReason
+	PollID string
+	Approve int64
+	Deny int64
+}
+
+// Entry in a listing's history. Actor is the sender of the msg that caused
+// the event, empty for council decisions and for events of the chain itself
+// such as expiry.
+type HistoryEvent struct {
+	Height int64
+	Action string
+	Actor sdk.Address
+	PollID string
+	Outcome string
+	Amount int64
+}
+
+// Create new Voter for address on each poll of a Listing
 type Voter struct {
 	Owner sdk.Address
 	Identifier string 
+	PollID string
 }
 
-// Vote revealed during reveal phase
+// Vote revealed during reveal phase. Delegate is set on votes cast with
+// delegated power by the delegate's reveal, and nil on direct votes.
 type Vote struct {
 	Choice bool
 	Power int64
+	Delegate sdk.Address
+}
+
+// Prefix of the claim ledger in the reveal store, ahead of the encoded Voter
+var ClaimPrefix = []byte{0x00}
+
+// Prefix of the index of unclaimed reveals in the reveal store, ahead of
+// the voter's address and the ballot identifier
+var PendingClaimPrefix = []byte{0x01}
+
+// Prefix of the index of delegations in the reveal store, ahead of the
+// delegate's address and the delegator's address
+var DelegatePrefix = []byte{0x02}
+
+// Prefix of delegations in the reveal store, ahead of the delegator's address
+var DelegationPrefix = []byte{0x03}
+
+// Voting power an address lends a curator on a registry's polls. The power is
+// staked from the delegator's balance each time the delegate reveals.
+type Delegation struct {
+	Delegator sdk.Address
+	Delegate sdk.Address
+	Power int64
+}
+
+// Ledger entry of a paid claim on one poll. Keeps the vote, since the
+// reveal is pruned once claimed.
+type Claim struct {
+	Vote Vote
+	Paid int64
+	Height int64
 }
 
+// Unit in which a ballot's phase deadlines are measured
+type DeadlineMode string
+
+const (
+	// Deadlines are block heights
+	BlockDeadlines DeadlineMode = "block"
+	// Deadlines are header times in unix seconds
+	TimeDeadlines DeadlineMode = "time"
+)
+
+// Block stamps hold heights or unix times depending on Deadlines
 type Ballot struct {
 	Identifier string
 	Owner sdk.Address
 	Challenger sdk.Address
-	Active bool
+	Status BallotStatus
 	Approve int64
 	Deny int64
 	Bond int64
 	EndApplyBlockStamp int64
 	EndCommitBlockStamp int64
 	EndRevealBlockStamp int64
+	PollID string
+	Deadlines DeadlineMode
+	Reason string
+	Evidence string
+
+	// Appeal state. A challenge's decision can be appealed until
+	// EndAppealBlockStamp, after which its bonds are paid out and the ballot
+	// is settled. Unchallenged listings are settled when applied.
+	Settled bool
+	EndAppealBlockStamp int64
+	Appealed bool
+	Appellant sdk.Address
+	AppealBond int64
+	// Poll superseded by an appeal vote
+	PrevPollID string
+
+	// Voter reward pool set when the ballot is finalized, and how much of it
+	// and of the winning side's voting power has been claimed so far
+	Pool int64
+	PoolPaid int64
+	PowerClaimed int64
+}
+
+// Appeal process for resolved challenges
+type AppealParams struct {
+	// Length of the window after a challenge is resolved. Zero disables appeals
+	AppealLen int64
+	// Appeal bond as a multiple of the candidate bond
+	BondFactor int64
+	// Phase lengths of the appeal vote
+	CommitLen int64
+	RevealLen int64
+	// Share of approving votes an appeal vote needs to accept the listing
+	Quorum Ratio
+	// If set, a majority of the council decides appeals instead of a vote
+	Council []sdk.Address
+}
+
+// Registry used by genesis files and clients that do not name one
+const DefaultRegistryID = "default"
+
+// Maximum length of a registry ID
+const MaxRegistryIDLength = 32
+
+// Registry with its own parameters and listing namespace
+type Registry struct {
+	ID string
+	Creator sdk.Address
+	Params RegistryParams
+}
+
+// Parameters fixed when a registry is created
+type RegistryParams struct {
+	// Denom of every bond posted in the registry
+	Denom string
+	MinDeposit int64
+	ApplyLen int64
+	CommitLen int64
+	RevealLen int64
+	// Share of the losing bond paid to the winning party, the rest goes to voters
+	DispensationPct Ratio
+	// Share of approving votes needed to accept a challenged listing
+	Quorum Ratio
+	Deadlines DeadlineMode
+	Appeal AppealParams
+	Fees FeeParams
+	// Lifetime of a listing in blocks, or seconds for time deadlines. Zero
+	// keeps listings until they are challenged
+	ListingLen int64
+}
+
+// Non-refundable fees, in the registry's denom
+type FeeParams struct {
+	// Charged on every DeclareCandidacyMsg on top of the bond
+	ApplicationFee int64
+	// Charged on every RenewListingMsg
+	RenewalFee int64
+	// Receives the fees. Fees are burned if empty
+	Treasury sdk.Address
+}
+
+func (params RegistryParams) Validate() sdk.Error {
+	if !ValidDenom(params.Denom) {
+		return ErrInvalidParams(fmt.Sprintf("Invalid denom %q", params.Denom))
+	}
+	if params.MinDeposit <= 0 {
+		return ErrInvalidParams("MinDeposit must be positive")
+	}
+	if params.ApplyLen < 0 || params.CommitLen <= 0 || params.RevealLen <= 0 {
+		return ErrInvalidParams("Commit and reveal phases must be positive and the apply phase not negative")
+	}
+	if !params.DispensationPct.Valid() || !params.Quorum.Valid() {
+		return ErrInvalidParams("DispensationPct and Quorum must be between 0 and 1")
+	}
+	if params.Deadlines != BlockDeadlines && params.Deadlines != TimeDeadlines {
+		return ErrInvalidParams(fmt.Sprintf("Deadlines must be %s or %s", BlockDeadlines, TimeDeadlines))
+	}
+	appeal := params.Appeal
+	if appeal.AppealLen < 0 {
+		return ErrInvalidParams("AppealLen must not be negative")
+	}
+	if appeal.AppealLen > 0 {
+		if appeal.BondFactor < 1 {
+			return ErrInvalidParams("Appeal BondFactor must be at least 1")
+		}
+		if len(appeal.Council) == 0 && (appeal.CommitLen <= 0 || appeal.RevealLen <= 0 || !appeal.Quorum.Valid()) {
+			return ErrInvalidParams("Appeal vote needs positive phases and a Quorum between 0 and 1")
+		}
+	}
+	if params.Fees.ApplicationFee < 0 || params.Fees.RenewalFee < 0 {
+		return ErrInvalidParams("Fees must not be negative")
+	}
+	if params.ListingLen < 0 {
+		return ErrInvalidParams("ListingLen must not be negative")
+	}
+	return nil
+}
+
+var denomRegex = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9]{2,15}$")
+
+// Denoms are 3 to 16 letters or digits, starting with a letter. Whether a
+// registry stakes in the denom is checked against its params by the handlers.
+func ValidDenom(denom string) bool {
+	return denomRegex.MatchString(denom)
+}
+
+// Registry IDs are 1 to MaxRegistryIDLength lowercase letters, digits, - or _
+func ValidRegistryID(id string) bool {
+	if len(id) == 0 || len(id) > MaxRegistryIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Prefix of owner's commitments in the commit store, ahead of the encoded
+// Voter. Addresses have a fixed length, so one owner's commitments never
+// contain another's.
+func CommitPrefix(registry string, owner sdk.Address) []byte {
+	return append(RegistryPrefix(registry), owner...)
+}
+
+// Prefix of a listing's events in the history store, which also holds the
+// number of events. The identifier is length-prefixed so one listing's
+// history never contains another's.
+func HistoryPrefix(registry string, identifier string) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(identifier)))
+	prefix := append(RegistryPrefix(registry), length...)
+	return append(prefix, []byte(identifier)...)
+}
+
+// Key of the event at position seq of a listing's history, counting from 0
+func HistoryKey(registry string, identifier string, seq int64) []byte {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, uint64(seq))
+	return append(HistoryPrefix(registry, identifier), bz...)
+}
+
+// Prefix of every key a registry owns in the listing, ballot, commit and
+// reveal stores. The empty ID maps to the whole store.
+func RegistryPrefix(id string) []byte {
+	if id == "" {
+		return nil
+	}
+	return []byte(id + "/")
+}
+
+// Current point in time to compare against the ballot's deadlines
+func (ballot Ballot) Now(ctx sdk.Context) int64 {
+	return ballot.NowAt(ctx.BlockHeight(), ctx.BlockHeader().Time)
+}
+
+// Point in time of the given block in the unit of the ballot's deadlines
+func (ballot Ballot) NowAt(height int64, blockTime int64) int64 {
+	if ballot.Deadlines == TimeDeadlines {
+		return blockTime
+	}
+	return height
 }
\ No newline at end of file
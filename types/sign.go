@@ -0,0 +1,55 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+)
+
+// Document every signer of a tx signs over. Binding the chain ID, the signers'
+// sequences and the fee to the msg keeps a signed msg from being replayed on
+// another chain or resubmitted on this one.
+type StdSignDoc struct {
+	ChainID   string          `json:"chain_id"`
+	Sequences []int64         `json:"sequences"`
+	Fee       json.RawMessage `json:"fee"`
+	Msg       json.RawMessage `json:"msg"`
+}
+
+// Bytes signed by each signer of a tx. Shared by the ante handler and tcrcli.
+func StdSignBytes(chainID string, sequences []int64, fee auth.StdFee, msg sdk.Msg) []byte {
+	feeBytes, err := json.Marshal(fee)
+	if err != nil {
+		panic(err)
+	}
+	doc := StdSignDoc{
+		ChainID:   chainID,
+		Sequences: sequences,
+		Fee:       MustSortJSON(feeBytes),
+		Msg:       msg.GetSignBytes(),
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	return MustSortJSON(b)
+}
+
+// Re-encode json with object keys in sorted order so that sign bytes do not
+// depend on struct field order
+func MustSortJSON(bz []byte) []byte {
+	var c interface{}
+	dec := json.NewDecoder(bytes.NewReader(bz))
+	// Keep int64 amounts exact instead of decoding them to float64
+	dec.UseNumber()
+	err := dec.Decode(&c)
+	if err != nil {
+		panic(err)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+)
+
+func TestSortJSON(t *testing.T) {
+	sorted := MustSortJSON([]byte(`{"b":1,"a":{"d":9223372036854775807,"c":"x"}}`))
+
+	assert.Equal(t, `{"a":{"c":"x","d":9223372036854775807},"b":1}`, string(sorted), "JSON keys not sorted or amounts not kept exact")
+}
+
+func TestSignBytes(t *testing.T) {
+	msg := GenerateCandidacyMsg()
+
+	bz := StdSignBytes("registry-chain", []int64{0}, auth.StdFee{}, msg)
+
+	assert.Equal(t, bz, StdSignBytes("registry-chain", []int64{0}, auth.StdFee{}, msg), "Sign bytes not deterministic")
+	assert.NotEqual(t, bz, StdSignBytes("other-chain", []int64{0}, auth.StdFee{}, msg), "Sign bytes do not depend on chain ID")
+	assert.NotEqual(t, bz, StdSignBytes("registry-chain", []int64{1}, auth.StdFee{}, msg), "Sign bytes do not depend on sequence")
+	assert.NotEqual(t, bz, StdSignBytes("registry-chain", []int64{0}, auth.StdFee{Gas: 10}, msg), "Sign bytes do not depend on fee")
+}
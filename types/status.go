@@ -0,0 +1,136 @@
+package types
+
+import (
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Stage of a ballot. A challenge moves from commit to reveal by itself when
+// its commit phase ends, every other move is made through Transition.
+type BallotStatus string
+
+const (
+	// Candidate in its application phase, or past it and not applied yet
+	StatusApplying BallotStatus = "applying"
+	// Challenged, votes are being committed
+	StatusCommit BallotStatus = "commit"
+	// Challenged, votes are being revealed or wait to be tallied
+	StatusReveal BallotStatus = "reveal"
+	// Appealed to the council, waiting for its decision
+	StatusCouncil BallotStatus = "council"
+	// Listed, either unchallenged or by decision of its last poll
+	StatusAccepted BallotStatus = "accepted"
+	// Rejected by decision of its last poll
+	StatusRejected BallotStatus = "rejected"
+	// Removed without losing a challenge: its listing expired, or it was
+	// challenged while bonded below the minimum. Its bond is refunded and the
+	// identifier can be declared again.
+	StatusRemoved BallotStatus = "removed"
+)
+
+// Status a decision moves the ballot to
+func DecisionStatus(accepted bool) BallotStatus {
+	if accepted {
+		return StatusAccepted
+	}
+	return StatusRejected
+}
+
+// Status of the ballot at now. Phases are half-open: a phase ending at t is
+// over at t.
+func (ballot Ballot) StatusAt(now int64) BallotStatus {
+	if ballot.Status == StatusCommit && now >= ballot.EndCommitBlockStamp {
+		return StatusReveal
+	}
+	return ballot.Status
+}
+
+// Whether the ballot's last decision, or its application, accepted the listing
+func (ballot Ballot) Accepted() bool {
+	return ballot.Status == StatusAccepted
+}
+
+// Whether the ballot has been decided, by a poll, the council or an unchallenged application
+func (ballot Ballot) Decided() bool {
+	return ballot.Status == StatusAccepted || ballot.Status == StatusRejected
+}
+
+// Whether a challenge on the ballot is still open: being voted on, waiting
+// for the council, or decided with bonds locked for the appeal window
+func (ballot Ballot) Challenged() bool {
+	switch ballot.Status {
+	case StatusCommit, StatusReveal, StatusCouncil:
+		return true
+	case StatusAccepted, StatusRejected:
+		return !ballot.Settled
+	}
+	return false
+}
+
+// Whether votes can be committed at now
+func (ballot Ballot) CommitOpen(now int64) bool {
+	return ballot.StatusAt(now) == StatusCommit
+}
+
+// Whether votes can be revealed at now
+func (ballot Ballot) RevealOpen(now int64) bool {
+	return ballot.StatusAt(now) == StatusReveal && now < ballot.EndRevealBlockStamp
+}
+
+// Whether the decision of the ballot's challenge can be appealed at now
+func (ballot Ballot) Appealable(now int64) bool {
+	return ballot.Decided() && !ballot.Settled && !ballot.Appealed && now < ballot.EndAppealBlockStamp
+}
+
+// Move the ballot to next at now. Every status change of a ballot goes
+// through here, so these are the only moves it can make:
+//
+//	applying           -> accepted           applied after the application phase
+//	applying           -> commit             challenged
+//	applying           -> removed            challenged while under-bonded
+//	accepted           -> commit             challenge of a settled listing
+//	accepted           -> removed            expired, or challenged while under-bonded
+//	reveal             -> accepted, rejected tallied after the reveal phase
+//	accepted, rejected -> commit, council    appealed within the appeal window
+//	council            -> accepted, rejected decided by the council
+func (ballot *Ballot) Transition(next BallotStatus, now int64) sdk.Error {
+	current := ballot.StatusAt(now)
+	decision := next == StatusAccepted || next == StatusRejected
+
+	legal := false
+	switch current {
+	case StatusApplying:
+		if next == StatusAccepted && now < ballot.EndApplyBlockStamp {
+			return ErrApplyPhaseNotEnded("")
+		}
+		legal = next == StatusAccepted || next == StatusCommit || next == StatusRemoved
+	case StatusCommit:
+		if decision {
+			return ErrRevealPhaseNotEnded("")
+		}
+	case StatusReveal:
+		if decision && now < ballot.EndRevealBlockStamp {
+			return ErrRevealPhaseNotEnded("")
+		}
+		legal = decision
+	case StatusAccepted, StatusRejected:
+		if !ballot.Settled {
+			legal = (next == StatusCommit || next == StatusCouncil) && ballot.Appealable(now)
+		} else {
+			legal = current == StatusAccepted && (next == StatusCommit || next == StatusRemoved)
+		}
+	case StatusCouncil:
+		legal = decision
+	case StatusRemoved:
+		if next == StatusCommit {
+			return ErrNotListed("")
+		}
+	}
+
+	if !legal {
+		return ErrInvalidTransition(fmt.Sprintf("Ballot cannot move from %s to %s", current, next))
+	}
+	ballot.Status = next
+	return nil
+}
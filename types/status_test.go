@@ -0,0 +1,87 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBallotTransition(t *testing.T) {
+	ballot := Ballot{
+		Status: StatusApplying,
+		EndApplyBlockStamp: 10,
+	}
+
+	err := ballot.Transition(StatusAccepted, 9)
+	assert.Equal(t, CodeApplyPhaseNotEnded, err.Code(), "Applied during application phase")
+	assert.Nil(t, ballot.Transition(StatusAccepted, 10))
+	ballot.Settled = true
+
+	// Applied listings cannot be applied again
+	err = ballot.Transition(StatusAccepted, 11)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Settled ballot applied twice")
+
+	ballot.EndCommitBlockStamp = 20
+	ballot.EndRevealBlockStamp = 30
+	assert.Nil(t, ballot.Transition(StatusCommit, 11))
+	ballot.PollID = "poll"
+	ballot.Settled = false
+
+	// Commit phase ends by itself
+	assert.True(t, ballot.CommitOpen(19))
+	assert.False(t, ballot.RevealOpen(19))
+	assert.Equal(t, StatusReveal, ballot.StatusAt(20))
+	assert.True(t, ballot.RevealOpen(29))
+	assert.False(t, ballot.RevealOpen(30))
+
+	err = ballot.Transition(StatusRejected, 15)
+	assert.Equal(t, CodeRevealPhaseNotEnded, err.Code(), "Decided during commit phase")
+	err = ballot.Transition(StatusRejected, 29)
+	assert.Equal(t, CodeRevealPhaseNotEnded, err.Code(), "Decided during reveal phase")
+	err = ballot.Transition(StatusCouncil, 30)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Sent to council without appeal")
+	assert.Nil(t, ballot.Transition(StatusRejected, 30))
+	assert.True(t, ballot.Challenged(), "Bonds released before appeal window closed")
+
+	// Appeals only within the window
+	ballot.EndAppealBlockStamp = 40
+	err = ballot.Transition(StatusCouncil, 40)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Appealed after appeal window")
+	assert.Nil(t, ballot.Transition(StatusCouncil, 39))
+	ballot.Appealed = true
+
+	err = ballot.Transition(StatusCommit, 39)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Council ballot reopened")
+	assert.Nil(t, ballot.Transition(StatusAccepted, 39))
+	ballot.Settled = true
+	assert.False(t, ballot.Challenged(), "Settled ballot still challenged")
+
+	// Listings that survived a challenge can be challenged again
+	rechallenged := ballot
+	assert.Nil(t, rechallenged.Transition(StatusCommit, 50), "Surviving listing not challenged again")
+	rejected := Ballot{Status: StatusRejected, Settled: true, PollID: "poll"}
+	err = rejected.Transition(StatusCommit, 50)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Rejected listing challenged")
+
+	// Removed listings cannot be challenged or brought back
+	assert.Nil(t, ballot.Transition(StatusRemoved, 50))
+	err = ballot.Transition(StatusCommit, 51)
+	assert.Equal(t, CodeNotListed, err.Code(), "Removed listing challenged")
+	err = ballot.Transition(StatusAccepted, 51)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Removed listing accepted")
+	assert.False(t, ballot.Challenged())
+}
+
+func TestRemoveBallot(t *testing.T) {
+	applying := Ballot{Status: StatusApplying, EndApplyBlockStamp: 10}
+	assert.Nil(t, applying.Transition(StatusRemoved, 5), "Under-bonded candidate not removed")
+
+	rejected := Ballot{Status: StatusRejected, Settled: true, PollID: "poll"}
+	err := rejected.Transition(StatusRemoved, 5)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Rejected listing removed")
+
+	// Listings in their appeal window wait for it
+	accepted := Ballot{Status: StatusAccepted, PollID: "poll", EndAppealBlockStamp: 10}
+	err = accepted.Transition(StatusRemoved, 5)
+	assert.Equal(t, CodeInvalidTransition, err.Code(), "Listing removed in its appeal window")
+}
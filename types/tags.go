@@ -0,0 +1,56 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Keys of the tags returned by registry handlers, indexed by the node for tx search
+const (
+	TagAction     = "action"
+	TagListing    = "listing"
+	TagOwner      = "owner"
+	TagChallenger = "challenger"
+	TagVoter      = "voter"
+	TagPoll       = "poll"
+	TagOutcome    = "outcome"
+	TagAmount     = "amount"
+	TagRegistry   = "registry"
+	TagDelegate   = "delegate"
+	TagDelegated  = "delegated"
+	TagFee        = "fee"
+	TagTreasury   = "treasury"
+	TagExpiry     = "expiry"
+	TagRefund     = "refund"
+)
+
+// Values of the outcome tag
+const (
+	OutcomeListed      = "listed"
+	OutcomeAccepted    = "accepted"
+	OutcomeRejected    = "rejected"
+	OutcomeUnderBonded = "under_bonded"
+	OutcomeRefunded    = "refunded"
+	OutcomeRewarded    = "rewarded"
+	OutcomeExpired     = "expired"
+)
+
+// Value of the treasury tag when fees are burned
+const TreasuryBurned = "burned"
+
+// Identifier of the poll opened by challenging a listing at the given height.
+// A listing can be challenged at most once per block, so the pair is unique.
+func NewPollID(identifier string, height int64) string {
+	return fmt.Sprintf("%s/%d", identifier, height)
+}
+
+// Identifier the store migration gives the one poll a listing of the first
+// release could have. No block has height 0, so no poll opened since has it.
+func FirstReleasePollID(identifier string) string {
+	return NewPollID(identifier, 0)
+}
+
+// Tag value of a coin amount
+func AmountTag(amount int64) []byte {
+	return []byte(strconv.FormatInt(amount, 10))
+}
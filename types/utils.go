@@ -7,12 +7,13 @@ import (
 
 func GenerateCandidacyMsg() DeclareCandidacyMsg {
 	coin := sdk.Coin{
-		Denom: "RegistryCoin",
+		Denom: DefaultDenom,
 		Amount: 5000,
 	}
 
 	return DeclareCandidacyMsg{
 		Owner: crypto.GenPrivKeyEd25519().PubKey().Address(),
+		Registry: DefaultRegistryID,
 		Identifier: "Unique registry listing",
 		Bond: coin,
 	}